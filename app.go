@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,12 +27,41 @@ func RunUSBMode(configPath string, silent bool) {
 }
 
 type App struct {
-	config           *Config
-	sessionManager   *SessionManager
+	config            *Config
+	sessionManager    *SessionManager
 	screenshotCapture *ScreenshotCapture
-	analyzer         *AIAnalyzer
-	isRunning        bool
-	stopChan         chan bool
+	pipeline          *CapturePipeline // Stages frames of the running session
+	outbox            *UploadOutbox    // Nil when no upload sink is configured
+	sinks             []*uploadSink    // Every configured sink, for heartbeats
+	webapp            *WebappClient    // Nil when no webapp sink is configured
+	webappSink        string           // Name of the webapp's sink in the upload queue
+	analyzer          *AIAnalyzer
+	captureNow        chan struct{}
+	policyChange      chan CapturePolicy // Replaces the running session's capture policy
+
+	// Capture loop state, shared by the loop, hotkeys, remote commands
+	// and heartbeats. stopChan is closed once to stop the loop, which
+	// closes loopDone when it returns.
+	mu        sync.Mutex
+	isRunning bool
+	paused    bool // Last pause state seen by the capture loop
+	stopChan  chan struct{}
+	stopOnce  *sync.Once
+	loopDone  chan struct{}
+	stopMu    sync.Mutex // Serializes stopping, so only one stop closes the session
+
+	// Remote commands
+	commandStop    chan struct{}
+	commandWG      sync.WaitGroup
+	remoteStopped  chan struct{}
+	remoteStopOnce sync.Once
+
+	// Heartbeat
+	health        captureHealth
+	ffmpegOnce    sync.Once
+	ffmpeg        bool
+	heartbeatStop chan struct{}
+	heartbeatWG   sync.WaitGroup
 }
 
 func NewApp(configPath string) (*App, error) {
@@ -41,47 +72,147 @@ func NewApp(configPath string) (*App, error) {
 	}
 
 	// Initialize session manager
-	sessionManager, err := NewSessionManager(config.DataDir)
+	sessionManager, err := NewSessionManager(config.DataDir, config.DeviceUUID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session manager: %w", err)
 	}
 
 	// Check for existing active session
-	activeSession, err := sessionManager.GetActiveSession()
+	activeSession, err := sessionManager.FindActiveSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to check for active session: %w", err)
 	}
 
-	if activeSession != nil {
+	if activeSession != nil && activeSession.Interrupted(time.Now()) {
+		fmt.Printf("Found interrupted session (ID: %d, last activity: %s)\n",
+			activeSession.ID, activeSession.lastActivity().Format("2006-01-02 15:04:05"))
+	} else if activeSession != nil {
 		fmt.Printf("Found existing active session (ID: %d, started: %s)\n",
 			activeSession.ID, activeSession.StartTime.Format("2006-01-02 15:04:05"))
 	}
 
 	// Initialize screenshot capture
-	screenshotCapture := NewScreenshotCapture("", config.WebappURL)
+	screenshotCapture := NewScreenshotCapture("", config.ScreenshotSettings)
+	screenshotCapture.SetRedactions(config.Redactions)
+	screenshotCapture.SetThumbnailSettings(config.ThumbnailSettings)
+	if config.CaptureSettings.RecordWindowInfo {
+		screenshotCapture.SetWindowInspector(NewWindowInspector(config.CaptureSettings.XDisplay))
+	}
 
 	// Initialize AI analyzer
 	analyzer := NewAIAnalyzer(config.OpenAIAPIKey)
 
+	// Screenshots are queued in the database and uploaded by the outbox
+	sinks, webapp, err := NewUploadSinks(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upload sinks: %w", err)
+	}
+	var outbox *UploadOutbox
+	if len(sinks) > 0 {
+		outbox = NewUploadOutbox(sessionManager.db, sinks, config.UploadOutbox)
+		for _, sink := range sinks {
+			if sink.accepts(sinkScreenshots) {
+				screenshotCapture.SetUploadRendition(config.UploadRendition)
+				break
+			}
+		}
+	}
+
 	app := &App{
 		config:            config,
+		outbox:            outbox,
+		sinks:             sinks,
 		sessionManager:    sessionManager,
 		screenshotCapture: screenshotCapture,
 		analyzer:          analyzer,
-		stopChan:          make(chan bool),
+		captureNow:        make(chan struct{}, 1),
+		policyChange:      make(chan CapturePolicy, 1),
+		remoteStopped:     make(chan struct{}),
+	}
+	if webapp != nil {
+		app.webapp = webapp.UploadSink.(*WebappClient)
+		app.webappSink = webapp.name
+		outbox.updateSent = app.updateSent
 	}
 
 	return app, nil
 }
 
+const defaultSessionDescription = "Screenshot capture session"
+
 func (app *App) StartSession(intervalSeconds int, studentName string) error {
-	// Start new session
-	session, err := app.sessionManager.StartSession("Screenshot capture session", studentName)
+	return app.StartSessionWithDescription(intervalSeconds, studentName, defaultSessionDescription)
+}
+
+// StartSessionWithDescription starts a new session and runs the capture
+// loop until the session is stopped
+func (app *App) StartSessionWithDescription(intervalSeconds int, studentName, description string) error {
+	session, policy, err := app.beginSession(intervalSeconds, studentName, description)
 	if err != nil {
 		return err
 	}
 
+	app.runCaptureLoop(session.ID, policy)
+	return nil
+}
+
+// beginSession creates the session and prepares capture without taking
+// any screenshots, so callers can run the capture loop in the background
+func (app *App) beginSession(intervalSeconds int, studentName, description string) (*Session, CapturePolicy, error) {
+	source, policy, pipeline, err := app.newCapture(intervalSeconds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// StartSession would close a session left active by a process that
+	// died too; closing it here also tells the upload sinks
+	if err := app.CloseInterruptedSession(); err != nil {
+		return nil, nil, err
+	}
+
+	// Start new session
+	session, err := app.sessionManager.StartSession(description, studentName)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	fmt.Printf("Started session ID: %d\n", session.ID)
+	started := sessionStartedUpdate(session)
+	started.DeviceID = app.deviceID()
+	app.reportSession(session.ID, started)
+
+	if err := app.attachCapture(session, source, policy, pipeline); err != nil {
+		return nil, nil, err
+	}
+	return session, policy, nil
+}
+
+// newCapture picks the capture backend and policy from config and builds
+// the pipeline, before any session is touched
+func (app *App) newCapture(intervalSeconds int) (CaptureSource, CapturePolicy, *CapturePipeline, error) {
+	source, err := NewCaptureSource(app.config.CaptureSettings)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create capture source: %w", err)
+	}
+	policy, err := NewCapturePolicy(app.config.CapturePolicy, intervalSeconds)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create capture policy: %w", err)
+	}
+	var upload func(CapturedFrame, string) error
+	if app.outbox != nil {
+		upload = app.queueUpload
+	}
+	pipeline, err := NewCapturePipeline(app.screenshotCapture, app.config.Pipeline, app.recordFrame, upload, app.health.record)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create capture pipeline: %w", err)
+	}
+	return source, policy, pipeline, nil
+}
+
+// attachCapture points capture at the session's folder and marks the app
+// running, ready for runCaptureLoop
+func (app *App) attachCapture(session *Session, source CaptureSource, policy CapturePolicy, pipeline *CapturePipeline) error {
+	app.screenshotCapture.SetSource(source)
 
 	// Set up screenshot capture directory
 	sessionDir := app.sessionManager.GetSessionScreenshotDir(session.ID)
@@ -90,83 +221,435 @@ func (app *App) StartSession(intervalSeconds int, studentName string) error {
 	if err := app.screenshotCapture.Initialize(); err != nil {
 		return err
 	}
+	app.pipeline = pipeline
+	app.pipeline.Start()
 
 	// Print display information
 	fmt.Println(app.screenshotCapture.GetDisplayInfo())
 
-	// Start capture loop
+	app.recordCapturePolicy(session.ID, policy)
+
+	// A remote stop only ends the session it was sent to
+	app.remoteStopped = make(chan struct{})
+	app.remoteStopOnce = sync.Once{}
+
+	app.mu.Lock()
 	app.isRunning = true
-	ticker := time.NewTicker(time.Duration(intervalSeconds) * time.Second)
-	defer ticker.Stop()
+	app.paused = false
+	app.stopChan = make(chan struct{})
+	app.stopOnce = &sync.Once{}
+	app.loopDone = make(chan struct{})
+	app.mu.Unlock()
+	return nil
+}
+
+// running reports whether a capture loop is running in this process
+func (app *App) running() bool {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	return app.isRunning
+}
+
+// stopCaptureLoop stops the capture loop and waits for it to return. Only
+// the first of several concurrent calls gets true; it owns closing the
+// session.
+func (app *App) stopCaptureLoop() bool {
+	app.mu.Lock()
+	if !app.isRunning {
+		app.mu.Unlock()
+		return false
+	}
+	app.isRunning = false
+	stop, once, done := app.stopChan, app.stopOnce, app.loopDone
+	app.mu.Unlock()
+
+	once.Do(func() { close(stop) })
+	<-done
+	return true
+}
+
+// recordCapturePolicy stores the policy a session captures with
+func (app *App) recordCapturePolicy(sessionID int, policy CapturePolicy) {
+	params, _ := json.Marshal(policy.Params())
+	if err := app.sessionManager.SetCapturePolicy(sessionID, policy.Name(), string(params)); err != nil {
+		fmt.Printf("Warning: Failed to record capture policy: %v\n", err)
+	}
+	fmt.Printf("Capture policy: %s %s\n", policy.Name(), params)
+}
+
+// runCaptureLoop takes screenshots according to the policy until the
+// session is stopped
+func (app *App) runCaptureLoop(sessionID int, policy CapturePolicy) {
+	app.mu.Lock()
+	stop, done := app.stopChan, app.loopDone
+	app.mu.Unlock()
+	defer close(done)
+	defer app.keepSessionAlive(sessionID)()
 
 	// Take initial screenshot
-	if err := app.takeScreenshotForSession(session.ID); err != nil {
-		fmt.Printf("Error taking screenshot: %v\n", err)
+	app.captureTick(sessionID)
+
+	for {
+		if !app.waitForNextCapture(sessionID, &policy, stop) {
+			return
+		}
+		app.captureTick(sessionID)
 	}
+}
 
-	for app.isRunning {
-		select {
-		case <-ticker.C:
-			if err := app.takeScreenshotForSession(session.ID); err != nil {
-				fmt.Printf("Error taking screenshot: %v\n", err)
-			}
-		case <-app.stopChan:
-			app.isRunning = false
+// captureTick takes one capture unless the session is paused. Pause state
+// is read from the database so pauses from another process are honored.
+func (app *App) captureTick(sessionID int) {
+	paused, err := app.sessionManager.IsPaused(sessionID)
+	if err != nil {
+		fmt.Printf("Error checking pause state: %v\n", err)
+	}
+	app.mu.Lock()
+	changed := paused != app.paused
+	app.paused = paused
+	app.mu.Unlock()
+	if changed {
+		if paused {
+			fmt.Println("Session paused, skipping captures")
+		} else {
+			fmt.Println("Session resumed")
 		}
 	}
+	if paused {
+		return
+	}
+
+	// A successful grab is only counted once the recorder stores it
+	if err := app.takeScreenshotForSession(sessionID); err != nil {
+		fmt.Printf("Error taking screenshot: %v\n", err)
+		app.health.record(err)
+	}
+}
+
+// PauseSession pauses capture on the active session, recording why
+func (app *App) PauseSession(reason string) error {
+	activeSession, err := app.sessionManager.FindActiveSession()
+	if err != nil {
+		return fmt.Errorf("failed to get active session: %w", err)
+	}
+	if activeSession == nil {
+		return fmt.Errorf("no active session found")
+	}
 
+	if err := app.sessionManager.PauseSession(activeSession.ID, reason); err != nil {
+		return err
+	}
+	fmt.Printf("Session %d paused\n", activeSession.ID)
 	return nil
 }
 
-func (app *App) takeScreenshot() error {
-	filePath, err := app.screenshotCapture.CaptureScreen()
+// TogglePause pauses the active session, or resumes it if it is paused
+func (app *App) TogglePause(reason string) error {
+	activeSession, err := app.sessionManager.FindActiveSession()
+	if err != nil {
+		return fmt.Errorf("failed to get active session: %w", err)
+	}
+	if activeSession == nil {
+		return fmt.Errorf("no active session found")
+	}
+
+	paused, err := app.sessionManager.IsPaused(activeSession.ID)
 	if err != nil {
 		return err
 	}
+	if paused {
+		return app.ResumeSession()
+	}
+	return app.PauseSession(reason)
+}
+
+// CaptureNow asks the capture loop to take a screenshot without waiting
+// for the next scheduled one
+func (app *App) CaptureNow() {
+	select {
+	case app.captureNow <- struct{}{}:
+	default: // A capture is already pending
+	}
+}
 
-	if err := app.sessionManager.RecordScreenshot(filePath); err != nil {
+// SetInterval rebuilds the running session's capture policy around a new
+// base interval; the current wait restarts with it
+func (app *App) SetInterval(seconds int) error {
+	if seconds < 1 {
+		return fmt.Errorf("interval must be at least 1 second")
+	}
+	if !app.running() {
+		return fmt.Errorf("no session is running")
+	}
+	policy, err := NewCapturePolicy(app.config.CapturePolicy, seconds)
+	if err != nil {
 		return err
 	}
+	select {
+	case <-app.policyChange: // Superseded before the loop took it
+	default:
+	}
+	app.policyChange <- policy
+	return nil
+}
+
+// SetStudentName renames the student of the active session and tells the
+// upload sinks
+func (app *App) SetStudentName(name string) error {
+	if name == "" {
+		return fmt.Errorf("student name cannot be empty")
+	}
+	activeSession, err := app.sessionManager.FindActiveSession()
+	if err != nil {
+		return fmt.Errorf("failed to get active session: %w", err)
+	}
+	if activeSession == nil {
+		return fmt.Errorf("no active session found")
+	}
 
-	fmt.Printf("Screenshot saved: %s\n", filepath.Base(filePath))
+	if err := app.sessionManager.setStudentName(activeSession.ID, name); err != nil {
+		return err
+	}
+	app.reportSession(activeSession.ID, SessionUpdate{SessionID: activeSession.GlobalID(), StudentName: name})
+	fmt.Printf("Session %d student name set to %s\n", activeSession.ID, name)
 	return nil
 }
 
-func (app *App) takeScreenshotForSession(sessionID int) error {
-	// Use a globally unique session ID: timestamp-based
-	globalSessionID := fmt.Sprintf("%d_%d", sessionID, time.Now().Unix())
+// AddBookmark records a note against the active session
+func (app *App) AddBookmark(note string) error {
+	activeSession, err := app.sessionManager.FindActiveSession()
+	if err != nil {
+		return fmt.Errorf("failed to get active session: %w", err)
+	}
+	if activeSession == nil {
+		return fmt.Errorf("no active session found")
+	}
+
+	return app.sessionManager.AddSessionEvent(activeSession.ID, eventKindBookmark, note)
+}
 
-	// For existing sessions, use the session ID from start time
-	if app.sessionManager.currentSession != nil {
-		globalSessionID = fmt.Sprintf("%d_%d", sessionID, app.sessionManager.currentSession.StartTime.Unix())
+// ResumeSession resumes capture on the active session
+func (app *App) ResumeSession() error {
+	activeSession, err := app.sessionManager.FindActiveSession()
+	if err != nil {
+		return fmt.Errorf("failed to get active session: %w", err)
+	}
+	if activeSession == nil {
+		return fmt.Errorf("no active session found")
+	}
+
+	if err := app.sessionManager.ResumeSession(activeSession.ID); err != nil {
+		return err
 	}
+	fmt.Printf("Session %d resumed\n", activeSession.ID)
+	return nil
+}
 
-	filePath, err := app.screenshotCapture.CaptureScreenForSession(globalSessionID)
+// StartSessionInBackground starts a session and returns once it is
+// running, leaving the capture loop on its own goroutine
+func (app *App) StartSessionInBackground(intervalSeconds int, studentName, description string) error {
+	session, policy, err := app.beginSession(intervalSeconds, studentName, description)
 	if err != nil {
 		return err
 	}
 
-	if err := app.sessionManager.RecordScreenshot(filePath); err != nil {
+	go app.runCaptureLoop(session.ID, policy)
+	return nil
+}
+
+// StartScheduledSession starts a background session for the Scheduler
+func (app *App) StartScheduledSession(intervalSeconds int, studentName, description string) error {
+	return app.StartSessionInBackground(intervalSeconds, studentName, description)
+}
+
+// StopScheduledSession ends the scheduled session with the normal summary
+// pipeline
+func (app *App) StopScheduledSession() error {
+	return app.StopSessionAndSummarize()
+}
+
+// waitForNextCapture blocks until the policy says to capture, returning
+// false if stop was closed while waiting. A policy sent by SetInterval
+// replaces *policy and restarts the wait.
+func (app *App) waitForNextCapture(sessionID int, policy *CapturePolicy, stop <-chan struct{}) bool {
+	timer := time.NewTimer((*policy).NextDelay(app.screenshotCapture.LastChangeScore()))
+	defer timer.Stop()
+
+	var probe <-chan time.Time
+	if interval := (*policy).ProbeInterval(); interval > 0 {
+		probeTicker := time.NewTicker(interval)
+		defer probeTicker.Stop()
+		probe = probeTicker.C
+	}
+
+	for {
+		select {
+		case <-timer.C:
+			// The last probe frame is stale by now
+			app.screenshotCapture.DiscardProbe()
+			return true
+		case <-app.captureNow:
+			app.screenshotCapture.DiscardProbe()
+			return true
+		case <-probe:
+			score, err := app.screenshotCapture.Probe()
+			if err != nil {
+				fmt.Printf("Error probing screen: %v\n", err)
+				continue
+			}
+			if (*policy).Triggered(score) {
+				// The capture reuses the probed frame
+				fmt.Printf("Screen changed (score %.3f), capturing now\n", score)
+				return true
+			}
+		case next := <-app.policyChange:
+			*policy = next
+			app.recordCapturePolicy(sessionID, next)
+			return app.waitForNextCapture(sessionID, policy, stop)
+		case <-stop:
+			return false
+		}
+	}
+}
+
+func (app *App) takeScreenshot() error {
+	frames, err := app.screenshotCapture.CaptureScreen()
+	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Screenshot saved: %s\n", filepath.Base(filePath))
+	return app.recordFrames(frames)
+}
+
+func (app *App) takeScreenshotForSession(sessionID int) error {
+	session := app.sessionManager.GetCurrentSession()
+	if session == nil || session.ID != sessionID {
+		var err error
+		if session, err = app.sessionManager.GetSessionByID(sessionID); err != nil {
+			return fmt.Errorf("failed to load session %d: %w", sessionID, err)
+		}
+	}
+
+	// Encoding, saving, uploading and recording happen off this goroutine
+	return app.pipeline.Capture(session.GlobalID())
+}
+
+func (app *App) recordFrames(frames []CapturedFrame) error {
+	for _, frame := range frames {
+		if err := app.recordFrame(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordFrame stores a saved frame in the database; it is the pipeline's
+// last stage
+func (app *App) recordFrame(frame CapturedFrame) error {
+	if err := app.sessionManager.RecordScreenshot(frame); err != nil {
+		return err
+	}
+	app.health.record(nil)
+	if frame.Duplicate {
+		fmt.Printf("Screen unchanged, reusing: %s\n", filepath.Base(frame.FilePath))
+		return nil
+	}
+	fmt.Printf("Screenshot saved: %s\n", filepath.Base(frame.FilePath))
 	return nil
 }
 
+// queueUpload adds a saved frame to the upload outbox
+func (app *App) queueUpload(frame CapturedFrame, globalSessionID string) error {
+	session := app.sessionManager.GetCurrentSession()
+	if session == nil {
+		return fmt.Errorf("no active session")
+	}
+	return app.outbox.Enqueue(session.ID, globalSessionID, frame)
+}
+
+// reportSession queues session details for the upload sinks. It does
+// nothing when none are configured.
+func (app *App) reportSession(sessionID int, update SessionUpdate) {
+	if app.outbox == nil {
+		return
+	}
+	if err := app.outbox.EnqueueUpdate(sessionID, update); err != nil {
+		fmt.Printf("Warning: Failed to queue session update: %v\n", err)
+	}
+}
+
+// reportStopped tells the upload sinks the session is complete
+func (app *App) reportStopped(session *Session) {
+	if app.outbox == nil {
+		return
+	}
+	pauses, err := app.sessionManager.GetSessionPauses(session.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load pauses: %v\n", err)
+	}
+	app.reportSession(session.ID, sessionStoppedUpdate(session, pauses))
+}
+
+// publishSummary stores a generated summary on the session and sends it to
+// the upload sinks
+func (app *App) publishSummary(session *Session, summary string) {
+	if err := app.sessionManager.SetSummary(session.ID, summary); err != nil {
+		fmt.Printf("Warning: Failed to store summary: %v\n", err)
+	}
+	session.Summary = summary
+	app.reportSession(session.ID, SessionUpdate{SessionID: session.GlobalID(), Summary: summary})
+}
+
+// StartUploads starts sending queued screenshots, including any left over
+// from earlier runs. It does nothing when no upload sink is configured.
+func (app *App) StartUploads() {
+	if app.outbox != nil {
+		app.outbox.Start()
+	}
+}
+
+// UploadQueueDepth returns the number of screenshots waiting to upload and
+// the number given up on
+func (app *App) UploadQueueDepth() (pending, dead int, err error) {
+	if app.outbox == nil {
+		return 0, 0, nil
+	}
+	return app.outbox.Depth()
+}
+
+// drainPipeline waits for frames still in flight to be saved and
+// recorded. Call it after the capture loop has stopped and before the
+// session is closed.
+func (app *App) drainPipeline() {
+	if app.pipeline == nil {
+		return
+	}
+	app.pipeline.Close()
+	fmt.Println(app.pipeline.Metrics())
+	app.pipeline = nil
+}
+
 func (app *App) StopSession() {
-	if app.isRunning {
-		app.stopChan <- true
+	app.stopMu.Lock()
+	defer app.stopMu.Unlock()
+
+	if app.stopCaptureLoop() {
+		app.drainPipeline()
 
 		if err := app.sessionManager.StopSession(); err != nil {
 			fmt.Printf("Error stopping session: %v\n", err)
 		} else {
 			fmt.Println("Session stopped successfully")
+			app.reportStopped(app.sessionManager.GetCurrentSession())
 		}
 	}
 }
 
 func (app *App) StopSessionAndSummarize() error {
+	// A concurrent stop finishes first; this one then finds no session
+	app.stopMu.Lock()
+	defer app.stopMu.Unlock()
+
 	// Check for active session
 	activeSession, err := app.sessionManager.GetActiveSession()
 	if err != nil {
@@ -177,19 +660,33 @@ func (app *App) StopSessionAndSummarize() error {
 		return fmt.Errorf("no active session found")
 	}
 
-	// Stop the capture loop
-	if app.isRunning {
-		app.stopChan <- true
-	}
+	// Nothing is capturing an interrupted session, so it ends where its
+	// activity stopped rather than now
+	interrupted := !app.running() && activeSession.Interrupted(time.Now())
+
+	// Stop the capture loop and let queued frames finish
+	app.stopCaptureLoop()
+	app.drainPipeline()
 
 	// Stop the session in database
-	if err := app.sessionManager.StopSession(); err != nil {
+	if interrupted {
+		err = app.sessionManager.closeInterruptedSession(activeSession)
+	} else {
+		err = app.sessionManager.StopSession()
+	}
+	if err != nil {
 		return fmt.Errorf("failed to stop session: %w", err)
 	}
+	app.reportStopped(activeSession)
+
+	pauses, err := app.sessionManager.GetSessionPauses(activeSession.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load pauses: %v\n", err)
+	}
 
-	fmt.Printf("Session %d stopped. Duration: %s\n",
+	fmt.Printf("Session %d stopped. Active time: %s\n",
 		activeSession.ID,
-		time.Since(activeSession.StartTime).Round(time.Second))
+		activeDuration(activeSession.StartTime, activeSession.EndTime, pauses).Round(time.Second))
 
 	// Get screenshots for analysis
 	screenshots, err := app.sessionManager.GetSessionScreenshots(activeSession.ID)
@@ -217,6 +714,7 @@ func (app *App) StopSessionAndSummarize() error {
 	if err := app.saveSummary(summaryPath, activeSession, summary); err != nil {
 		return fmt.Errorf("failed to save summary: %w", err)
 	}
+	app.publishSummary(activeSession, summary)
 
 	// Also save session info file
 	sessionInfoPath := filepath.Join(sessionDir, "session_info.txt")
@@ -224,14 +722,23 @@ func (app *App) StopSessionAndSummarize() error {
 		fmt.Printf("Warning: Failed to save session info: %v\n", err)
 	}
 
-	// Generate timelapse if enough screenshots
-	if len(screenshots) >= 3 {
+	// Contact sheet of thumbnails for quick browsing
+	contactSheetPath := filepath.Join(sessionDir, "contact_sheet.jpg")
+	if err := GenerateContactSheet(screenshots, contactSheetPath, app.config.ThumbnailSettings); err != nil {
+		fmt.Printf("Warning: Failed to create contact sheet: %v\n", err)
+	} else {
+		fmt.Printf("Contact sheet: %s\n", contactSheetPath)
+	}
+
+	// Generate timelapse if enough capture ticks
+	ticks := len(groupScreenshotsByTick(screenshots))
+	if ticks >= 3 {
 		fmt.Printf("Creating timelapse video from %d screenshots...\n", len(screenshots))
 		if err := app.generateTimelapse(screenshots, sessionDir, activeSession); err != nil {
 			fmt.Printf("Warning: Failed to create timelapse: %v\n", err)
 		}
 	} else {
-		fmt.Printf("Skipping timelapse: need at least 3 screenshots (have %d)\n", len(screenshots))
+		fmt.Printf("Skipping timelapse: need at least 3 captures (have %d)\n", ticks)
 	}
 
 	fmt.Printf("Session analysis saved to: %s\n", sessionDir)
@@ -248,7 +755,8 @@ func (app *App) saveSummary(path string, session *Session, summary string) error
 	content += fmt.Sprintf("Student: %s\n", session.StudentName)
 	content += fmt.Sprintf("Start Time: %s\n", session.StartTime.Format("2006-01-02 15:04:05"))
 	content += fmt.Sprintf("End Time: %s\n", session.EndTime.Format("2006-01-02 15:04:05"))
-	content += fmt.Sprintf("Duration: %s\n", session.EndTime.Sub(session.StartTime).Round(time.Second))
+	content += app.durationLines(session)
+	content += app.bookmarkLines(session)
 	content += "\nAnalysis:\n"
 	content += "---------\n"
 	content += summary
@@ -263,12 +771,57 @@ func (app *App) saveSessionInfo(path string, session *Session) error {
 	content += fmt.Sprintf("Student: %s\n", session.StudentName)
 	content += fmt.Sprintf("Started: %s\n", session.StartTime.Format("2006-01-02 15:04:05"))
 	content += fmt.Sprintf("Ended: %s\n", session.EndTime.Format("2006-01-02 15:04:05"))
-	content += fmt.Sprintf("Duration: %s\n", session.EndTime.Sub(session.StartTime).Round(time.Second))
+	content += app.durationLines(session)
 	content += fmt.Sprintf("Status: %s\n", session.Status)
 
 	return writeFile(path, content)
 }
 
+// durationLines reports active time, which leaves out pauses, along with
+// the pauses themselves
+func (app *App) durationLines(session *Session) string {
+	pauses, err := app.sessionManager.GetSessionPauses(session.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load pauses: %v\n", err)
+	}
+
+	content := fmt.Sprintf("Duration: %s\n", activeDuration(session.StartTime, session.EndTime, pauses).Round(time.Second))
+	if len(pauses) > 0 {
+		content += fmt.Sprintf("Wall Time: %s\n", session.EndTime.Sub(session.StartTime).Round(time.Second))
+		content += fmt.Sprintf("Pauses: %s\n", describePauses(session.StartTime, session.EndTime, pauses))
+		for _, p := range pauses {
+			resumed := "end of session"
+			if !p.ResumedAt.IsZero() {
+				resumed = p.ResumedAt.Format("15:04:05")
+			}
+			reason := p.Reason
+			if reason == "" {
+				reason = "no reason given"
+			}
+			content += fmt.Sprintf("  - %s to %s (%s)\n", p.PausedAt.Format("15:04:05"), resumed, reason)
+		}
+	}
+	return content
+}
+
+// bookmarkLines lists the notes added during the session, if any
+func (app *App) bookmarkLines(session *Session) string {
+	events, err := app.sessionManager.GetSessionEvents(session.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load bookmarks: %v\n", err)
+		return ""
+	}
+	if len(events) == 0 {
+		return ""
+	}
+
+	content := "\nBookmarks:\n"
+	for _, e := range events {
+		content += fmt.Sprintf("  - %s %s\n", e.Timestamp.Format("15:04:05"), e.Note)
+	}
+	return content
+}
+
 func (app *App) generateTimelapse(screenshots []Screenshot, sessionDir string, session *Session) error {
 	generator := NewTimelapseGenerator()
 
@@ -298,7 +851,11 @@ func (app *App) generateTimelapse(screenshots []Screenshot, sessionDir string, s
 
 	// Add timelapse info to a separate file
 	timelapseInfoPath := filepath.Join(sessionDir, "timelapse_info.txt")
-	info := generator.GetTimelapseInfo(screenshots, app.config.TimelapseSettings)
+	pauses, err := app.sessionManager.GetSessionPauses(session.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load pauses: %v\n", err)
+	}
+	info := generator.GetTimelapseInfo(screenshots, app.config.TimelapseSettings, pauses)
 
 	content := "Timelapse Information\n"
 	content += "====================\n\n"
@@ -313,11 +870,48 @@ func (app *App) generateTimelapse(screenshots []Screenshot, sessionDir string, s
 		fmt.Printf("Warning: Failed to save timelapse info: %v\n", err)
 	}
 
+	if app.outbox != nil {
+		metadata := app.timelapseMetadata(outputPath, screenshots, pauses)
+		if err := app.outbox.EnqueueTimelapse(session.ID, session.GlobalID(), outputPath, metadata); err != nil {
+			fmt.Printf("Warning: Failed to queue timelapse upload: %v\n", err)
+		}
+	}
+
 	fmt.Printf("✅ Timelapse created: %s\n", filepath.Base(outputPath))
 	return nil
 }
 
+// timelapseMetadata describes the timelapse at path, made from a
+// session's screenshots with the current timelapse settings
+func (app *App) timelapseMetadata(path string, screenshots []Screenshot, pauses []SessionPause) *TimelapseMetadata {
+	settings := app.config.TimelapseSettings
+	ticks := len(groupScreenshotsByTick(screenshots))
+	metadata := &TimelapseMetadata{
+		File:   filepath.Base(path),
+		Format: strings.TrimPrefix(filepath.Ext(path), "."),
+		FPS:    settings.FPS,
+		Frames: ticks,
+	}
+	if settings.FPS > 0 {
+		metadata.LengthSeconds = float64(ticks) / float64(settings.FPS)
+	}
+	if len(screenshots) > 0 {
+		metadata.CoveredSeconds = int64(activeDuration(screenshots[0].Timestamp, screenshots[len(screenshots)-1].Timestamp, pauses).Seconds())
+	}
+	return metadata
+}
+
 func (app *App) Close() error {
+	app.stopRemoteCommands()
+	if app.outbox != nil {
+		// Give session updates and fresh screenshots a moment to go out
+		app.outbox.Flush(10 * time.Second)
+		app.outbox.Stop()
+	}
+	app.stopHeartbeat()
+	if app.screenshotCapture != nil && app.screenshotCapture.windows != nil {
+		app.screenshotCapture.windows.Close()
+	}
 	if app.sessionManager != nil {
 		return app.sessionManager.Close()
 	}
@@ -333,4 +927,4 @@ func writeFile(path, content string) error {
 
 	_, err = file.WriteString(content)
 	return err
-}
\ No newline at end of file
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// byteLimiter is a token bucket shared by every upload, so the combined
+// rate of all workers stays under the configured bytes per second
+type byteLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteLimiter(bytesPerSecond int) *byteLimiter {
+	rate := float64(bytesPerSecond)
+	return &byteLimiter{
+		rate:   rate,
+		burst:  rate, // Up to one second's worth after an idle spell
+		tokens: rate,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be sent. Tokens are taken up front, so a
+// large request borrows from the future and later callers queue behind it.
+func (l *byteLimiter) wait(n int) {
+	if d := l.take(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// take takes n bytes' worth of tokens without waiting and returns how long
+// the caller would have to wait to stay under the rate
+func (l *byteLimiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens -= float64(n)
+	return l.debt()
+}
+
+// delay is how long sending n more bytes would take at the limited rate,
+// without taking any tokens
+func (l *byteLimiter) delay(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens -= float64(n)
+	d := l.debt()
+	l.tokens += float64(n)
+	return d
+}
+
+func (l *byteLimiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+func (l *byteLimiter) debt() time.Duration {
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// throttledReader paces reads through a byteLimiter so request bodies are
+// streamed at the limited rate rather than sent in bursts
+type throttledReader struct {
+	r       io.ReadCloser
+	limiter *byteLimiter
+}
+
+// throttleChunk bounds each read so one large body can't hold the bucket
+const throttleChunk = 16 * 1024
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.limiter.wait(n)
+	}
+	return n, err
+}
+
+func (t *throttledReader) Close() error {
+	return t.r.Close()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestByteLimiterDelay(t *testing.T) {
+	limiter := newByteLimiter(1000)
+	if d := limiter.delay(1000); d != 0 {
+		t.Errorf("delay within the burst = %s, want 0", d)
+	}
+	if d := limiter.delay(3000); d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("delay(3000) = %s, want about 2s", d)
+	}
+	// delay only looks; take borrows from the future
+	if d := limiter.take(3000); d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("take(3000) = %s, want about 2s", d)
+	}
+	if d := limiter.delay(1000); d < 2900*time.Millisecond || d > 3*time.Second {
+		t.Errorf("delay after take = %s, want about 3s", d)
+	}
+}
+
+func TestSendRequestThrottleBudget(t *testing.T) {
+	var received []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, len(body))
+	}))
+	defer server.Close()
+
+	// A 1KB/s limit and a 2s timeout leave a one second budget
+	client := &http.Client{Timeout: 2 * time.Second}
+	limiter := newByteLimiter(1000)
+	send := func(size int) time.Duration {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, server.URL, bytes.NewReader(make([]byte, size)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		if _, err := sendRequest(client, limiter, req); err != nil {
+			t.Fatalf("sendRequest(%d bytes): %v", size, err)
+		}
+		return time.Since(start)
+	}
+
+	// Paced, this would take 100s and time out; it goes at full speed
+	if took := send(100_000); took > time.Second {
+		t.Errorf("oversized body took %s, want it sent unthrottled", took)
+	}
+	// ...but it still counts against the limit
+	if d := limiter.delay(1); d < 90*time.Second {
+		t.Errorf("limiter owes %s after the oversized body, want about 99s", d)
+	}
+
+	limiter = newByteLimiter(1000)
+	if took := send(1500); took < 400*time.Millisecond {
+		t.Errorf("body within the budget took %s, want it paced", took)
+	}
+	if len(received) != 2 || received[0] != 100_000 || received[1] != 1500 {
+		t.Errorf("server received %v", received)
+	}
+}
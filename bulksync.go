@@ -0,0 +1,529 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SyncFilter picks the completed sessions -sync looks at
+type SyncFilter struct {
+	SessionID int       // Only this session (0 = any)
+	Since     time.Time // Sessions starting at or after this (zero = no bound)
+	Until     time.Time // Sessions starting before this (zero = no bound)
+}
+
+// parseSyncFilter builds a filter from the -session, -since and -until
+// flags. Dates are local calendar days and -until includes its day.
+func parseSyncFilter(sessionID int, since, until string) (SyncFilter, error) {
+	filter := SyncFilter{SessionID: sessionID}
+	if since != "" {
+		t, err := time.ParseInLocation("2006-01-02", since, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid -since date %q (want YYYY-MM-DD)", since)
+		}
+		filter.Since = t
+	}
+	if until != "" {
+		t, err := time.ParseInLocation("2006-01-02", until, time.Local)
+		if err != nil {
+			return filter, fmt.Errorf("invalid -until date %q (want YYYY-MM-DD)", until)
+		}
+		filter.Until = t.AddDate(0, 0, 1)
+	}
+	return filter, nil
+}
+
+func (f SyncFilter) matches(session Session) bool {
+	if f.SessionID != 0 && session.ID != f.SessionID {
+		return false
+	}
+	if !f.Since.IsZero() && session.StartTime.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !session.StartTime.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Kinds of sync_files rows
+const (
+	syncSessionDetails = "session"    // Student, times and completion; file_path is empty
+	syncScreenshot     = "screenshot" // A saved screenshot file
+	syncSummary        = "summary"    // file_path is the session's summary.txt
+	syncTimelapse      = "timelapse"  // A timelapse video file
+)
+
+// sync_files states
+const (
+	syncDone   = "done"
+	syncFailed = "failed" // Rejected by the webapp; tried again on the next run
+)
+
+// syncItem is one thing a session still needs on the webapp
+type syncItem struct {
+	Kind       string
+	FilePath   string
+	CapturedAt time.Time
+	Quality    int // JPEG quality the screenshot was saved at
+}
+
+func (item syncItem) describe() string {
+	if item.Kind == syncSessionDetails {
+		return "session details"
+	}
+	return filepath.Base(item.FilePath)
+}
+
+// sessionSyncPlan lists what one session is missing on the webapp
+type sessionSyncPlan struct {
+	Session Session
+	Items   []syncItem
+	Present []syncItem // Found on the webapp without a local record
+	Missing []string   // Recorded in the database but gone from disk
+}
+
+// BulkSyncReport counts what a -sync run did, or would do on a dry run
+type BulkSyncReport struct {
+	DryRun   bool
+	Sessions int
+	Uploaded int
+	Present  int
+	Failed   int
+	Missing  int
+}
+
+func (r BulkSyncReport) String() string {
+	verb := "uploaded"
+	if r.DryRun {
+		verb = "to upload"
+	}
+	return fmt.Sprintf("Checked %d session(s): %d file(s) %s, %d already on webapp, %d rejected, %d missing locally",
+		r.Sessions, r.Uploaded, verb, r.Present, r.Failed, r.Missing)
+}
+
+// getCompletedSessions returns every completed session whose folder
+// retention hasn't removed, oldest first
+func (sm *SessionManager) getCompletedSessions() ([]Session, error) {
+	rows, err := sm.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE status = 'completed' AND (purge_scope IS NULL OR purge_scope != ?) ORDER BY start_time",
+		purgeSession)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+func syncKey(kind, filePath string) string {
+	return kind + "|" + filePath
+}
+
+// getSyncedFiles returns the sync_files keys of a session already sent
+func (sm *SessionManager) getSyncedFiles(sessionID int) (map[string]bool, error) {
+	rows, err := sm.db.Query("SELECT kind, file_path FROM sync_files WHERE session_id = ? AND status = ?", sessionID, syncDone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	synced := make(map[string]bool)
+	for rows.Next() {
+		var kind, filePath string
+		if err := rows.Scan(&kind, &filePath); err != nil {
+			return nil, err
+		}
+		synced[syncKey(kind, filePath)] = true
+	}
+	return synced, rows.Err()
+}
+
+// getQueuedScreenshots returns the file names of a session's screenshots
+// the upload outbox has sent to sink under its current global ID or is
+// still trying to send
+func (sm *SessionManager) getQueuedScreenshots(session *Session, sink string) (map[string]bool, error) {
+	rows, err := sm.db.Query(
+		"SELECT file_path FROM upload_queue WHERE session_id = ? AND global_session_id = ? AND sink = ? AND kind IN (?, ?) AND status != ?",
+		session.ID, session.GlobalID(), sink, uploadScreenshot, uploadRendition, uploadDead,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	queued := make(map[string]bool)
+	for rows.Next() {
+		var filePath string
+		if err := rows.Scan(&filePath); err != nil {
+			return nil, err
+		}
+		// Renditions share the saved file's name
+		queued[filepath.Base(filePath)] = true
+	}
+	return queued, rows.Err()
+}
+
+// recordSync stores the outcome of sending one item; a nil sendErr marks it
+// done
+func (sm *SessionManager) recordSync(sessionID int, item syncItem, sendErr error) error {
+	status, lastError := syncDone, ""
+	var syncedAt interface{} = time.Now()
+	if sendErr != nil {
+		status, lastError, syncedAt = syncFailed, sendErr.Error(), nil
+	}
+	_, err := sm.db.Exec(`
+		INSERT INTO sync_files (session_id, kind, file_path, status, attempts, last_error, synced_at)
+		VALUES (?, ?, ?, ?, 1, ?, ?)
+		ON CONFLICT (session_id, kind, file_path) DO UPDATE SET
+			status = excluded.status, attempts = attempts + 1, last_error = excluded.last_error, synced_at = excluded.synced_at`,
+		sessionID, item.Kind, item.FilePath, status, lastError, syncedAt,
+	)
+	return err
+}
+
+// readSavedSummary returns the analysis section of a summary.txt written
+// by saveSummary, for sessions analyzed before summaries were stored in
+// the database
+func readSavedSummary(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	const marker = "\nAnalysis:\n---------\n"
+	content := string(data)
+	if i := strings.Index(content, marker); i >= 0 {
+		return strings.TrimSpace(content[i+len(marker):])
+	}
+	return ""
+}
+
+// remoteScreenshotSet indexes the names ListScreenshots returns by
+// "<timestamp>-<file stem>", with and without a trailing blob suffix
+func remoteScreenshotSet(names []string) map[string]bool {
+	set := make(map[string]bool)
+	for _, name := range names {
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		set[stem] = true
+		if i := strings.LastIndex(stem, "-"); i > 0 {
+			set[stem[:i]] = true
+		}
+	}
+	return set
+}
+
+func remoteScreenshotKey(capturedAt time.Time, filename string) string {
+	return fmt.Sprintf("%d-%s", capturedAt.Unix(), strings.TrimSuffix(filename, filepath.Ext(filename)))
+}
+
+// planSessionSync works out what a session is missing on the webapp.
+// remote and remoteScreenshots are nil when the webapp couldn't say what
+// it holds, leaving local records to decide.
+func (app *App) planSessionSync(session Session, remote *remoteSessionData, remoteScreenshots map[string]bool) (*sessionSyncPlan, error) {
+	sm := app.sessionManager
+	synced, err := sm.getSyncedFiles(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	queued, err := sm.getQueuedScreenshots(&session, app.webappSink)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &sessionSyncPlan{Session: session}
+	globalID := session.GlobalID()
+	add := func(item syncItem, onServer bool) {
+		switch {
+		case synced[syncKey(item.Kind, item.FilePath)]:
+		case onServer:
+			plan.Present = append(plan.Present, item)
+		default:
+			plan.Items = append(plan.Items, item)
+		}
+	}
+
+	add(syncItem{Kind: syncSessionDetails}, remote != nil && remote.Status[globalID] == "completed")
+
+	screenshots, err := sm.GetSessionScreenshots(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range screenshots {
+		if s.IsDuplicate() || queued[filepath.Base(s.FilePath)] {
+			continue
+		}
+		item := syncItem{Kind: syncScreenshot, FilePath: s.FilePath, CapturedAt: s.Timestamp, Quality: s.Quality}
+		onServer := remoteScreenshots[remoteScreenshotKey(s.Timestamp, filepath.Base(s.FilePath))]
+		if !onServer && !synced[syncKey(item.Kind, item.FilePath)] {
+			if _, err := os.Stat(s.FilePath); err != nil {
+				plan.Missing = append(plan.Missing, s.FilePath)
+				continue
+			}
+		}
+		add(item, onServer)
+	}
+
+	sessionDir := sm.GetSessionDir(session.ID)
+	summaryPath := filepath.Join(sessionDir, "summary.txt")
+	if session.Summary != "" || readSavedSummary(summaryPath) != "" {
+		add(syncItem{Kind: syncSummary, FilePath: summaryPath}, remote != nil && remote.Summaries[globalID] != "")
+	}
+
+	videos, _ := filepath.Glob(filepath.Join(sessionDir, "*_timelapse.*"))
+	for _, video := range videos {
+		onServer := false
+		if remote != nil {
+			if timelapse := remote.Details[globalID].Timelapse; timelapse != nil {
+				onServer = timelapse.URL != "" && timelapse.File == filepath.Base(video)
+			}
+		}
+		add(syncItem{Kind: syncTimelapse, FilePath: video, CapturedAt: session.EndTime}, onServer)
+	}
+
+	return plan, nil
+}
+
+// RunSync uploads whatever completed sessions matching filter are missing
+// on the webapp: session details, screenshots, summaries and timelapses.
+// Each item's outcome is recorded in sync_files, so an interrupted run
+// resumes where it stopped. A dry run only lists what would be sent.
+func (app *App) RunSync(filter SyncFilter, dryRun bool) (*BulkSyncReport, error) {
+	if app.webapp == nil {
+		return nil, fmt.Errorf("no webapp_url configured")
+	}
+	webapp := app.webapp
+
+	report := &BulkSyncReport{DryRun: dryRun}
+	remote, err := webapp.FetchSessionData()
+	if err != nil {
+		if !dryRun {
+			return report, fmt.Errorf("failed to reach webapp: %w", err)
+		}
+		fmt.Printf("Warning: Webapp unreachable (%v); listing from local records only\n", err)
+		remote = nil
+	}
+
+	sessions, err := app.sessionManager.getCompletedSessions()
+	if err != nil {
+		return report, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	for _, session := range sessions {
+		if !filter.matches(session) {
+			continue
+		}
+		report.Sessions++
+
+		var remoteScreenshots map[string]bool
+		if remote != nil {
+			names, err := webapp.ListScreenshots(session.GlobalID())
+			if err != nil {
+				fmt.Printf("Warning: Could not list screenshots on webapp for session %d: %v\n", session.ID, err)
+			} else {
+				remoteScreenshots = remoteScreenshotSet(names)
+			}
+		}
+
+		plan, err := app.planSessionSync(session, remote, remoteScreenshots)
+		if err != nil {
+			return report, fmt.Errorf("failed to check session %d: %w", session.ID, err)
+		}
+		report.Present += len(plan.Present)
+		report.Missing += len(plan.Missing)
+		printSyncPlan(plan, dryRun)
+
+		if dryRun {
+			report.Uploaded += len(plan.Items)
+			continue
+		}
+
+		// Note what the webapp already had so later runs don't ask again
+		for _, item := range plan.Present {
+			if err := app.sessionManager.recordSync(session.ID, item, nil); err != nil {
+				return report, fmt.Errorf("failed to record sync: %w", err)
+			}
+		}
+		if err := app.sendSyncItems(plan, remote, report); err != nil {
+			return report, fmt.Errorf("%w (run -sync again to resume)", err)
+		}
+	}
+	return report, nil
+}
+
+func printSyncPlan(plan *sessionSyncPlan, dryRun bool) {
+	session := plan.Session
+	name := session.StudentName
+	if name == "" {
+		name = "unnamed"
+	}
+	fmt.Printf("\nSession %d (%s, %s): %d to upload, %d already on webapp\n",
+		session.ID, name, session.StartTime.Format("2006-01-02 15:04"), len(plan.Items), len(plan.Present))
+	for _, path := range plan.Missing {
+		fmt.Printf("   missing    %s\n", path)
+	}
+	if !dryRun {
+		return
+	}
+	for _, item := range plan.Items {
+		fmt.Printf("   %-10s %s\n", item.Kind, item.describe())
+	}
+}
+
+// sendSyncItems uploads a session's missing items in order: details first
+// so the webapp knows the session, then screenshots, summary and
+// timelapse. Items the webapp rejects are recorded and skipped; any other
+// failure stops the run.
+func (app *App) sendSyncItems(plan *sessionSyncPlan, remote *remoteSessionData, report *BulkSyncReport) error {
+	client := app.webapp
+	session := plan.Session
+	globalID := session.GlobalID()
+	pauses, err := app.sessionManager.GetSessionPauses(session.ID)
+	if err != nil {
+		fmt.Printf("Warning: Failed to load pauses: %v\n", err)
+	}
+
+	record := func(items []syncItem, sendErr error) error {
+		var upErr *uploadError
+		if sendErr != nil && !(errors.As(sendErr, &upErr) && upErr.permanent()) {
+			return fmt.Errorf("failed to upload %s: %w", items[0].describe(), sendErr)
+		}
+		for _, item := range items {
+			if err := app.sessionManager.recordSync(session.ID, item, sendErr); err != nil {
+				return fmt.Errorf("failed to record sync: %w", err)
+			}
+		}
+		if sendErr != nil {
+			fmt.Printf("   Webapp rejected %s: %v\n", items[0].describe(), sendErr)
+			report.Failed += len(items)
+		} else {
+			report.Uploaded += len(items)
+		}
+		return nil
+	}
+
+	var screenshots []syncItem
+	for _, item := range plan.Items {
+		switch item.Kind {
+		case syncSessionDetails:
+			update := sessionStoppedUpdate(&session, pauses)
+			update.Description = session.Description
+			// Names already set on the webapp are left to the name sync
+			if remote == nil || remote.Names[globalID] == "" {
+				update.StudentName = session.StudentName
+			}
+			sendErr := client.UpdateSession(update)
+			if err := record([]syncItem{item}, sendErr); err != nil {
+				return err
+			}
+			if sendErr == nil && update.StudentName != "" {
+				app.sessionManager.markSynced(session.ID, update.StudentName, "")
+			}
+		case syncScreenshot:
+			screenshots = append(screenshots, item)
+		}
+	}
+
+	batchSize := app.config.UploadOutbox.BatchSize
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	for start := 0; start < len(screenshots); start += batchSize {
+		end := start + batchSize
+		if end > len(screenshots) {
+			end = len(screenshots)
+		}
+		batch := screenshots[start:end]
+		files := make([]uploadFile, 0, len(batch))
+		for _, item := range batch {
+			data, err := app.syncScreenshotData(item)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", item.describe(), err)
+			}
+			files = append(files, uploadFile{Filename: filepath.Base(item.FilePath), CapturedAt: item.CapturedAt, Data: data})
+		}
+		if err := record(batch, client.UploadScreenshots(globalID, files)); err != nil {
+			return err
+		}
+		fmt.Printf("   Uploaded %d/%d screenshot(s)\n", end, len(screenshots))
+	}
+
+	for _, item := range plan.Items {
+		switch item.Kind {
+		case syncSummary:
+			summary := session.Summary
+			if summary == "" {
+				summary = readSavedSummary(item.FilePath)
+				app.sessionManager.SetSummary(session.ID, summary)
+			}
+			sendErr := client.UpdateSession(SessionUpdate{SessionID: globalID, Summary: summary})
+			if err := record([]syncItem{item}, sendErr); err != nil {
+				return err
+			}
+			if sendErr == nil {
+				app.sessionManager.markSynced(session.ID, "", summary)
+			}
+		case syncTimelapse:
+			if err := record([]syncItem{item}, app.uploadTimelapse(session, item, pauses)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncScreenshotData reads a saved screenshot, re-encoding it with the
+// upload rendition settings when they're in use
+func (app *App) syncScreenshotData(item syncItem) ([]byte, error) {
+	rendition := app.config.UploadRendition
+	if !rendition.enabled() {
+		return os.ReadFile(item.FilePath)
+	}
+	img, err := loadRGBA(item.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	quality := item.Quality
+	if quality <= 0 {
+		quality = app.config.ScreenshotSettings.Quality
+	}
+	return encodeUploadRendition(img, rendition, quality)
+}
+
+// uploadTimelapse sends a timelapse video and then its details, with the
+// URL the webapp stored it at
+func (app *App) uploadTimelapse(session Session, item syncItem, pauses []SessionPause) error {
+	client := app.webapp
+	data, err := os.ReadFile(item.FilePath)
+	if err != nil {
+		// Nothing to retry if the file is gone
+		return &uploadError{status: http.StatusGone, body: err.Error()}
+	}
+	url, err := client.UploadTimelapse(session.GlobalID(), uploadFile{
+		Filename:   filepath.Base(item.FilePath),
+		CapturedAt: item.CapturedAt,
+		Data:       data,
+	})
+	if err != nil {
+		return err
+	}
+
+	screenshots, err := app.sessionManager.GetSessionScreenshots(session.ID)
+	if err != nil {
+		return err
+	}
+	metadata := app.timelapseMetadata(item.FilePath, screenshots, pauses)
+	metadata.URL = url
+	return client.UpdateSession(SessionUpdate{SessionID: session.GlobalID(), Timelapse: metadata})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeWebapp holds what a webapp knows about sessions and records what
+// -sync sends it
+type fakeWebapp struct {
+	mu          sync.Mutex
+	status      map[string]string   // Global session ID to status
+	screenshots map[string][]string // Global session ID to stored names
+	reject      bool                // Answer screenshot uploads with 400
+	fail        bool                // Answer screenshot uploads with 500
+
+	uploads int // Screenshot files received
+	updates []SessionUpdate
+}
+
+func newFakeWebapp(t *testing.T) (*fakeWebapp, *httptest.Server) {
+	t.Helper()
+	webapp := &fakeWebapp{status: make(map[string]string), screenshots: make(map[string][]string)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webapp.mu.Lock()
+		defer webapp.mu.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/student-names":
+			json.NewEncoder(w).Encode(map[string]interface{}{"names": map[string]string{}, "status": webapp.status})
+		case r.Method == "GET" && r.URL.Path == "/api/screenshots":
+			json.NewEncoder(w).Encode(map[string]interface{}{"files": webapp.screenshots[r.URL.Query().Get("sessionId")]})
+		case r.Method == "POST" && r.URL.Path == "/api/screenshots":
+			if webapp.reject || webapp.fail {
+				status := http.StatusBadRequest
+				if webapp.fail {
+					status = http.StatusInternalServerError
+				}
+				http.Error(w, "no thanks", status)
+				return
+			}
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("invalid upload: %v", err)
+			}
+			sessionID := r.FormValue("sessionId")
+			timestamps := r.MultipartForm.Value["timestamp"]
+			for i, file := range r.MultipartForm.File["screenshot"] {
+				webapp.screenshots[sessionID] = append(webapp.screenshots[sessionID], timestamps[i]+"-"+file.Filename)
+				webapp.uploads++
+			}
+			w.Write([]byte(`{"success": true}`))
+		case r.Method == "POST" && r.URL.Path == "/api/student-names":
+			var update SessionUpdate
+			json.NewDecoder(r.Body).Decode(&update)
+			webapp.updates = append(webapp.updates, update)
+			if update.Status != "" {
+				webapp.status[update.SessionID] = update.Status
+			}
+			w.Write([]byte(`{"success": true}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return webapp, server
+}
+
+// recordTestSession stores a completed session with n screenshots, a
+// second apart, saved as small files
+func recordTestSession(t *testing.T, sm *SessionManager, n int) (*Session, []string) {
+	t.Helper()
+	session, err := sm.StartSession("Sync test", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := sm.GetSessionScreenshotDir(session.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("screenshot_%d.jpg", i))
+		writeTestFile(t, path)
+		frame := CapturedFrame{FilePath: path, Tick: i + 1, Timestamp: session.StartTime.Add(time.Duration(i) * time.Second)}
+		if err := sm.RecordScreenshot(frame); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if err := sm.StopSession(); err != nil {
+		t.Fatal(err)
+	}
+	return session, paths
+}
+
+func countSyncFiles(t *testing.T, sm *SessionManager, status string) int {
+	t.Helper()
+	var n int
+	if err := sm.db.QueryRow("SELECT COUNT(*) FROM sync_files WHERE status = ?", status).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRunSyncSkipsWhatTheWebappHas(t *testing.T) {
+	webapp, server := newFakeWebapp(t)
+	app := newTestApp(t, map[string]interface{}{"webapp_url": server.URL})
+	sm := app.sessionManager
+
+	session, paths := recordTestSession(t, sm, 5)
+	// One screenshot is already on the webapp, one went through the live
+	// outbox and one is gone from disk
+	webapp.screenshots[session.GlobalID()] = []string{
+		fmt.Sprintf("%d-screenshot_0-Xa1b2.jpg", session.StartTime.Unix()),
+	}
+	if err := app.outbox.Enqueue(session.ID, session.GlobalID(), CapturedFrame{FilePath: paths[1], Timestamp: session.StartTime}); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(paths[4])
+
+	// A dry run only reports
+	report, err := app.RunSync(SyncFilter{}, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if report.Sessions != 1 || report.Uploaded != 3 || report.Present != 1 || report.Missing != 1 {
+		t.Errorf("dry run report = %+v, want details and 2 screenshots to upload", report)
+	}
+	if webapp.uploads != 0 || len(webapp.updates) != 0 || countSyncFiles(t, sm, syncDone) != 0 {
+		t.Fatalf("dry run sent %d screenshot(s) and %d update(s)", webapp.uploads, len(webapp.updates))
+	}
+
+	report, err = app.RunSync(SyncFilter{}, false)
+	if err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+	if report.Uploaded != 3 || report.Present != 1 || report.Missing != 1 || report.Failed != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if webapp.uploads != 2 {
+		t.Errorf("webapp received %d screenshot(s), want 2", webapp.uploads)
+	}
+	if len(webapp.updates) != 1 || webapp.updates[0].Status != "completed" || webapp.updates[0].StudentName != "Ada" {
+		t.Errorf("session updates = %+v, want the completed session details", webapp.updates)
+	}
+	// Uploads and what the webapp already had are both recorded
+	if n := countSyncFiles(t, sm, syncDone); n != 4 {
+		t.Errorf("%d sync_files rows done, want 4", n)
+	}
+
+	// Nothing left for a second run
+	report, err = app.RunSync(SyncFilter{}, false)
+	if err != nil {
+		t.Fatalf("second RunSync: %v", err)
+	}
+	if report.Uploaded != 0 || report.Present != 0 || webapp.uploads != 2 || len(webapp.updates) != 1 {
+		t.Errorf("second run report = %+v, webapp has %d upload(s) and %d update(s)", report, webapp.uploads, len(webapp.updates))
+	}
+}
+
+func TestRunSyncRejectedAndFailedUploads(t *testing.T) {
+	webapp, server := newFakeWebapp(t)
+	app := newTestApp(t, map[string]interface{}{"webapp_url": server.URL})
+	sm := app.sessionManager
+	recordTestSession(t, sm, 2)
+
+	// A server error stops the run without recording the screenshots, so
+	// the next run resumes with them
+	webapp.fail = true
+	if _, err := app.RunSync(SyncFilter{}, false); err == nil {
+		t.Fatal("RunSync succeeded against a failing webapp")
+	}
+	if n := countSyncFiles(t, sm, syncFailed); n != 0 {
+		t.Errorf("%d sync_files rows failed after a server error, want 0", n)
+	}
+
+	// A rejection is recorded and the run carries on
+	webapp.fail, webapp.reject = false, true
+	report, err := app.RunSync(SyncFilter{}, false)
+	if err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+	if report.Failed != 2 || countSyncFiles(t, sm, syncFailed) != 2 {
+		t.Errorf("report = %+v, want 2 rejected and recorded", report)
+	}
+
+	// Rejected items are tried again
+	webapp.reject = false
+	report, err = app.RunSync(SyncFilter{}, false)
+	if err != nil {
+		t.Fatalf("RunSync: %v", err)
+	}
+	if report.Uploaded != 2 || webapp.uploads != 2 || countSyncFiles(t, sm, syncFailed) != 0 {
+		t.Errorf("report = %+v with %d upload(s), want both retried", report, webapp.uploads)
+	}
+}
+
+func TestSyncFilter(t *testing.T) {
+	filter, err := parseSyncFilter(0, "2024-03-04", "2024-03-05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(d, hour int) Session {
+		return Session{ID: d, StartTime: time.Date(2024, 3, d, hour, 0, 0, 0, time.Local)}
+	}
+	tests := []struct {
+		session Session
+		want    bool
+	}{
+		{day(3, 23), false},
+		{day(4, 0), true},
+		{day(5, 23), true}, // -until includes its day
+		{day(6, 0), false},
+	}
+	for _, tt := range tests {
+		if got := filter.matches(tt.session); got != tt.want {
+			t.Errorf("%s matches = %v, want %v", tt.session.StartTime, got, tt.want)
+		}
+	}
+
+	if _, err := parseSyncFilter(0, "04/03/2024", ""); err == nil {
+		t.Error("accepted a date that isn't YYYY-MM-DD")
+	}
+	if filter, _ := parseSyncFilter(7, "", ""); filter.matches(day(4, 9)) || !filter.matches(Session{ID: 7}) {
+		t.Error("-session does not pick exactly that session")
+	}
+}
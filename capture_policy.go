@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// CapturePolicy decides when the capture loop takes the next screenshot
+type CapturePolicy interface {
+	Name() string
+	// Params returns the effective parameters, recorded on the session
+	Params() map[string]interface{}
+	// NextDelay returns how long to wait before the next capture, given the
+	// difference score (0-1) between the last two captures
+	NextDelay(changeScore float64) time.Duration
+	// ProbeInterval returns how often to take probe frames while waiting,
+	// or 0 if the policy does not probe
+	ProbeInterval() time.Duration
+	// Triggered reports whether a probe frame changed enough from the last
+	// capture to capture immediately
+	Triggered(probeScore float64) bool
+}
+
+type CapturePolicySettings struct {
+	Type            string  `json:"type"`             // "fixed", "jitter", "adaptive", "event"
+	JitterSeconds   int     `json:"jitter_seconds"`   // jitter: random offset window (+/-) around the interval
+	MinInterval     int     `json:"min_interval"`     // adaptive: fastest interval while the screen changes
+	MaxInterval     int     `json:"max_interval"`     // adaptive/event: slowest interval while the screen is static
+	ChangeThreshold float64 `json:"change_threshold"` // adaptive/event: difference score (0-1) counted as a large change
+	ProbeInterval   int     `json:"probe_interval"`   // event: seconds between low-cost probe frames
+}
+
+// NewCapturePolicy builds the configured policy around the base interval
+// from the -interval flag or the interactive prompt
+func NewCapturePolicy(settings CapturePolicySettings, intervalSeconds int) (CapturePolicy, error) {
+	interval := time.Duration(intervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	minInterval := time.Duration(settings.MinInterval) * time.Second
+	if minInterval <= 0 || minInterval > interval {
+		minInterval = interval / 3
+		if minInterval < time.Second {
+			minInterval = time.Second
+		}
+	}
+	maxInterval := time.Duration(settings.MaxInterval) * time.Second
+	if maxInterval < interval {
+		maxInterval = interval * 4
+	}
+	threshold := settings.ChangeThreshold
+	if threshold <= 0 || threshold > 1 {
+		threshold = 0.05
+	}
+
+	switch strings.ToLower(settings.Type) {
+	case "", "fixed":
+		return &fixedPolicy{interval: interval}, nil
+	case "jitter":
+		jitter := time.Duration(settings.JitterSeconds) * time.Second
+		if jitter <= 0 {
+			jitter = interval / 3
+		}
+		return &jitterPolicy{interval: interval, jitter: jitter}, nil
+	case "adaptive":
+		return &adaptivePolicy{
+			base:      interval,
+			min:       minInterval,
+			max:       maxInterval,
+			threshold: threshold,
+			current:   interval,
+		}, nil
+	case "event":
+		probe := time.Duration(settings.ProbeInterval) * time.Second
+		if probe <= 0 {
+			probe = 5 * time.Second
+		}
+		return &eventPolicy{max: maxInterval, probe: probe, threshold: threshold}, nil
+	default:
+		return nil, fmt.Errorf("unknown capture policy: %s", settings.Type)
+	}
+}
+
+// fixedPolicy captures on a constant interval (the original behavior)
+type fixedPolicy struct {
+	interval time.Duration
+}
+
+func (p *fixedPolicy) Name() string { return "fixed" }
+
+func (p *fixedPolicy) Params() map[string]interface{} {
+	return map[string]interface{}{"interval_seconds": p.interval.Seconds()}
+}
+
+func (p *fixedPolicy) NextDelay(changeScore float64) time.Duration { return p.interval }
+
+func (p *fixedPolicy) ProbeInterval() time.Duration { return 0 }
+
+func (p *fixedPolicy) Triggered(probeScore float64) bool { return false }
+
+// jitterPolicy randomizes each delay within interval +/- jitter, so
+// captures can't be anticipated
+type jitterPolicy struct {
+	interval time.Duration
+	jitter   time.Duration
+}
+
+func (p *jitterPolicy) Name() string { return "jitter" }
+
+func (p *jitterPolicy) Params() map[string]interface{} {
+	return map[string]interface{}{
+		"interval_seconds": p.interval.Seconds(),
+		"jitter_seconds":   p.jitter.Seconds(),
+	}
+}
+
+func (p *jitterPolicy) NextDelay(changeScore float64) time.Duration {
+	offset := time.Duration(rand.Int63n(int64(2*p.jitter)+1)) - p.jitter
+	delay := p.interval + offset
+	if delay < time.Second {
+		delay = time.Second
+	}
+	return delay
+}
+
+func (p *jitterPolicy) ProbeInterval() time.Duration { return 0 }
+
+func (p *jitterPolicy) Triggered(probeScore float64) bool { return false }
+
+// adaptivePolicy drops to the minimum interval after a large change and
+// backs off gradually towards the maximum while the screen stays static
+type adaptivePolicy struct {
+	base      time.Duration
+	min       time.Duration
+	max       time.Duration
+	threshold float64
+	current   time.Duration
+}
+
+func (p *adaptivePolicy) Name() string { return "adaptive" }
+
+func (p *adaptivePolicy) Params() map[string]interface{} {
+	return map[string]interface{}{
+		"interval_seconds":     p.base.Seconds(),
+		"min_interval_seconds": p.min.Seconds(),
+		"max_interval_seconds": p.max.Seconds(),
+		"change_threshold":     p.threshold,
+	}
+}
+
+func (p *adaptivePolicy) NextDelay(changeScore float64) time.Duration {
+	switch {
+	case changeScore >= p.threshold:
+		p.current = p.min
+	case changeScore < p.threshold/4:
+		p.current = p.current * 3 / 2
+		if p.current > p.max {
+			p.current = p.max
+		}
+	}
+	return p.current
+}
+
+func (p *adaptivePolicy) ProbeInterval() time.Duration { return 0 }
+
+func (p *adaptivePolicy) Triggered(probeScore float64) bool { return false }
+
+// eventPolicy watches cheap probe frames and captures as soon as one
+// differs enough from the last capture, with the maximum interval as a
+// fallback so static screens are still recorded occasionally
+type eventPolicy struct {
+	max       time.Duration
+	probe     time.Duration
+	threshold float64
+}
+
+func (p *eventPolicy) Name() string { return "event" }
+
+func (p *eventPolicy) Params() map[string]interface{} {
+	return map[string]interface{}{
+		"max_interval_seconds":   p.max.Seconds(),
+		"probe_interval_seconds": p.probe.Seconds(),
+		"change_threshold":       p.threshold,
+	}
+}
+
+func (p *eventPolicy) NextDelay(changeScore float64) time.Duration { return p.max }
+
+func (p *eventPolicy) ProbeInterval() time.Duration { return p.probe }
+
+func (p *eventPolicy) Triggered(probeScore float64) bool { return probeScore >= p.threshold }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"image"
+	"testing"
+	"time"
+)
+
+func TestNewCapturePolicyDefaults(t *testing.T) {
+	tests := []struct {
+		settings CapturePolicySettings
+		interval int
+		want     map[string]interface{}
+	}{
+		{CapturePolicySettings{}, 0, map[string]interface{}{"interval_seconds": 30.0}},
+		{CapturePolicySettings{Type: "Jitter"}, 30, map[string]interface{}{"interval_seconds": 30.0, "jitter_seconds": 10.0}},
+		{
+			// Out of range limits fall back to fractions and multiples of the interval
+			CapturePolicySettings{Type: "adaptive", MinInterval: 90, MaxInterval: 10, ChangeThreshold: 2},
+			30,
+			map[string]interface{}{
+				"interval_seconds": 30.0, "min_interval_seconds": 10.0,
+				"max_interval_seconds": 120.0, "change_threshold": 0.05,
+			},
+		},
+		{
+			CapturePolicySettings{Type: "event", MaxInterval: 300, ChangeThreshold: 0.2},
+			30,
+			map[string]interface{}{
+				"max_interval_seconds": 300.0, "probe_interval_seconds": 5.0, "change_threshold": 0.2,
+			},
+		},
+	}
+	for _, tt := range tests {
+		policy, err := NewCapturePolicy(tt.settings, tt.interval)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.settings.Type, err)
+		}
+		params := policy.Params()
+		for key, want := range tt.want {
+			if params[key] != want {
+				t.Errorf("%s %s = %v, want %v", policy.Name(), key, params[key], want)
+			}
+		}
+	}
+
+	if _, err := NewCapturePolicy(CapturePolicySettings{Type: "random"}, 30); err == nil {
+		t.Error("unknown policy type accepted")
+	}
+}
+
+func TestJitterPolicyStaysInWindow(t *testing.T) {
+	policy := &jitterPolicy{interval: 30 * time.Second, jitter: 10 * time.Second}
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 1000; i++ {
+		delay := policy.NextDelay(0)
+		if delay < 20*time.Second || delay > 40*time.Second {
+			t.Fatalf("delay %v outside 30s +/- 10s", delay)
+		}
+		seen[delay] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("only %d distinct delays in 1000 draws", len(seen))
+	}
+
+	// Never drops below a second, even when the jitter is wider than the interval
+	policy = &jitterPolicy{interval: 2 * time.Second, jitter: 10 * time.Second}
+	for i := 0; i < 1000; i++ {
+		if delay := policy.NextDelay(0); delay < time.Second {
+			t.Fatalf("delay %v under a second", delay)
+		}
+	}
+}
+
+func TestAdaptivePolicy(t *testing.T) {
+	policy, err := NewCapturePolicy(CapturePolicySettings{
+		Type: "adaptive", MinInterval: 10, MaxInterval: 60, ChangeThreshold: 0.2,
+	}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		score float64
+		want  time.Duration
+	}{
+		{0.01, 30 * time.Second}, // Static: back off from the base interval
+		{0.01, 45 * time.Second},
+		{0.01, 60 * time.Second}, // Capped at the maximum
+		{0.01, 60 * time.Second},
+		{0.5, 10 * time.Second},  // Large change: straight to the minimum
+		{0.1, 10 * time.Second},  // Some change: hold the current interval
+		{0.01, 15 * time.Second}, // Quiet again
+	}
+	for i, step := range steps {
+		if got := policy.NextDelay(step.score); got != step.want {
+			t.Errorf("step %d: score %.2f gave %v, want %v", i, step.score, got, step.want)
+		}
+	}
+}
+
+// TestEventPolicyCapturesOnChange runs the capture loop's wait with fast
+// probes: a static screen waits for the stop signal, a changed one
+// captures straight away
+func TestEventPolicyCapturesOnChange(t *testing.T) {
+	app := newTestApp(t, nil)
+	source := &sequenceSource{images: []*image.RGBA{textScreen(10, false)}}
+	app.screenshotCapture.SetSource(source)
+	app.screenshotCapture.outputDir = t.TempDir()
+	if err := app.screenshotCapture.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	var policy CapturePolicy = &eventPolicy{max: time.Hour, probe: 10 * time.Millisecond, threshold: 0.05}
+
+	// The first probe always differs from nothing
+	if score, err := app.screenshotCapture.Probe(); err != nil || score != 1 {
+		t.Fatalf("probe before any capture = %v, %v; want 1", score, err)
+	}
+	if _, err := app.screenshotCapture.CaptureScreenForSession("s"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A cursor blink is not an event
+	source.images = append(source.images, textScreen(10, true))
+	source.next = 1
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	if app.waitForNextCapture(0, &policy, stop) {
+		t.Fatal("captured a static screen before the maximum interval")
+	}
+
+	// A new window is
+	source.images = append(source.images, textScreen(30, false))
+	source.next = 2
+	done := make(chan bool)
+	go func() { done <- app.waitForNextCapture(0, &policy, make(chan struct{})) }()
+	select {
+	case captured := <-done:
+		if !captured {
+			t.Fatal("wait returned without capturing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("screen change did not trigger a capture")
+	}
+
+	if _, err := app.screenshotCapture.CaptureScreenForSession("s"); err != nil {
+		t.Fatal(err)
+	}
+	if score := app.screenshotCapture.LastChangeScore(); score < 0.05 {
+		t.Errorf("change score between captures is %.3f, want at least 0.05", score)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kbinani/screenshot"
+)
+
+// CaptureSource produces raw frames for the capture pipeline. The default
+// source grabs the real screen; the others make it possible to run a full
+// session on a headless machine or in CI.
+type CaptureSource interface {
+	Name() string
+	NumDisplays() int
+	DisplayBounds(display int) image.Rectangle
+	Capture(display int) (*image.RGBA, error)
+}
+
+// framePeeker is implemented by sources that can return the frame the
+// next Capture will, without consuming it. Probes use it so they don't
+// advance the source.
+type framePeeker interface {
+	Peek(display int) (*image.RGBA, error)
+}
+
+type CaptureSettings struct {
+	Backend           string `json:"backend"`         // "screen", "replay", "synthetic", "command"
+	ReplayDir         string `json:"replay_dir"`      // Folder of images for the replay backend
+	SyntheticWidth    int    `json:"synthetic_width"` // Frame size for the synthetic backend
+	SyntheticHeight   int    `json:"synthetic_height"`
+	SyntheticDisplays int    `json:"synthetic_displays"` // Number of fake displays, laid out left to right
+	Command           string `json:"command"`            // e.g. "grim {file}", "scrot -o {file}", "import -window root {file}"
+	RecordWindowInfo  bool   `json:"record_window_info"` // Record the foreground window with each screenshot (X11)
+	XDisplay          string `json:"x_display"`          // X display to query, empty for $DISPLAY
+}
+
+func NewCaptureSource(settings CaptureSettings) (CaptureSource, error) {
+	switch strings.ToLower(settings.Backend) {
+	case "", "screen":
+		return &screenSource{}, nil
+	case "replay":
+		return newReplaySource(settings.ReplayDir)
+	case "synthetic":
+		return newSyntheticSource(settings.SyntheticWidth, settings.SyntheticHeight, settings.SyntheticDisplays), nil
+	case "command":
+		return newCommandSource(settings.Command)
+	default:
+		return nil, fmt.Errorf("unknown capture backend: %s", settings.Backend)
+	}
+}
+
+// screenSource captures the real displays through kbinani/screenshot.
+type screenSource struct{}
+
+func (s *screenSource) Name() string { return "screen" }
+
+func (s *screenSource) NumDisplays() int {
+	return screenshot.NumActiveDisplays()
+}
+
+func (s *screenSource) DisplayBounds(display int) image.Rectangle {
+	return screenshot.GetDisplayBounds(display)
+}
+
+func (s *screenSource) Capture(display int) (*image.RGBA, error) {
+	return screenshot.CaptureRect(screenshot.GetDisplayBounds(display))
+}
+
+// replaySource plays back a directory of recorded images in name order,
+// looping when it reaches the end.
+type replaySource struct {
+	files  []string
+	next   int
+	bounds image.Rectangle
+}
+
+func newReplaySource(dir string) (*replaySource, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("replay backend requires replay_dir")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".jpg", ".jpeg", ".png":
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no images found in replay directory: %s", dir)
+	}
+	sort.Strings(files)
+
+	// Use the first frame to report display bounds
+	first, err := loadRGBA(files[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &replaySource{files: files, bounds: first.Bounds()}, nil
+}
+
+func (s *replaySource) Name() string { return "replay" }
+
+func (s *replaySource) NumDisplays() int { return 1 }
+
+func (s *replaySource) DisplayBounds(display int) image.Rectangle { return s.bounds }
+
+func (s *replaySource) Capture(display int) (*image.RGBA, error) {
+	path := s.files[s.next]
+	s.next = (s.next + 1) % len(s.files)
+	return loadRGBA(path)
+}
+
+func (s *replaySource) Peek(display int) (*image.RGBA, error) {
+	return loadRGBA(s.files[s.next])
+}
+
+// syntheticSource generates frames in memory: a moving gradient with a
+// frame counter bar, so consecutive frames differ slightly.
+type syntheticSource struct {
+	width    int
+	height   int
+	displays int
+	frame    int
+}
+
+func newSyntheticSource(width, height, displays int) *syntheticSource {
+	if width <= 0 {
+		width = 1280
+	}
+	if height <= 0 {
+		height = 720
+	}
+	if displays <= 0 {
+		displays = 1
+	}
+	return &syntheticSource{width: width, height: height, displays: displays}
+}
+
+func (s *syntheticSource) Name() string { return "synthetic" }
+
+func (s *syntheticSource) NumDisplays() int { return s.displays }
+
+func (s *syntheticSource) DisplayBounds(display int) image.Rectangle {
+	return image.Rect(display*s.width, 0, (display+1)*s.width, s.height)
+}
+
+func (s *syntheticSource) Capture(display int) (*image.RGBA, error) {
+	img := s.render(display)
+
+	// Advance the animation once per tick, after the last display
+	if display == s.displays-1 {
+		s.frame++
+	}
+	return img, nil
+}
+
+func (s *syntheticSource) Peek(display int) (*image.RGBA, error) {
+	return s.render(display), nil
+}
+
+func (s *syntheticSource) render(display int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, s.width, s.height))
+	offset := s.frame * 16
+
+	for y := 0; y < s.height; y++ {
+		for x := 0; x < s.width; x++ {
+			img.SetRGBA(x, y, color.RGBA{
+				R: uint8((x + offset) * 255 / s.width),
+				G: uint8(y * 255 / s.height),
+				B: uint8(128 + display*64),
+				A: 255,
+			})
+		}
+	}
+
+	// Progress bar along the top edge marks the frame number
+	barWidth := (s.frame % 20) * s.width / 20
+	draw.Draw(img, image.Rect(0, 0, barWidth, s.height/20), image.White, image.Point{}, draw.Src)
+	return img
+}
+
+// commandSource shells out to an external screenshot tool such as scrot,
+// grim or ImageMagick's import. The command must contain a {file}
+// placeholder for the output path.
+type commandSource struct {
+	args   []string
+	bounds image.Rectangle
+}
+
+func newCommandSource(command string) (*commandSource, error) {
+	if !strings.Contains(command, "{file}") {
+		return nil, fmt.Errorf("capture command must contain a {file} placeholder")
+	}
+
+	args := strings.Fields(command)
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil, fmt.Errorf("capture command not found: %s", args[0])
+	}
+
+	// Capture once up front, like the replay backend's first frame, so the
+	// display bounds are known before the first tick and a broken command
+	// fails at startup
+	source := &commandSource{args: args}
+	if _, err := source.Capture(0); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (s *commandSource) Name() string { return "command" }
+
+func (s *commandSource) NumDisplays() int { return 1 }
+
+func (s *commandSource) DisplayBounds(display int) image.Rectangle { return s.bounds }
+
+func (s *commandSource) Capture(display int) (*image.RGBA, error) {
+	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("infogenerator_capture_%d.png", time.Now().UnixNano()))
+	defer os.Remove(tempFile)
+
+	args := make([]string, len(s.args))
+	for i, arg := range s.args {
+		args[i] = strings.ReplaceAll(arg, "{file}", tempFile)
+	}
+
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("capture command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	img, err := loadRGBA(tempFile)
+	if err != nil {
+		return nil, err
+	}
+	s.bounds = img.Bounds()
+	return img, nil
+}
+
+func loadRGBA(path string) (*image.RGBA, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", filepath.Base(path), err)
+	}
+
+	return toRGBA(img), nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
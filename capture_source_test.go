@@ -0,0 +1,39 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommandSourceBounds(t *testing.T) {
+	if _, err := exec.LookPath("cp"); err != nil {
+		t.Skip("cp not available")
+	}
+	frame := filepath.Join(t.TempDir(), "frame.png")
+	file, err := os.Create(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(file, image.NewRGBA(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	source, err := NewCaptureSource(CaptureSettings{Backend: "command", Command: "cp " + frame + " {file}"})
+	if err != nil {
+		t.Fatalf("NewCaptureSource: %v", err)
+	}
+	// Known before the first capture, so the first screenshot is laid out
+	// like the rest
+	if got, want := source.DisplayBounds(0), image.Rect(0, 0, 64, 48); got != want {
+		t.Errorf("DisplayBounds = %v, want %v", got, want)
+	}
+
+	if _, err := NewCaptureSource(CaptureSettings{Backend: "command", Command: "cp " + frame + ".missing {file}"}); err == nil {
+		t.Error("a failing capture command was accepted")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -26,13 +27,13 @@ type ClaudeRequest struct {
 }
 
 type ClaudeMessage struct {
-	Role    string           `json:"role"`
-	Content []ClaudeContent  `json:"content"`
+	Role    string          `json:"role"`
+	Content []ClaudeContent `json:"content"`
 }
 
 type ClaudeContent struct {
-	Type   string            `json:"type"`
-	Text   string            `json:"text,omitempty"`
+	Type   string             `json:"type"`
+	Text   string             `json:"text,omitempty"`
 	Source *ClaudeImageSource `json:"source,omitempty"`
 }
 
@@ -68,14 +69,33 @@ func NewClaudeAnalyzer(apiKey string) *ClaudeAnalyzer {
 	}
 }
 
-func (ca *ClaudeAnalyzer) GenerateSessionSummary(screenshots []Screenshot, analysisPrompt string, studentName string) (string, error) {
+// AnalysisContext carries what is known about a session beyond its
+// screenshots
+type AnalysisContext struct {
+	StudentName string
+	Pauses      []SessionPause
+	Events      []SessionEvent // Bookmarks typed during the session
+}
+
+func (ca *ClaudeAnalyzer) GenerateSessionSummary(screenshots []Screenshot, analysisPrompt string, info AnalysisContext) (string, error) {
 	// Sort screenshots by timestamp
-	sort.Slice(screenshots, func(i, j int) bool {
+	sort.SliceStable(screenshots, func(i, j int) bool {
+		if screenshots[i].Timestamp.Equal(screenshots[j].Timestamp) {
+			return screenshots[i].DisplayIndex < screenshots[j].DisplayIndex
+		}
 		return screenshots[i].Timestamp.Before(screenshots[j].Timestamp)
 	})
 
 	// Sample screenshots for analysis (limit to avoid token limits)
 	sampledScreenshots := ca.sampleScreenshots(screenshots, 8)
+	multiDisplay := len(groupScreenshotsByTick(screenshots)) != len(screenshots)
+
+	unchanged := 0
+	for _, s := range screenshots {
+		if s.IsDuplicate() {
+			unchanged++
+		}
+	}
 
 	fmt.Printf("Analyzing %d screenshots with Claude API...\n", len(sampledScreenshots))
 
@@ -88,12 +108,15 @@ func (ca *ClaudeAnalyzer) GenerateSessionSummary(screenshots []Screenshot, analy
 	messageContent := []ClaudeContent{
 		{
 			Type: "text",
-			Text: fmt.Sprintf("%s\n\nStudent name: %s\nTotal screenshots in session: %d\nScreenshots being analyzed: %d\nSession duration: %s\n\nHere are the screenshots in chronological order:",
+			Text: fmt.Sprintf("%s\n\nStudent name: %s\nTotal screenshots in session: %d\nUnchanged screenshots (screen idle): %d\nScreenshots being analyzed: %d\nSession duration: %s\nApplications in the foreground: %s\n%s\nHere are the screenshots in chronological order:",
 				analysisPrompt,
-				studentName,
+				info.StudentName,
 				len(screenshots),
+				unchanged,
 				len(sampledScreenshots),
-				ca.calculateSessionDuration(screenshots)),
+				ca.calculateSessionDuration(screenshots, info.Pauses),
+				ca.summarizeApplications(screenshots),
+				ca.formatAnnotations(info.Events)),
 		},
 	}
 
@@ -105,11 +128,19 @@ func (ca *ClaudeAnalyzer) GenerateSessionSummary(screenshots []Screenshot, analy
 			continue
 		}
 
-		// Add timestamp info
+		// Add timestamp info, naming the display for multi-monitor ticks
+		label := fmt.Sprintf("\n--- Screenshot %d taken at %s ---",
+			i+1, screenshot.Timestamp.Format("15:04:05 MST"))
+		if multiDisplay {
+			label = fmt.Sprintf("\n--- Screenshot %d taken at %s (display %d) ---",
+				i+1, screenshot.Timestamp.Format("15:04:05 MST"), screenshot.DisplayIndex+1)
+		}
+		if screenshot.WindowClass != "" {
+			label += fmt.Sprintf("\nActive application: %s - %s", screenshot.WindowClass, screenshot.WindowTitle)
+		}
 		messageContent = append(messageContent, ClaudeContent{
 			Type: "text",
-			Text: fmt.Sprintf("\n--- Screenshot %d taken at %s ---",
-				i+1, screenshot.Timestamp.Format("15:04:05 MST")),
+			Text: label,
 		})
 
 		// Add the image
@@ -148,21 +179,51 @@ func (ca *ClaudeAnalyzer) GenerateSessionSummary(screenshots []Screenshot, analy
 	return response.Content[0].Text, nil
 }
 
+// sampleScreenshots picks evenly spaced capture ticks, keeping every
+// display of a chosen tick together so multi-monitor captures stay
+// comparable. Ticks where nothing changed are skipped since they repeat an
+// earlier image. maxCount bounds the number of images returned.
 func (ca *ClaudeAnalyzer) sampleScreenshots(screenshots []Screenshot, maxCount int) []Screenshot {
-	if len(screenshots) <= maxCount {
-		return screenshots
+	var ticks [][]Screenshot
+	var changed []Screenshot
+	for _, tick := range groupScreenshotsByTick(screenshots) {
+		for _, s := range tick {
+			if !s.IsDuplicate() {
+				ticks = append(ticks, tick)
+				changed = append(changed, tick...)
+				break
+			}
+		}
+	}
+
+	if len(changed) <= maxCount {
+		return changed
+	}
+
+	displays := 1
+	for _, tick := range ticks {
+		if len(tick) > displays {
+			displays = len(tick)
+		}
+	}
+	maxTicks := maxCount / displays
+	if maxTicks < 1 {
+		maxTicks = 1
+	}
+	if maxTicks > len(ticks) {
+		maxTicks = len(ticks)
 	}
 
 	// Take evenly spaced samples
-	interval := float64(len(screenshots)) / float64(maxCount)
+	interval := float64(len(ticks)) / float64(maxTicks)
 	sampled := make([]Screenshot, 0, maxCount)
 
-	for i := 0; i < maxCount; i++ {
+	for i := 0; i < maxTicks; i++ {
 		index := int(float64(i) * interval)
-		if index >= len(screenshots) {
-			index = len(screenshots) - 1
+		if index >= len(ticks) {
+			index = len(ticks) - 1
 		}
-		sampled = append(sampled, screenshots[index])
+		sampled = append(sampled, ticks[index]...)
 	}
 
 	return sampled
@@ -242,14 +303,66 @@ Example style: "Riley had a great introduction to Roblox Studio today, starting
 Do not include headers, bullet points, or section breaks - just write a natural paragraph report.`
 }
 
-func (ca *ClaudeAnalyzer) calculateSessionDuration(screenshots []Screenshot) string {
+// summarizeApplications lists recorded foreground applications by how
+// many capture ticks they were active in, most used first
+func (ca *ClaudeAnalyzer) summarizeApplications(screenshots []Screenshot) string {
+	counts := make(map[string]int)
+	for _, tick := range groupScreenshotsByTick(screenshots) {
+		if class := tick[0].WindowClass; class != "" {
+			counts[class]++
+		}
+	}
+	if len(counts) == 0 {
+		return "not recorded"
+	}
+
+	apps := make([]string, 0, len(counts))
+	for app := range counts {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		if counts[apps[i]] == counts[apps[j]] {
+			return apps[i] < apps[j]
+		}
+		return counts[apps[i]] > counts[apps[j]]
+	})
+
+	parts := make([]string, len(apps))
+	for i, app := range apps {
+		parts[i] = fmt.Sprintf("%s (%d captures)", app, counts[app])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatAnnotations lists bookmarks as teacher/student annotations for the
+// prompt, or returns an empty string when there are none
+func (ca *ClaudeAnalyzer) formatAnnotations(events []SessionEvent) string {
+	var lines []string
+	for _, e := range events {
+		if e.Kind != eventKindBookmark || strings.TrimSpace(e.Note) == "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s", e.Timestamp.Format("15:04:05"), e.Note))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\nTeacher/student annotations made during the session (bookmarks):\n" + strings.Join(lines, "\n") + "\n"
+}
+
+// calculateSessionDuration reports active time between the first and last
+// screenshot, leaving out pauses
+func (ca *ClaudeAnalyzer) calculateSessionDuration(screenshots []Screenshot, pauses []SessionPause) string {
 	if len(screenshots) < 2 {
 		return "Unknown"
 	}
 
 	start := screenshots[0].Timestamp
 	end := screenshots[len(screenshots)-1].Timestamp
-	duration := end.Sub(start)
+	duration := activeDuration(start, end, pauses).Round(time.Second).String()
 
-	return duration.Round(time.Second).String()
-}
\ No newline at end of file
+	if paused := pausedDuration(start, end, pauses); paused > 0 {
+		duration += fmt.Sprintf(" active (plus %s paused)", paused.Round(time.Second))
+	}
+	return duration
+}
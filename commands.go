@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type RemoteCommandSettings struct {
+	PollSeconds int `json:"poll_seconds"` // How often a running session asks the webapp for commands (0 = never)
+}
+
+// Remote command types
+const (
+	commandStop           = "stop" // Stop and summarize, like -stop
+	commandPause          = "pause"
+	commandResume         = "resume"
+	commandInterval       = "interval"
+	commandCaptureNow     = "capture_now"
+	commandSetStudentName = "set_student_name"
+)
+
+// RemoteCommand is an instruction for this device queued on the webapp
+type RemoteCommand struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Interval    int    `json:"interval,omitempty"`    // interval: seconds between captures
+	StudentName string `json:"studentName,omitempty"` // set_student_name
+	Reason      string `json:"reason,omitempty"`      // pause: recorded with the pause
+}
+
+// CommandAck reports the outcome of a command back to the webapp
+type CommandAck struct {
+	DeviceID string `json:"deviceId"`
+	ID       string `json:"id"`
+	Status   string `json:"status"` // "done" or "failed"
+	Error    string `json:"error,omitempty"`
+}
+
+// FetchCommands returns the commands waiting on the webapp for a device
+func (c *WebappClient) FetchCommands(deviceID string) ([]RemoteCommand, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/commands?deviceId="+url.QueryEscape(deviceID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := c.signRequest(req, nil); err != nil {
+		return nil, err
+	}
+	var result struct {
+		Commands []RemoteCommand `json:"commands"`
+	}
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return result.Commands, nil
+}
+
+// AckCommand tells the webapp a command was applied or failed, so it stops
+// handing it out
+func (c *WebappClient) AckCommand(ack CommandAck) error {
+	body, err := json.Marshal(ack)
+	if err != nil {
+		return fmt.Errorf("failed to encode acknowledgement: %w", err)
+	}
+	req, err := http.NewRequest("POST", c.baseURL+"/api/commands/ack", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if err := c.signRequest(req, body); err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// signRequest signs a request to the command endpoints, which the webapp
+// refuses without a device signature
+func (c *WebappClient) signRequest(req *http.Request, body []byte) error {
+	if c.signer == nil {
+		return fmt.Errorf("remote commands need a device_token")
+	}
+	return c.signer.SignRequest(req, body)
+}
+
+// deviceID names this machine to the webapp, as in signed uploads
+func (app *App) deviceID() string {
+	return app.config.deviceID()
+}
+
+// ApplyCommand carries out a remote command on the session this process
+// is running
+func (app *App) ApplyCommand(cmd RemoteCommand) error {
+	if !app.running() {
+		return fmt.Errorf("no session is running on this device")
+	}
+
+	switch cmd.Type {
+	case commandStop:
+		if err := app.StopSessionAndSummarize(); err != nil {
+			return err
+		}
+		app.remoteStopOnce.Do(func() { close(app.remoteStopped) })
+	case commandPause:
+		reason := cmd.Reason
+		if reason == "" {
+			reason = "remote"
+		}
+		if err := app.PauseSession(reason); err != nil {
+			return err
+		}
+		// Let the capture loop pick up the new state straight away
+		app.CaptureNow()
+	case commandResume:
+		if err := app.ResumeSession(); err != nil {
+			return err
+		}
+		app.CaptureNow()
+	case commandInterval:
+		return app.SetInterval(cmd.Interval)
+	case commandCaptureNow:
+		app.CaptureNow()
+	case commandSetStudentName:
+		return app.SetStudentName(cmd.StudentName)
+	default:
+		return fmt.Errorf("unknown command %q", cmd.Type)
+	}
+	return nil
+}
+
+// RemoteStopped is closed once a remote stop command has ended the
+// session, so whatever is waiting on the session can return
+func (app *App) RemoteStopped() <-chan struct{} {
+	return app.remoteStopped
+}
+
+// StartRemoteCommands polls the webapp for commands until Close. It does
+// nothing without a webapp, without a device token to sign the polls
+// with, or when polling is turned off.
+func (app *App) StartRemoteCommands() {
+	interval := time.Duration(app.config.RemoteCommands.PollSeconds) * time.Second
+	if app.webapp == nil || interval <= 0 || app.commandStop != nil {
+		return
+	}
+	if app.webapp.signer == nil {
+		fmt.Println("Remote commands are off: they need a device_token shared with the webapp")
+		return
+	}
+	app.commandStop = make(chan struct{})
+	app.commandWG.Add(1)
+	go app.pollCommands(interval, app.commandStop)
+}
+
+func (app *App) stopRemoteCommands() {
+	if app.commandStop == nil {
+		return
+	}
+	close(app.commandStop)
+	app.commandWG.Wait()
+	app.commandStop = nil
+}
+
+func (app *App) pollCommands(interval time.Duration, stop <-chan struct{}) {
+	defer app.commandWG.Done()
+
+	// Outcomes of commands already applied, kept so a command whose
+	// acknowledgement was lost is acknowledged again rather than re-run
+	applied := make(map[string]error)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		commands, err := app.webapp.FetchCommands(app.deviceID())
+		if err != nil {
+			fmt.Printf("Warning: Failed to fetch remote commands: %v\n", err)
+		}
+		for _, cmd := range commands {
+			result, done := applied[cmd.ID]
+			if !done {
+				fmt.Printf("Remote command: %s\n", cmd.Type)
+				result = app.ApplyCommand(cmd)
+				if result != nil {
+					fmt.Printf("Remote command %s failed: %v\n", cmd.Type, result)
+				}
+				applied[cmd.ID] = result
+			}
+
+			ack := CommandAck{DeviceID: app.deviceID(), ID: cmd.ID, Status: "done"}
+			if result != nil {
+				ack.Status, ack.Error = "failed", result.Error()
+			}
+			if err := app.webapp.AckCommand(ack); err != nil {
+				fmt.Printf("Warning: Failed to acknowledge remote command %s: %v\n", cmd.ID, err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
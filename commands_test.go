@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// commandServer stands in for the webapp's command endpoints. It checks
+// signatures the way the webapp routes do, hands out commands until they
+// are acknowledged and, when a command is acknowledged, records whether
+// the device had already applied it.
+type commandServer struct {
+	t        *testing.T
+	verifier *UploadVerifier
+	applied  func(RemoteCommand) bool
+
+	mu           sync.Mutex
+	commands     []RemoteCommand
+	acks         map[string]CommandAck
+	appliedAtAck map[string]bool
+}
+
+func newCommandServer(t *testing.T, commands ...RemoteCommand) (*commandServer, *httptest.Server) {
+	s := &commandServer{
+		t:            t,
+		verifier:     newTestVerifier(),
+		commands:     commands,
+		acks:         make(map[string]CommandAck),
+		appliedAtAck: make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/commands", s.poll)
+	mux.HandleFunc("/api/commands/ack", s.ack)
+	// Session updates and screenshots from the running session
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true}`))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+func (s *commandServer) poll(w http.ResponseWriter, r *http.Request) {
+	device, _, err := s.verifier.VerifyRequest(r)
+	if err != nil {
+		s.t.Errorf("command poll rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("deviceId") != device {
+		s.t.Errorf("poll for %q signed by %q", r.URL.Query().Get("deviceId"), device)
+		http.Error(w, "signed by a different device", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := []RemoteCommand{}
+	for _, cmd := range s.commands {
+		if _, acked := s.acks[cmd.ID]; !acked {
+			pending = append(pending, cmd)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"commands": pending})
+}
+
+func (s *commandServer) ack(w http.ResponseWriter, r *http.Request) {
+	device, body, err := s.verifier.VerifyRequest(r)
+	if err != nil {
+		s.t.Errorf("acknowledgement rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var ack CommandAck
+	if err := json.Unmarshal(body, &ack); err != nil || ack.DeviceID != device {
+		s.t.Errorf("acknowledgement %s from %q signed by %q (%v)", body, ack.DeviceID, device, err)
+		http.Error(w, "bad acknowledgement", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cmd := range s.commands {
+		if cmd.ID == ack.ID {
+			if _, repeated := s.acks[cmd.ID]; !repeated {
+				s.appliedAtAck[cmd.ID] = s.applied(cmd)
+			}
+		}
+	}
+	s.acks[ack.ID] = ack
+	w.Write([]byte(`{"success": true}`))
+}
+
+func (s *commandServer) acked(id string) (CommandAck, bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ack, ok := s.acks[id]
+	return ack, s.appliedAtAck[id], ok
+}
+
+func TestRemoteCommands(t *testing.T) {
+	commands := []RemoteCommand{
+		{ID: "1", Type: commandPause, Reason: "lunch"},
+		{ID: "2", Type: commandSetStudentName, StudentName: "Grace"},
+		{ID: "3", Type: commandStop},
+	}
+	server, ts := newCommandServer(t, commands...)
+	app := newTestApp(t, map[string]interface{}{
+		"webapp_url":      ts.URL,
+		"device_id":       testDevice,
+		"device_token":    testToken,
+		"remote_commands": map[string]int{"poll_seconds": 1},
+	})
+
+	if err := app.StartSessionInBackground(3600, "Ada", "Remote command test"); err != nil {
+		t.Fatalf("StartSessionInBackground: %v", err)
+	}
+	session, err := app.sessionManager.GetActiveSession()
+	if err != nil || session == nil {
+		t.Fatalf("GetActiveSession = %v, %v", session, err)
+	}
+
+	// Each acknowledgement must come after the command took effect
+	server.applied = func(cmd RemoteCommand) bool {
+		switch cmd.Type {
+		case commandPause:
+			paused, err := app.sessionManager.IsPaused(session.ID)
+			return err == nil && paused
+		case commandSetStudentName:
+			stored, err := app.sessionManager.GetSessionByID(session.ID)
+			return err == nil && stored.StudentName == cmd.StudentName
+		case commandStop:
+			stored, err := app.sessionManager.GetSessionByID(session.ID)
+			return err == nil && !app.running() && stored.Status == "completed"
+		}
+		return false
+	}
+	app.StartRemoteCommands()
+
+	select {
+	case <-app.RemoteStopped():
+	case <-time.After(10 * time.Second):
+		t.Fatal("remote stop did not end the session")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for _, cmd := range commands {
+		ack, applied, ok := server.acked(cmd.ID)
+		for !ok && time.Now().Before(deadline) {
+			time.Sleep(20 * time.Millisecond)
+			ack, applied, ok = server.acked(cmd.ID)
+		}
+		if !ok {
+			t.Errorf("%s was never acknowledged", cmd.Type)
+			continue
+		}
+		if ack.Status != "done" || ack.DeviceID != testDevice {
+			t.Errorf("%s acknowledged as %+v", cmd.Type, ack)
+		}
+		if !applied {
+			t.Errorf("%s acknowledged before it was applied", cmd.Type)
+		}
+	}
+
+	pauses, err := app.sessionManager.GetSessionPauses(session.ID)
+	if err != nil || len(pauses) != 1 || pauses[0].Reason != "lunch" {
+		t.Errorf("pauses = %+v, %v; want one for lunch", pauses, err)
+	}
+}
+
+func TestRemoteCommandFailureAcknowledged(t *testing.T) {
+	server, ts := newCommandServer(t, RemoteCommand{ID: "1", Type: "reboot"})
+	server.applied = func(RemoteCommand) bool { return true }
+	app := newTestApp(t, map[string]interface{}{
+		"webapp_url":      ts.URL,
+		"device_id":       testDevice,
+		"device_token":    testToken,
+		"remote_commands": map[string]int{"poll_seconds": 1},
+	})
+	if err := app.StartSessionInBackground(3600, "Ada", "Remote command test"); err != nil {
+		t.Fatalf("StartSessionInBackground: %v", err)
+	}
+	app.StartRemoteCommands()
+
+	deadline := time.Now().Add(5 * time.Second)
+	ack, _, ok := server.acked("1")
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		ack, _, ok = server.acked("1")
+	}
+	if !ok || ack.Status != "failed" || ack.Error == "" {
+		t.Errorf("unknown command acknowledged as %+v (%v), want failed with the error", ack, ok)
+	}
+	app.StopSession()
+}
+
+func TestFetchCommandsNeedsToken(t *testing.T) {
+	_, ts := newCommandServer(t)
+	client := NewWebappClient(ts.URL, nil, nil)
+	if _, err := client.FetchCommands(testDevice); err == nil {
+		t.Error("unsigned command poll was sent")
+	}
+}
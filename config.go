@@ -8,25 +8,58 @@ import (
 )
 
 type Config struct {
-	DataDir            string `json:"data_dir"`
-	OpenAIAPIKey       string `json:"openai_api_key"`
-	ClaudeAPIKey       string `json:"claude_api_key"`
-	AnalysisPrompt     string `json:"analysis_prompt"`
-	UseOfflineAnalysis bool   `json:"use_offline_analysis"`
-	EnableAIEnhancement bool   `json:"enable_ai_enhancement"`
-	PreferClaude       bool   `json:"prefer_claude"`
-	WebappURL          string `json:"webapp_url"`
-	ScreenshotSettings ScreenshotSettings `json:"screenshot_settings"`
-	TimelapseSettings  TimelapseSettings  `json:"timelapse_settings"`
+	DataDir             string                  `json:"data_dir"`
+	OpenAIAPIKey        string                  `json:"openai_api_key"`
+	ClaudeAPIKey        string                  `json:"claude_api_key"`
+	AnalysisPrompt      string                  `json:"analysis_prompt"`
+	UseOfflineAnalysis  bool                    `json:"use_offline_analysis"`
+	EnableAIEnhancement bool                    `json:"enable_ai_enhancement"`
+	PreferClaude        bool                    `json:"prefer_claude"`
+	WebappURL           string                  `json:"webapp_url"`
+	DeviceID            string                  `json:"device_id"`    // Identifies this machine to the webapp
+	DeviceToken         string                  `json:"device_token"` // Shared secret used to sign uploads; empty sends them unsigned
+	DeviceUUID          string                  `json:"-"`            // Generated per install, stored in data_dir
+	ScreenshotSettings  ScreenshotSettings      `json:"screenshot_settings"`
+	TimelapseSettings   TimelapseSettings       `json:"timelapse_settings"`
+	CaptureSettings     CaptureSettings         `json:"capture_settings"`
+	CapturePolicy       CapturePolicySettings   `json:"capture_policy"`
+	Redactions          []RedactionRule         `json:"redactions"`
+	ThumbnailSettings   ThumbnailSettings       `json:"thumbnail_settings"`
+	Schedule            ScheduleSettings        `json:"schedule"`
+	Pipeline            PipelineSettings        `json:"pipeline"`
+	UploadOutbox        OutboxSettings          `json:"upload_outbox"`
+	Sinks               []SinkSettings          `json:"sinks"` // Where uploads go besides webapp_url
+	UploadRendition     UploadRenditionSettings `json:"upload_rendition"`
+	Sync                SyncSettings            `json:"sync"`
+	RemoteCommands      RemoteCommandSettings   `json:"remote_commands"`
+	Heartbeat           HeartbeatSettings       `json:"heartbeat"`
+	Retention           RetentionSettings       `json:"retention"`
 }
 
 type ScreenshotSettings struct {
-	Quality       int  `json:"quality"`        // JPEG quality 1-100
-	Compress      bool `json:"compress"`       // Enable compression
-	MaxFileSize   int  `json:"max_file_size"`  // Max file size in MB
+	Quality          int    `json:"quality"`            // JPEG quality 1-100
+	Compress         bool   `json:"compress"`           // Enable compression
+	MaxFileSize      int    `json:"max_file_size"`      // Max file size in MB
+	DisplayMode      string `json:"display_mode"`       // "primary", "all" (one file per display), "stitched"
+	MaxWidth         int    `json:"max_width"`          // Downscale wider captures when compressing (0 = keep)
+	MaxHeight        int    `json:"max_height"`         // Downscale taller captures when compressing (0 = keep)
+	MinQuality       int    `json:"min_quality"`        // Lowest JPEG quality used to fit under max_file_size
+	Dedup            bool   `json:"dedup"`              // Reference the previous file instead of saving unchanged frames
+	DedupMaxDistance int    `json:"dedup_max_distance"` // Max perceptual hash distance (of 256 bits) treated as unchanged
 }
 
 func LoadConfig(configPath string) (*Config, error) {
+	return loadConfig(configPath, true)
+}
+
+// readConfig is LoadConfig without touching the disk, for dry runs: a
+// missing config file gives the defaults, and DeviceUUID is left empty
+// until one has been generated
+func readConfig(configPath string) (*Config, error) {
+	return loadConfig(configPath, false)
+}
+
+func loadConfig(configPath string, create bool) (*Config, error) {
 	// Get executable directory first
 	execDir, err := getExecutableDir()
 	if err != nil {
@@ -44,15 +77,70 @@ func LoadConfig(configPath string) (*Config, error) {
 		PreferClaude:        true,
 		WebappURL:           "", // Set to your Vercel app URL
 		ScreenshotSettings: ScreenshotSettings{
-			Quality:     80,
-			Compress:    true,
-			MaxFileSize: 5,
+			Quality:          80,
+			Compress:         true,
+			MaxFileSize:      5,
+			DisplayMode:      "primary",
+			MaxWidth:         1920,
+			MaxHeight:        1080,
+			MinQuality:       40,
+			Dedup:            false,
+			DedupMaxDistance: 3,
 		},
 		TimelapseSettings: TimelapseSettings{
 			FPS:     2,
 			Quality: "medium",
 			Format:  "mp4",
 		},
+		CaptureSettings: CaptureSettings{
+			Backend:          "screen",
+			SyntheticWidth:   1280,
+			SyntheticHeight:  720,
+			RecordWindowInfo: true,
+		},
+		ThumbnailSettings: ThumbnailSettings{
+			Width:                240,
+			Quality:              70,
+			ContactSheetColumns:  6,
+			MaxContactSheetTiles: 120,
+		},
+		Pipeline: PipelineSettings{
+			QueueSize:  8,
+			DropPolicy: "drop_oldest",
+		},
+		UploadOutbox: OutboxSettings{
+			MaxAttempts:      10,
+			BaseDelaySeconds: 5,
+			MaxDelaySeconds:  600,
+			Workers:          2,
+			BatchThreshold:   20,
+			BatchSize:        5,
+		},
+		Sync: SyncSettings{
+			ConflictPolicy: conflictRemoteWins,
+		},
+		RemoteCommands: RemoteCommandSettings{
+			PollSeconds: 15,
+		},
+		Heartbeat: HeartbeatSettings{
+			IntervalSeconds: 60,
+		},
+		Retention: RetentionSettings{
+			PruneAtStartup: true,
+		},
+		Schedule: ScheduleSettings{
+			Interval:            30,
+			MinRemainingMinutes: 5,
+			DefaultStudentName:  "Student",
+		},
+		CapturePolicy: CapturePolicySettings{
+			Type:            "fixed",
+			JitterSeconds:   10,
+			MinInterval:     10,
+			MaxInterval:     120,
+			ChangeThreshold: 0.05,
+			ProbeInterval:   5,
+		},
 	}
 
 	// Make config path absolute if it's not
@@ -63,21 +151,34 @@ func LoadConfig(configPath string) (*Config, error) {
 	// Check if config file exists
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
 		// Create default config file
-		if err := config.Save(configPath); err != nil {
-			return nil, fmt.Errorf("failed to create default config: %w", err)
+		if create {
+			if err := config.Save(configPath); err != nil {
+				return nil, fmt.Errorf("failed to create default config: %w", err)
+			}
+			fmt.Printf("Created default configuration file: %s\n", configPath)
+		}
+	} else {
+		// Load existing config file
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
-		fmt.Printf("Created default configuration file: %s\n", configPath)
-		return config, nil
-	}
 
-	// Load existing config file
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %w", err)
+		}
 
-	if err := json.Unmarshal(data, config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+		// Configs from before min_quality may set a lower quality than its
+		// default; only an explicit min_quality above quality is an error
+		var explicit struct {
+			ScreenshotSettings struct {
+				MinQuality *int `json:"min_quality"`
+			} `json:"screenshot_settings"`
+		}
+		json.Unmarshal(data, &explicit)
+		if explicit.ScreenshotSettings.MinQuality == nil && config.ScreenshotSettings.MinQuality > config.ScreenshotSettings.Quality {
+			config.ScreenshotSettings.MinQuality = config.ScreenshotSettings.Quality
+		}
 	}
 
 	// Always ensure data directory is relative to executable directory
@@ -85,6 +186,28 @@ func LoadConfig(configPath string) (*Config, error) {
 		config.DataDir = filepath.Join(execDir, config.DataDir)
 	}
 
+	if config.CaptureSettings.ReplayDir != "" && !filepath.IsAbs(config.CaptureSettings.ReplayDir) {
+		config.CaptureSettings.ReplayDir = filepath.Join(execDir, config.CaptureSettings.ReplayDir)
+	}
+
+	if create {
+		if config.DeviceUUID, err = loadDeviceUUID(config.DataDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Heartbeat.StatusFile != "" && !filepath.IsAbs(config.Heartbeat.StatusFile) {
+		config.Heartbeat.StatusFile = filepath.Join(execDir, config.Heartbeat.StatusFile)
+	}
+
+	if config.Schedule.ICSFile != "" && !filepath.IsAbs(config.Schedule.ICSFile) {
+		config.Schedule.ICSFile = filepath.Join(execDir, config.Schedule.ICSFile)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", configPath, err)
+	}
+
 	return config, nil
 }
 
@@ -112,9 +235,38 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("max_file_size must be at least 1 MB")
 	}
 
+	if c.ScreenshotSettings.MinQuality < 0 || c.ScreenshotSettings.MinQuality > c.ScreenshotSettings.Quality {
+		return fmt.Errorf("min_quality must be between 0 and quality")
+	}
+
+	for i, rule := range c.Redactions {
+		if err := rule.validate(i); err != nil {
+			return err
+		}
+	}
+
+	switch c.ScreenshotSettings.DisplayMode {
+	case "", "primary", "all", "stitched":
+	default:
+		return fmt.Errorf("display_mode must be primary, all or stitched")
+	}
+
+	if err := validateSinks(sinkSettings(c)); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// deviceID names this machine to the webapp: device_id when set, otherwise
+// the install's device UUID so unconfigured machines stay apart
+func (c *Config) deviceID() string {
+	if c.DeviceID != "" {
+		return c.DeviceID
+	}
+	return c.DeviceUUID
+}
+
 func getExecutableDir() (string, error) {
 	execPath, err := os.Executable()
 	if err != nil {
@@ -126,4 +278,4 @@ func getExecutableDir() (string, error) {
 // Helper functions for file operations (to avoid import issues)
 func createFile(path string) (*os.File, error) {
 	return os.Create(path)
-}
\ No newline at end of file
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestLoadConfigMinQuality(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     int // min_quality after loading; 0 when loading must fail
+	}{
+		{"default", map[string]interface{}{}, 40},
+		{"legacy low quality", map[string]interface{}{"quality": 30}, 30},
+		{"explicit", map[string]interface{}{"quality": 30, "min_quality": 20}, 20},
+		{"explicit above quality", map[string]interface{}{"quality": 30, "min_quality": 40}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfig(writeTestConfig(t, map[string]interface{}{"screenshot_settings": tt.settings}))
+			if tt.want == 0 {
+				if err == nil {
+					t.Errorf("loaded with min_quality %d above quality %d", config.ScreenshotSettings.MinQuality, config.ScreenshotSettings.Quality)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig: %v", err)
+			}
+			if got := config.ScreenshotSettings.MinQuality; got != tt.want {
+				t.Errorf("min_quality = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
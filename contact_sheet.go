@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+)
+
+type ThumbnailSettings struct {
+	Width                int `json:"width"`                   // Thumbnail width in pixels
+	Quality              int `json:"quality"`                 // Thumbnail JPEG quality
+	ContactSheetColumns  int `json:"contact_sheet_columns"`   // Thumbnails per contact sheet row
+	MaxContactSheetTiles int `json:"max_contact_sheet_tiles"` // Captures shown before sampling
+}
+
+const (
+	sheetPadding     = 8
+	sheetLabelHeight = 22
+	glyphScale       = 3
+)
+
+// thumbnailPath returns where the thumbnail for a screenshot file lives:
+// a "thumbs" folder next to it
+func thumbnailPath(screenshotPath string) string {
+	return filepath.Join(filepath.Dir(screenshotPath), "thumbs", "thumb_"+filepath.Base(screenshotPath))
+}
+
+// encodeThumbnail returns a small JPEG copy of img
+func encodeThumbnail(img image.Image, settings ThumbnailSettings) ([]byte, error) {
+	return encodeJPEG(scaleToFit(img, settings.Width, 0), settings.Quality)
+}
+
+// writeThumbnail saves an encoded thumbnail, creating the thumbs folder
+func writeThumbnail(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// GenerateContactSheet lays out one timestamped thumbnail per capture tick
+// in a grid. Ticks where nothing changed are left out, and long sessions
+// are sampled down to MaxContactSheetTiles.
+func GenerateContactSheet(screenshots []Screenshot, outputPath string, settings ThumbnailSettings) error {
+	var ticks [][]Screenshot
+	for _, tick := range groupScreenshotsByTick(screenshots) {
+		for _, s := range tick {
+			if !s.IsDuplicate() {
+				ticks = append(ticks, tick)
+				break
+			}
+		}
+	}
+	if len(ticks) == 0 {
+		return fmt.Errorf("no screenshots for contact sheet")
+	}
+
+	if settings.MaxContactSheetTiles > 0 && len(ticks) > settings.MaxContactSheetTiles {
+		sampled := make([][]Screenshot, 0, settings.MaxContactSheetTiles)
+		interval := float64(len(ticks)) / float64(settings.MaxContactSheetTiles)
+		for i := 0; i < settings.MaxContactSheetTiles; i++ {
+			sampled = append(sampled, ticks[int(float64(i)*interval)])
+		}
+		ticks = sampled
+	}
+
+	// Build tiles first so the grid cell can fit the largest one
+	tiles := make([]image.Image, 0, len(ticks))
+	cellW, cellH := 0, 0
+	for _, tick := range ticks {
+		tile, err := tickThumbnail(tick, settings)
+		if err != nil {
+			fmt.Printf("Warning: Skipping %s in contact sheet: %v\n", filepath.Base(tick[0].FilePath), err)
+			tile = image.NewRGBA(image.Rect(0, 0, settings.Width, settings.Width*9/16))
+		}
+		tiles = append(tiles, tile)
+		if tile.Bounds().Dx() > cellW {
+			cellW = tile.Bounds().Dx()
+		}
+		if tile.Bounds().Dy() > cellH {
+			cellH = tile.Bounds().Dy()
+		}
+	}
+
+	columns := settings.ContactSheetColumns
+	if columns <= 0 {
+		columns = 6
+	}
+	if columns > len(tiles) {
+		columns = len(tiles)
+	}
+	rows := (len(tiles) + columns - 1) / columns
+
+	cellH += sheetLabelHeight
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		sheetPadding+columns*(cellW+sheetPadding),
+		sheetPadding+rows*(cellH+sheetPadding)))
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{color.RGBA{32, 32, 32, 255}}, image.Point{}, draw.Src)
+
+	for i, tile := range tiles {
+		x := sheetPadding + (i%columns)*(cellW+sheetPadding)
+		y := sheetPadding + (i/columns)*(cellH+sheetPadding)
+
+		b := tile.Bounds()
+		draw.Draw(sheet, image.Rect(x, y, x+b.Dx(), y+b.Dy()), tile, b.Min, draw.Src)
+		drawText(sheet, x, y+b.Dy()+4, ticks[i][0].Timestamp.Format("15:04:05"), color.White)
+	}
+
+	data, err := encodeJPEG(sheet, 85)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(outputPath, data, 0644)
+}
+
+// tickThumbnail returns the thumbnail for a capture tick, placing the
+// displays of multi-monitor ticks side by side. Screenshots recorded
+// before thumbnails existed are scaled from the full image.
+func tickThumbnail(tick []Screenshot, settings ThumbnailSettings) (image.Image, error) {
+	var images []image.Image
+	for _, s := range tick {
+		path := s.ThumbnailPath
+		if path == "" {
+			path = s.FilePath
+		}
+		img, err := loadRGBA(path)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+
+	tile := images[0]
+	if len(images) > 1 {
+		tile = stitchSideBySide(images)
+	}
+	return scaleToFit(tile, settings.Width*len(images), 0), nil
+}
+
+// glyphs is a 3x5 bitmap font covering the characters used in timestamps
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	':': {"...", ".#.", "...", ".#.", "..."},
+	'-': {"...", "...", "###", "...", "..."},
+	' ': {"...", "...", "...", "...", "..."},
+}
+
+// drawText renders text at (x, y) with the built-in bitmap font,
+// skipping characters it has no glyph for
+func drawText(img *image.RGBA, x, y int, text string, c color.Color) {
+	for _, r := range text {
+		glyph, ok := glyphs[r]
+		if !ok {
+			continue
+		}
+		for gy, row := range glyph {
+			for gx, cell := range row {
+				if cell != '#' {
+					continue
+				}
+				px := x + gx*glyphScale
+				py := y + gy*glyphScale
+				draw.Draw(img, image.Rect(px, py, px+glyphScale, py+glyphScale), &image.Uniform{c}, image.Point{}, draw.Src)
+			}
+		}
+		x += 4 * glyphScale
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadDeviceUUID(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	id, err := loadDeviceUUID(dir)
+	if err != nil || !uuidPattern.MatchString(id) {
+		t.Fatalf("first load = %q, %v", id, err)
+	}
+	if again, err := loadDeviceUUID(dir); err != nil || again != id {
+		t.Errorf("second load = %q, %v; want %q", again, err, id)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, deviceUUIDFile), []byte("lab-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := loadDeviceUUID(dir); err == nil {
+		t.Errorf("loaded %q from a damaged device_uuid file", id)
+	}
+}
+
+func TestGlobalIDsQualifiedOnUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	sm := openTestSessionManager(t, dir)
+	session, err := sm.StartSession("Fractions", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testDeviceUUID + "_" + legacyGlobalID(session.ID, session.StartTime)
+	if session.GlobalID() != want {
+		t.Fatalf("GlobalID = %q, want %q", session.GlobalID(), want)
+	}
+
+	// A database from before device UUIDs and schema versions, with a
+	// pending upload and a -sync record under the session's old ID
+	if _, err := sm.db.Exec("UPDATE sessions SET global_id = NULL WHERE id = ?", session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.db.Exec("DROP TABLE schema_version"); err != nil {
+		t.Fatal(err)
+	}
+	legacy := legacyGlobalID(session.ID, session.StartTime)
+	outbox := NewUploadOutbox(sm.db, webappSinks("http://127.0.0.1:0"), OutboxSettings{})
+	if err := outbox.Enqueue(session.ID, legacy, CapturedFrame{FilePath: "a.jpg", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.recordSync(session.ID, syncItem{Kind: syncScreenshot, FilePath: "b.jpg"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	sm.Close()
+
+	sm = openTestSessionManager(t, dir)
+	stored, err := sm.GetSessionByID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.GlobalID() != want || stored.LegacyGlobalID != legacy {
+		t.Errorf("upgraded IDs = %q (legacy %q), want %q (legacy %q)", stored.GlobalID(), stored.LegacyGlobalID, want, legacy)
+	}
+	var queued string
+	if err := sm.db.QueryRow("SELECT global_session_id FROM upload_queue WHERE session_id = ?", session.ID).Scan(&queued); err != nil || queued != want {
+		t.Errorf("queued upload follows %q (%v), want %q", queued, err, want)
+	}
+	var synced int
+	if err := sm.db.QueryRow("SELECT COUNT(*) FROM sync_files WHERE session_id = ?", session.ID).Scan(&synced); err != nil || synced != 0 {
+		t.Errorf("%d -sync records kept (%v); the session must be republished", synced, err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+import "fmt"
+
+func diskFree(dir string) (uint64, error) {
+	return 0, fmt.Errorf("free space is not available on this platform")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeReplayFrames writes n distinct PNG frames for the replay backend
+func writeReplayFrames(t *testing.T, n, width, height int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				// Stripes that move with each frame, on a white background
+				c := color.RGBA{255, 255, 255, 255}
+				if (x/20+i)%3 == 0 {
+					c = color.RGBA{uint8(80 * i), 120, 200, 255}
+				}
+				img.Set(x, y, c)
+			}
+		}
+		file, err := os.Create(filepath.Join(dir, string(rune('a'+i))+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(file, img); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+	}
+	return dir
+}
+
+// waitForScreenshots waits until the session has n screenshot rows
+func waitForScreenshots(t *testing.T, app *App, sessionID, n int) []Screenshot {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		screenshots, err := app.sessionManager.GetSessionScreenshots(sessionID)
+		if err != nil {
+			t.Fatalf("GetSessionScreenshots: %v", err)
+		}
+		if len(screenshots) >= n {
+			return screenshots
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d screenshots recorded, want %d", len(screenshots), n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func decodeJPEG(t *testing.T, path string) image.Image {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := jpeg.Decode(file)
+	if err != nil {
+		t.Fatalf("%s is not a JPEG: %v", filepath.Base(path), err)
+	}
+	return img
+}
+
+// TestReplaySession runs recorded frames through the whole capture
+// pipeline, headless: capture, redaction, encoding, thumbnails, the
+// database and the end-of-session summary
+func TestReplaySession(t *testing.T) {
+	const frames, width, height = 3, 640, 400
+	app := newTestApp(t, map[string]interface{}{
+		"capture_settings": map[string]interface{}{
+			"backend":            "replay",
+			"replay_dir":         writeReplayFrames(t, frames, width, height),
+			"record_window_info": false,
+		},
+		"redactions": []map[string]interface{}{
+			{"name": "taskbar", "display": -1, "type": "band", "edge": "top", "size": 40},
+		},
+	})
+
+	if err := app.StartSessionInBackground(3600, "Ada", "Replay test"); err != nil {
+		t.Fatalf("StartSessionInBackground: %v", err)
+	}
+	session, err := app.sessionManager.GetActiveSession()
+	if err != nil || session == nil {
+		t.Fatalf("GetActiveSession = %v, %v", session, err)
+	}
+	waitForScreenshots(t, app, session.ID, 1)
+	for n := 2; n <= frames; n++ {
+		app.CaptureNow()
+		waitForScreenshots(t, app, session.ID, n)
+	}
+
+	if err := app.StopSessionAndSummarize(); err != nil {
+		t.Fatalf("StopSessionAndSummarize: %v", err)
+	}
+
+	stored, err := app.sessionManager.GetSessionByID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != "completed" || stored.EndTime.IsZero() {
+		t.Errorf("session %s, ended %v; want completed", stored.Status, stored.EndTime)
+	}
+
+	screenshots := waitForScreenshots(t, app, session.ID, frames)
+	if len(screenshots) != frames {
+		t.Fatalf("%d screenshots recorded, want %d", len(screenshots), frames)
+	}
+	seen := make(map[string]bool)
+	for i, shot := range screenshots {
+		if shot.Tick != i+1 || shot.Width != width || shot.Height != height || shot.Hash == "" {
+			t.Errorf("screenshot %d = %+v", i, shot)
+		}
+		if shot.Redactions != "taskbar" {
+			t.Errorf("screenshot %d redactions = %q, want taskbar", i, shot.Redactions)
+		}
+		if seen[shot.FilePath] {
+			t.Errorf("screenshot %d reuses %s", i, shot.FilePath)
+		}
+		seen[shot.FilePath] = true
+
+		info, err := os.Stat(shot.FilePath)
+		if err != nil {
+			t.Errorf("screenshot %d: %v", i, err)
+			continue
+		}
+		if info.Size() != shot.FileSize {
+			t.Errorf("screenshot %d is %d bytes, recorded as %d", i, info.Size(), shot.FileSize)
+		}
+		if _, err := os.Stat(shot.ThumbnailPath); shot.ThumbnailPath == "" || err != nil {
+			t.Errorf("screenshot %d thumbnail %q: %v", i, shot.ThumbnailPath, err)
+		}
+	}
+
+	// Frames were saved in replay order, with the redacted band black
+	first, second := decodeJPEG(t, screenshots[0].FilePath), decodeJPEG(t, screenshots[1].FilePath)
+	if r, g, b, _ := first.At(width/2, 10).RGBA(); r>>8 > 16 || g>>8 > 16 || b>>8 > 16 {
+		t.Errorf("redacted pixel is (%d, %d, %d), want black", r>>8, g>>8, b>>8)
+	}
+	if r, _, b, _ := first.At(5, height/2).RGBA(); r>>8 > 40 || b>>8 < 160 {
+		t.Errorf("first screenshot does not start with a stripe")
+	}
+	if r, _, b, _ := second.At(5, height/2).RGBA(); r>>8 < 220 || b>>8 < 220 {
+		t.Errorf("second screenshot does not start with background")
+	}
+
+	sessionDir := app.sessionManager.GetSessionDir(session.ID)
+	for _, name := range []string{"summary.txt", "session_info.txt", "contact_sheet.jpg"} {
+		if _, err := os.Stat(filepath.Join(sessionDir, name)); err != nil {
+			t.Errorf("session output %s: %v", name, err)
+		}
+	}
+}
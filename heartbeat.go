@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type HeartbeatSettings struct {
+	IntervalSeconds int    `json:"interval_seconds"` // How often status is written and sent (0 = never)
+	StatusFile      string `json:"status_file"`      // Local status file (default: status.json in data_dir)
+}
+
+// Device states reported in a heartbeat
+const (
+	deviceCapturing = "capturing"
+	devicePaused    = "paused"
+	deviceIdle      = "idle"    // Running without a session, e.g. between scheduled classes
+	deviceStopped   = "stopped" // The process exited cleanly
+)
+
+// DeviceStatus is one heartbeat: what a machine is doing and whether it
+// can keep doing it
+type DeviceStatus struct {
+	DeviceID        string     `json:"deviceId"`
+	DeviceUUID      string     `json:"deviceUuid"`
+	Hostname        string     `json:"hostname"`
+	PID             int        `json:"pid"`
+	State           string     `json:"state"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+	IntervalSeconds int        `json:"intervalSeconds"` // A heartbeat older than a few of these means the process died
+	SessionID       string     `json:"sessionId,omitempty"`
+	Screenshots     int        `json:"screenshots"` // Captured in the current session
+	LastCaptureAt   *time.Time `json:"lastCaptureAt,omitempty"`
+	LastError       string     `json:"lastCaptureError,omitempty"`
+	LastErrorAt     *time.Time `json:"lastCaptureErrorAt,omitempty"`
+	UploadsPending  int        `json:"uploadsPending"`
+	UploadsFailed   int        `json:"uploadsFailed"`
+	DiskFreeBytes   uint64     `json:"diskFreeBytes"`
+	DiskError       string     `json:"diskError,omitempty"`
+	FFmpeg          bool       `json:"ffmpeg"`
+}
+
+// captureHealth remembers the outcome of recent captures for heartbeats
+type captureHealth struct {
+	mu          sync.Mutex
+	lastCapture time.Time
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// record notes a stage error, or with nil a screenshot stored in the
+// database
+func (h *captureHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.lastError, h.lastErrorAt = err.Error(), time.Now()
+		return
+	}
+	h.lastCapture = time.Now()
+}
+
+// statusSink is implemented by upload sinks that can receive heartbeats
+type statusSink interface {
+	SendHeartbeat(status DeviceStatus) error
+}
+
+// SendHeartbeat reports device status to the webapp's dashboard
+func (c *WebappClient) SendHeartbeat(status DeviceStatus) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode heartbeat: %w", err)
+	}
+	req, err := http.NewRequest("POST", c.baseURL+"/api/heartbeat", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.signer != nil {
+		if err := c.signer.SignRequest(req, body); err != nil {
+			return err
+		}
+	}
+	return c.do(req, nil)
+}
+
+// SendHeartbeat keeps devices/<device UUID>.json up to date next to the
+// session folders. The UUID is unique per install even when device_id is
+// shared or unset.
+func (s *storeSink) SendHeartbeat(status DeviceStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.store.put(path.Join("devices", status.DeviceUUID+".json"), data, "application/json")
+}
+
+// statusFilePath is where heartbeats are written locally
+func statusFilePath(config *Config) string {
+	if config.Heartbeat.StatusFile != "" {
+		return config.Heartbeat.StatusFile
+	}
+	return filepath.Join(config.DataDir, "status.json")
+}
+
+// DeviceStatus gathers the current heartbeat
+func (app *App) DeviceStatus() DeviceStatus {
+	status := DeviceStatus{
+		DeviceID:        app.deviceID(),
+		DeviceUUID:      app.config.DeviceUUID,
+		PID:             os.Getpid(),
+		State:           deviceIdle,
+		UpdatedAt:       time.Now(),
+		IntervalSeconds: app.config.Heartbeat.IntervalSeconds,
+		FFmpeg:          app.ffmpegAvailable(),
+	}
+	status.Hostname, _ = os.Hostname()
+
+	// Read-only: this runs beside the capture loop and must not swap the
+	// session it records into
+	if session, err := app.sessionManager.FindActiveSession(); err == nil && session != nil && app.running() {
+		status.State = deviceCapturing
+		if paused, _ := app.sessionManager.IsPaused(session.ID); paused {
+			status.State = devicePaused
+		}
+		status.SessionID = session.GlobalID()
+		app.sessionManager.db.QueryRow("SELECT COUNT(*) FROM screenshots WHERE session_id = ?", session.ID).Scan(&status.Screenshots)
+	}
+
+	app.health.mu.Lock()
+	if !app.health.lastCapture.IsZero() {
+		at := app.health.lastCapture
+		status.LastCaptureAt = &at
+	}
+	if app.health.lastError != "" {
+		at := app.health.lastErrorAt
+		status.LastError, status.LastErrorAt = app.health.lastError, &at
+	}
+	app.health.mu.Unlock()
+
+	status.UploadsPending, status.UploadsFailed, _ = app.UploadQueueDepth()
+	free, err := diskFree(app.config.DataDir)
+	if err != nil {
+		status.DiskError = err.Error()
+	}
+	status.DiskFreeBytes = free
+	return status
+}
+
+// ffmpegAvailable checks for FFmpeg once; it won't appear or vanish while
+// the process runs, and the check starts a process
+func (app *App) ffmpegAvailable() bool {
+	app.ffmpegOnce.Do(func() {
+		app.ffmpeg = NewTimelapseGenerator().CheckFFmpegAvailable()
+	})
+	return app.ffmpeg
+}
+
+// StartHeartbeat writes the status file and sends a heartbeat to the upload
+// sinks every interval until Close
+func (app *App) StartHeartbeat() {
+	interval := time.Duration(app.config.Heartbeat.IntervalSeconds) * time.Second
+	if interval <= 0 || app.heartbeatStop != nil {
+		return
+	}
+	app.heartbeatStop = make(chan struct{})
+	app.heartbeatWG.Add(1)
+	go func(stop <-chan struct{}) {
+		defer app.heartbeatWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			app.sendHeartbeat(app.DeviceStatus())
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}(app.heartbeatStop)
+}
+
+// stopHeartbeat ends the heartbeat with a final "stopped" status, so a
+// clean exit isn't mistaken for a crash
+func (app *App) stopHeartbeat() {
+	if app.heartbeatStop == nil {
+		return
+	}
+	close(app.heartbeatStop)
+	app.heartbeatWG.Wait()
+	app.heartbeatStop = nil
+
+	status := app.DeviceStatus()
+	status.State = deviceStopped
+	status.SessionID, status.Screenshots = "", 0
+	app.sendHeartbeat(status)
+}
+
+func (app *App) sendHeartbeat(status DeviceStatus) {
+	if err := writeStatusFile(statusFilePath(app.config), status); err != nil {
+		fmt.Printf("Warning: Failed to write status file: %v\n", err)
+	}
+	// Heartbeats go straight out rather than through the upload queue; a
+	// late one is worthless
+	for _, sink := range app.sinks {
+		heartbeats, ok := sink.UploadSink.(statusSink)
+		if !ok || !sink.accepts(sinkStatus) {
+			continue
+		}
+		if err := heartbeats.SendHeartbeat(status); err != nil {
+			fmt.Printf("Warning: Failed to send heartbeat to %s: %v\n", sink.name, err)
+		}
+	}
+}
+
+// writeStatusFile replaces the status file, writing then renaming so
+// -status never reads half a file
+func writeStatusFile(path string, status DeviceStatus) error {
+	data, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readStatusFile loads the status written by a running process
+func readStatusFile(path string) (*DeviceStatus, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var status DeviceStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &status, nil
+}
+
+// Stale reports whether the process stopped sending heartbeats without
+// saying it was stopping
+func (s *DeviceStatus) Stale(now time.Time) bool {
+	if s.State == deviceStopped || s.IntervalSeconds <= 0 {
+		return false
+	}
+	return now.Sub(s.UpdatedAt) > 3*time.Duration(s.IntervalSeconds)*time.Second
+}
+
+func (s *DeviceStatus) String() string {
+	var b bytes.Buffer
+	state := s.State
+	if s.Stale(time.Now()) {
+		state += " (no heartbeat since " + s.UpdatedAt.Format("2006-01-02 15:04:05") + "; the process may have died)"
+	}
+	fmt.Fprintf(&b, "Device:       %s (%s, pid %d)\n", s.DeviceID, s.Hostname, s.PID)
+	fmt.Fprintf(&b, "State:        %s\n", state)
+	fmt.Fprintf(&b, "Updated:      %s\n", s.UpdatedAt.Format("2006-01-02 15:04:05"))
+	if s.SessionID != "" {
+		fmt.Fprintf(&b, "Session:      %s, %d screenshot(s)\n", s.SessionID, s.Screenshots)
+	}
+	if s.LastCaptureAt != nil {
+		fmt.Fprintf(&b, "Last capture: %s\n", s.LastCaptureAt.Format("2006-01-02 15:04:05"))
+	}
+	if s.LastErrorAt != nil {
+		fmt.Fprintf(&b, "Last error:   %s at %s\n", s.LastError, s.LastErrorAt.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprintf(&b, "Uploads:      %d waiting, %d failed\n", s.UploadsPending, s.UploadsFailed)
+	if s.DiskError != "" {
+		fmt.Fprintf(&b, "Disk free:    unknown (%s)\n", s.DiskError)
+	} else {
+		fmt.Fprintf(&b, "Disk free:    %.1f GB\n", float64(s.DiskFreeBytes)/(1<<30))
+	}
+	fmt.Fprintf(&b, "FFmpeg:       %v", s.FFmpeg)
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCaptureHealthFollowsPipeline checks heartbeats see errors from the
+// stages after the grab, and only count a capture once it is recorded
+func TestCaptureHealthFollowsPipeline(t *testing.T) {
+	app := newTestApp(t, map[string]interface{}{
+		"capture_settings": map[string]interface{}{
+			"backend":            "replay",
+			"replay_dir":         writeReplayFrames(t, 2, 320, 200),
+			"record_window_info": false,
+		},
+	})
+	if err := app.StartSessionInBackground(3600, "Ada", "Health test"); err != nil {
+		t.Fatalf("StartSessionInBackground: %v", err)
+	}
+	defer app.StopSession()
+	session, err := app.sessionManager.FindActiveSession()
+	if err != nil || session == nil {
+		t.Fatalf("FindActiveSession = %v, %v", session, err)
+	}
+	waitForScreenshots(t, app, session.ID, 1)
+
+	status := app.DeviceStatus()
+	if status.LastCaptureAt == nil || status.LastError != "" {
+		t.Fatalf("after a recorded capture: last capture %v, error %q", status.LastCaptureAt, status.LastError)
+	}
+	lastCapture := *status.LastCaptureAt
+
+	// The next frame is grabbed but can't be saved
+	screenshotDir := app.sessionManager.GetSessionScreenshotDir(session.ID)
+	if err := os.RemoveAll(screenshotDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(screenshotDir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	app.CaptureNow()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for status = app.DeviceStatus(); status.LastError == "" && time.Now().Before(deadline); status = app.DeviceStatus() {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if !strings.Contains(status.LastError, "save") {
+		t.Errorf("last error = %q, want the failed save", status.LastError)
+	}
+	if status.LastCaptureAt == nil || !status.LastCaptureAt.Equal(lastCapture) {
+		t.Errorf("last capture moved to %v after a frame that was never saved", status.LastCaptureAt)
+	}
+}
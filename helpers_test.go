@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDeviceUUID = "00000000-0000-4000-8000-000000000001"
+
+// newTestSessionManager opens a fresh sessions.db in a temporary data
+// directory
+func newTestSessionManager(t *testing.T) *SessionManager {
+	t.Helper()
+	return openTestSessionManager(t, t.TempDir())
+}
+
+// openTestSessionManager opens sessions.db in dir, closing it when the
+// test ends
+func openTestSessionManager(t *testing.T, dir string) *SessionManager {
+	t.Helper()
+	sm, err := NewSessionManager(dir, testDeviceUUID)
+	if err != nil {
+		t.Fatalf("NewSessionManager: %v", err)
+	}
+	t.Cleanup(func() { sm.Close() })
+	return sm
+}
+
+// writeTestConfig writes a config file into a temporary directory and
+// returns its path. It captures small synthetic frames into its own data
+// directory unless settings, which are top-level config keys, say
+// otherwise.
+func writeTestConfig(t *testing.T, settings map[string]interface{}) string {
+	t.Helper()
+	dir := t.TempDir()
+	config := map[string]interface{}{
+		"data_dir": filepath.Join(dir, "sessions"),
+		"capture_settings": map[string]interface{}{
+			"backend":            "synthetic",
+			"synthetic_width":    320,
+			"synthetic_height":   200,
+			"record_window_info": false,
+		},
+	}
+	for key, value := range settings {
+		config[key] = value
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+// newTestApp builds an App from a config file written by writeTestConfig
+func newTestApp(t *testing.T, settings map[string]interface{}) *App {
+	t.Helper()
+	app, err := NewApp(writeTestConfig(t, settings))
+	if err != nil {
+		t.Fatalf("NewApp: %v", err)
+	}
+	t.Cleanup(func() { app.Close() })
+	return app
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/eiannone/keyboard"
+)
+
+// hotkeyResult says why listenForHotkeys returned
+type hotkeyResult int
+
+const (
+	hotkeyInterrupted hotkeyResult = iota // Ctrl+C, a signal, or the session ended
+	hotkeySummarize                       // The stop-and-summarize key was pressed
+)
+
+const hotkeyHelp = "Hotkeys: [p] pause/resume  [c] capture now  [b] bookmark  [s] stop and summarize"
+
+// listenForHotkeys handles single-key commands for the running session
+// until the user stops it, a signal arrives or done is closed. If the
+// terminal cannot be read key by key (no console, or running as a
+// service) it falls back to waiting for Ctrl+C.
+func listenForHotkeys(app *App, signals <-chan os.Signal, done <-chan struct{}) hotkeyResult {
+	keys, err := keyboard.GetKeys(10)
+	if err != nil {
+		fmt.Printf("Hotkeys unavailable (%v); press Ctrl+C to stop\n", err)
+		select {
+		case <-signals:
+		case <-done:
+		}
+		return hotkeyInterrupted
+	}
+	defer keyboard.Close()
+
+	fmt.Println(hotkeyHelp)
+	for {
+		select {
+		case <-signals:
+			return hotkeyInterrupted
+		case <-done:
+			return hotkeyInterrupted
+		case event, ok := <-keys:
+			if !ok {
+				return hotkeyInterrupted
+			}
+			if event.Err != nil {
+				continue
+			}
+
+			switch {
+			case event.Key == keyboard.KeyCtrlC:
+				return hotkeyInterrupted
+			case event.Rune == 's' || event.Rune == 'S' || event.Rune == 'q' || event.Rune == 'Q':
+				return hotkeySummarize
+			case event.Rune == 'p' || event.Rune == 'P':
+				if err := app.TogglePause("break"); err != nil {
+					fmt.Printf("Error: %v\n", err)
+				}
+				// Let the capture loop pick up the new state straight away
+				app.CaptureNow()
+			case event.Rune == 'c' || event.Rune == 'C':
+				fmt.Println("Capturing now")
+				app.CaptureNow()
+			case event.Rune == 'b' || event.Rune == 'B':
+				note, ok := readHotkeyLine(keys, "Bookmark note (Enter to save, Esc to cancel): ")
+				if !ok || note == "" {
+					fmt.Println("Bookmark cancelled")
+					continue
+				}
+				if err := app.AddBookmark(note); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					continue
+				}
+				fmt.Println("Bookmark saved")
+			case event.Rune == 'h' || event.Rune == '?':
+				fmt.Println(hotkeyHelp)
+			}
+		}
+	}
+}
+
+// readHotkeyLine reads a line of text from raw key events, echoing it as
+// it is typed. It returns false if Esc or Ctrl+C cancels the input.
+func readHotkeyLine(keys <-chan keyboard.KeyEvent, prompt string) (string, bool) {
+	fmt.Print(prompt)
+
+	var line []rune
+	for event := range keys {
+		if event.Err != nil {
+			continue
+		}
+		switch event.Key {
+		case keyboard.KeyEnter:
+			fmt.Println()
+			return strings.TrimSpace(string(line)), true
+		case keyboard.KeyEsc, keyboard.KeyCtrlC:
+			fmt.Println()
+			return "", false
+		case keyboard.KeyBackspace, keyboard.KeyBackspace2:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Print("\b \b")
+			}
+		case keyboard.KeySpace:
+			line = append(line, ' ')
+			fmt.Print(" ")
+		default:
+			if event.Rune != 0 {
+				line = append(line, event.Rune)
+				fmt.Print(string(event.Rune))
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// weekdayCodes maps iCalendar BYDAY codes to ClassBlock day names
+var weekdayCodes = map[string]string{
+	"MO": "monday", "TU": "tuesday", "WE": "wednesday", "TH": "thursday",
+	"FR": "friday", "SA": "saturday", "SU": "sunday",
+}
+
+// LoadICSBlocks reads VEVENTs from an iCalendar file as class blocks.
+// Weekly RRULEs (with optional BYDAY and UNTIL) become weekly blocks, events
+// without a rule become one-off blocks, and other recurrences are skipped.
+// SUMMARY is used as the description and X-STUDENT-NAME, if present, as
+// the student.
+func LoadICSBlocks(path string) ([]ClassBlock, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open timetable: %w", err)
+	}
+	defer file.Close()
+
+	// Unfold continuation lines (RFC 5545 3.1)
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read timetable: %w", err)
+	}
+
+	var blocks []ClassBlock
+	var event map[string]icsProperty
+	for _, line := range lines {
+		switch {
+		case line == "BEGIN:VEVENT":
+			event = make(map[string]icsProperty)
+		case line == "END:VEVENT":
+			if event != nil {
+				eventBlocks, err := icsEventBlocks(event)
+				if err != nil {
+					fmt.Printf("Skipping timetable event %q: %v\n", event["SUMMARY"].value, err)
+				}
+				blocks = append(blocks, eventBlocks...)
+			}
+			event = nil
+		case event != nil:
+			if prop, ok := parseICSLine(line); ok {
+				event[prop.name] = prop
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+type icsProperty struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+func parseICSLine(line string) (icsProperty, bool) {
+	colon := strings.Index(line, ":")
+	if colon < 0 {
+		return icsProperty{}, false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	prop := icsProperty{
+		name:   strings.ToUpper(parts[0]),
+		params: make(map[string]string),
+		value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if eq := strings.Index(param, "="); eq > 0 {
+			prop.params[strings.ToUpper(param[:eq])] = strings.Trim(param[eq+1:], `"`)
+		}
+	}
+	return prop, true
+}
+
+// parseICSTime parses DATE-TIME values in UTC ("Z"), with a TZID, or as
+// floating local time, and returns them in local time
+func parseICSTime(prop icsProperty) (time.Time, error) {
+	value := prop.value
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t.Local(), err
+	}
+
+	location := time.Local
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			location = loc
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, location)
+	return t.Local(), err
+}
+
+func icsEventBlocks(event map[string]icsProperty) ([]ClassBlock, error) {
+	startProp, ok := event["DTSTART"]
+	if !ok {
+		return nil, fmt.Errorf("missing DTSTART")
+	}
+	endProp, ok := event["DTEND"]
+	if !ok {
+		return nil, fmt.Errorf("missing DTEND")
+	}
+	start, err := parseICSTime(startProp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTSTART: %w", err)
+	}
+	end, err := parseICSTime(endProp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DTEND: %w", err)
+	}
+
+	block := ClassBlock{
+		Start:       start.Format("15:04"),
+		End:         end.Format("15:04"),
+		StudentName: unescapeICS(event["X-STUDENT-NAME"].value),
+		Description: unescapeICS(event["SUMMARY"].value),
+	}
+
+	rrule, recurring := event["RRULE"]
+	if !recurring {
+		block.Date = start.Format("2006-01-02")
+		return []ClassBlock{block}, nil
+	}
+
+	rule := make(map[string]string)
+	for _, part := range strings.Split(rrule.value, ";") {
+		if eq := strings.Index(part, "="); eq > 0 {
+			rule[strings.ToUpper(part[:eq])] = part[eq+1:]
+		}
+	}
+	if rule["FREQ"] != "WEEKLY" {
+		return nil, fmt.Errorf("unsupported recurrence %s", rrule.value)
+	}
+	if until := rule["UNTIL"]; until != "" {
+		if len(until) >= 8 {
+			if t, err := time.Parse("20060102", until[:8]); err == nil {
+				block.Until = t.Format("2006-01-02")
+			}
+		}
+	}
+
+	days := []string{strings.ToLower(start.Weekday().String())}
+	if byDay := rule["BYDAY"]; byDay != "" {
+		days = nil
+		for _, code := range strings.Split(byDay, ",") {
+			if day, ok := weekdayCodes[strings.ToUpper(code)]; ok {
+				days = append(days, day)
+			}
+		}
+	}
+
+	var blocks []ClassBlock
+	for _, day := range days {
+		weekly := block
+		weekly.Day = day
+		blocks = append(blocks, weekly)
+	}
+	return blocks, nil
+}
+
+func unescapeICS(value string) string {
+	replacer := strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"bufio"
-	"database/sql"
 	"flag"
 	"fmt"
 	"io"
@@ -13,14 +12,28 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 func main() {
 	var (
 		startSession = flag.Bool("start", false, "Start a new screenshot session")
 		stopSession  = flag.Bool("stop", false, "Stop current session and generate summary")
+		pauseSession = flag.Bool("pause", false, "Pause capture on the current session")
+		resume       = flag.Bool("resume", false, "Resume capture on a paused session")
+		recoverCrash = flag.Bool("recover", false, "With -start, continue a session a crashed process left active instead of closing it")
+		pauseReason  = flag.String("reason", "break", "Reason recorded with -pause")
 		usbAuto      = flag.Bool("usb-auto", false, "USB auto mode - start/stop based on USB insertion/removal")
 		analyze      = flag.Bool("analyze", false, "Analyze existing sessions and generate reports")
+		schedule     = flag.Bool("schedule", false, "Run sessions automatically from the class timetable in config")
+		status       = flag.Bool("status", false, "Show the latest heartbeat of the capture process on this machine")
+		syncSessions = flag.Bool("sync", false, "Upload screenshots, summaries and timelapses of completed sessions the webapp is missing")
+		migrate      = flag.Bool("migrate", false, "Bring sessions.db up to the current schema")
+		prune        = flag.Bool("prune", false, "Delete old screenshots and sessions under the retention settings in config")
+		dryRun       = flag.Bool("dry-run", false, "With -sync, -migrate or -prune, list what would be done without changing anything")
+		sessionID    = flag.Int("session", 0, "With -sync, only this session ID")
+		since        = flag.String("since", "", "With -sync, only sessions started on or after this date (YYYY-MM-DD)")
+		until        = flag.String("until", "", "With -sync, only sessions started on or before this date (YYYY-MM-DD)")
 		silent       = flag.Bool("silent", false, "Run in silent background mode")
 		interval     = flag.Int("interval", 30, "Screenshot interval in seconds")
 		configPath   = flag.String("config", "config.json", "Path to configuration file")
@@ -31,12 +44,30 @@ func main() {
 	if *analyze {
 		// Analysis mode
 		runAnalysisMode(*configPath)
+	} else if *migrate {
+		// Database schema upgrade
+		runMigrateMode(*configPath, *dryRun)
+	} else if *prune {
+		// Retention rules, on demand
+		runPruneMode(*configPath, *dryRun)
+	} else if *status {
+		// Health of a capture process running in the background
+		runStatusMode(*configPath)
+	} else if *syncSessions {
+		// Bulk upload of earlier sessions
+		runSyncMode(*configPath, *sessionID, *since, *until, *dryRun)
+	} else if *schedule {
+		// Timetable-driven sessions
+		runScheduleMode(*configPath, *silent)
 	} else if *usbAuto {
 		// USB auto mode
 		RunUSBMode(*configPath, *silent)
+	} else if *pauseSession || *resume {
+		// Pause or resume a session running in another process
+		runPauseMode(*pauseSession, *pauseReason, *configPath, *silent)
 	} else if *startSession || *stopSession {
 		// Command-line mode (for advanced users)
-		runCommandLineMode(*startSession, *stopSession, *interval, *configPath, *silent)
+		runCommandLineMode(*startSession, *stopSession, *recoverCrash, *interval, *configPath, *silent)
 	} else {
 		// Check if we're running from a USB drive - if so, auto-start USB mode silently
 		if isRunningFromUSB() {
@@ -48,7 +79,7 @@ func main() {
 	}
 }
 
-func runCommandLineMode(start, stop bool, interval int, configPath string, silent bool) {
+func runCommandLineMode(start, stop, recoverCrash bool, interval int, configPath string, silent bool) {
 	// In silent mode, suppress all output
 	if silent {
 		log.SetOutput(io.Discard)
@@ -75,25 +106,62 @@ func runCommandLineMode(start, stop bool, interval int, configPath string, silen
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-		go func() {
-			<-sigChan
+		app.PruneAtStartup()
+		interrupted, err := app.InterruptedSession()
+		if err != nil {
 			if !silent {
-				fmt.Println("\nStopping session...")
+				log.Fatal("Failed to check for an interrupted session:", err)
 			}
-			app.StopSession()
-		}()
-
-		if err := app.StartSession(interval, "Student"); err != nil {
+			return
+		}
+		if recoverCrash && interrupted != nil {
+			err = app.ResumeInterruptedSession(interval)
+		} else {
+			err = app.StartSessionInBackground(interval, "Student", defaultSessionDescription)
+		}
+		if err != nil {
 			if !silent {
 				log.Fatal("Failed to start session:", err)
 			}
 			return
 		}
+		app.StartUploads()
+		app.StartRemoteCommands()
+		app.StartHeartbeat()
+		defer app.Close()
+
+		// Silent runs have no console to read hotkeys from
+		if silent {
+			select {
+			case <-sigChan:
+				app.StopSession()
+			case <-app.RemoteStopped():
+			}
+			return
+		}
+
+		if listenForHotkeys(app, sigChan, app.RemoteStopped()) == hotkeySummarize {
+			fmt.Println("\nStopping session and generating summary...")
+			if err := app.StopSessionAndSummarize(); err != nil {
+				log.Fatal("Failed to stop session:", err)
+			}
+			return
+		}
+
+		select {
+		case <-app.RemoteStopped():
+			fmt.Println("\nSession stopped remotely")
+		default:
+			fmt.Println("\nStopping session...")
+			app.StopSession()
+		}
 
 	case stop:
 		if !silent {
 			fmt.Println("Stopping session and generating summary...")
 		}
+		app.StartUploads()
+		defer app.Close()
 		if err := app.StopSessionAndSummarize(); err != nil {
 			if !silent {
 				log.Fatal("Failed to stop session:", err)
@@ -103,6 +171,196 @@ func runCommandLineMode(start, stop bool, interval int, configPath string, silen
 	}
 }
 
+func runPauseMode(pause bool, reason, configPath string, silent bool) {
+	if silent {
+		log.SetOutput(io.Discard)
+	}
+
+	app, err := NewApp(configPath)
+	if err != nil {
+		if !silent {
+			log.Fatal("Failed to initialize application:", err)
+		}
+		return
+	}
+	defer app.Close()
+
+	if pause {
+		err = app.PauseSession(reason)
+	} else {
+		err = app.ResumeSession()
+	}
+	if err != nil && !silent {
+		log.Fatal(err)
+	}
+}
+
+// runMigrateMode lists the schema migrations sessions.db still needs and,
+// unless dryRun, applies them
+func runMigrateMode(configPath string, dryRun bool) {
+	// A dry run must not leave anything behind: no config file, data_dir or
+	// device UUID, and no write to sessions.db
+	load := LoadConfig
+	if dryRun {
+		load = readConfig
+	}
+	config, err := load(configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	dbPath := filepath.Join(config.DataDir, "sessions.db")
+
+	version, err := readSchemaVersion(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%s: schema version %d, this build knows %d\n", dbPath, version, latestSchemaVersion())
+	if len(pending) == 0 {
+		fmt.Println("Nothing to migrate")
+		return
+	}
+	for _, m := range pending {
+		fmt.Printf("  %3d  %s\n", m.version, m.description)
+	}
+	if dryRun {
+		fmt.Printf("Dry run: %d migration(s) pending\n", len(pending))
+		return
+	}
+
+	sessionManager, err := NewSessionManager(config.DataDir, config.DeviceUUID)
+	if err != nil {
+		log.Fatal("Migration failed:", err)
+	}
+	sessionManager.Close()
+	fmt.Printf("Applied %d migration(s)\n", len(pending))
+}
+
+// runPruneMode applies the retention rules once, or with dryRun lists
+// what they would remove
+func runPruneMode(configPath string, dryRun bool) {
+	app, err := NewApp(configPath)
+	if err != nil {
+		log.Fatal("Failed to initialize application:", err)
+	}
+
+	settings := app.config.Retention
+	if settings.ScreenshotDays <= 0 && settings.MaxDataDirMB <= 0 {
+		app.Close()
+		fmt.Println("No retention rules set; add screenshot_days or max_data_dir_mb under retention in config")
+		return
+	}
+	report, err := app.Prune(dryRun)
+	if report != nil {
+		fmt.Println(report)
+	}
+	app.Close()
+	if err != nil {
+		log.Fatal("Prune stopped: ", err)
+	}
+}
+
+// runStatusMode prints the status file a running process keeps up to date,
+// without opening the database
+func runStatusMode(configPath string) {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	path := statusFilePath(config)
+	status, err := readStatusFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("No status at %s; no capture process has run with heartbeats enabled\n", path)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal("Failed to read status:", err)
+	}
+	fmt.Println(status)
+	if status.Stale(time.Now()) {
+		os.Exit(1)
+	}
+}
+
+func runSyncMode(configPath string, sessionID int, since, until string, dryRun bool) {
+	filter, err := parseSyncFilter(sessionID, since, until)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	app, err := NewApp(configPath)
+	if err != nil {
+		log.Fatal("Failed to initialize application:", err)
+	}
+	if !dryRun {
+		// Queued uploads go out alongside the sync
+		app.StartUploads()
+	}
+
+	report, err := app.RunSync(filter, dryRun)
+	if report != nil && report.Sessions > 0 {
+		fmt.Printf("\n%s\n", report)
+	}
+	app.Close()
+	if err != nil {
+		log.Fatal("Sync stopped: ", err)
+	}
+}
+
+func runScheduleMode(configPath string, silent bool) {
+	if silent {
+		log.SetOutput(io.Discard)
+	}
+
+	app, err := NewApp(configPath)
+	if err != nil {
+		if !silent {
+			log.Fatal("Failed to initialize application:", err)
+		}
+		return
+	}
+	defer app.Close()
+
+	blocks := app.config.Schedule.Blocks
+	if app.config.Schedule.ICSFile != "" {
+		icsBlocks, err := LoadICSBlocks(app.config.Schedule.ICSFile)
+		if err != nil {
+			log.Fatal("Failed to load timetable:", err)
+		}
+		blocks = append(blocks, icsBlocks...)
+	}
+
+	scheduler, err := NewScheduler(blocks, app.config.Schedule, app, realClock{})
+	if err != nil {
+		log.Fatal("Invalid schedule:", err)
+	}
+	app.PruneAtStartup()
+	app.StartUploads()
+	app.StartRemoteCommands()
+	app.StartHeartbeat()
+
+	if !silent {
+		fmt.Printf("Following timetable with %d class block(s). Press Ctrl+C to stop.\n", len(blocks))
+	}
+
+	// Stop the scheduler (and any running session) on Ctrl+C
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		close(stop)
+	}()
+
+	if err := scheduler.Run(stop); err != nil {
+		log.Fatal("Scheduler stopped:", err)
+	}
+}
+
 func runInteractiveMode(configPath string) {
 	// Clear screen and show welcome message
 	fmt.Println("\n" + strings.Repeat("=", 60))
@@ -119,17 +377,50 @@ func runInteractiveMode(configPath string) {
 	}
 	defer app.Close()
 
+	app.PruneAtStartup()
+
+	// Send anything left in the upload queue from earlier runs
+	app.StartUploads()
+	app.StartRemoteCommands()
+	app.StartHeartbeat()
+
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
 		// Check for active session
-		activeSession, _ := app.sessionManager.GetActiveSession()
+		activeSession, _ := app.sessionManager.FindActiveSession()
+
+		if pending, dead, err := app.UploadQueueDepth(); err == nil && pending+dead > 0 {
+			fmt.Printf("\n📤 Uploads waiting: %d", pending)
+			if dead > 0 {
+				fmt.Printf(" (%d failed permanently)", dead)
+			}
+			fmt.Println()
+		}
 
 		fmt.Println("\n🎯 What would you like to do?")
-		if activeSession != nil {
-			fmt.Printf("   ⏳ Active session running (ID: %d)\n", activeSession.ID)
+		paused := false
+		interrupted := activeSession != nil && !app.running() && activeSession.Interrupted(time.Now())
+		if interrupted {
+			fmt.Printf("   ⚠️  Session %d was interrupted (nothing captured since %s)\n",
+				activeSession.ID, activeSession.lastActivity().Format("2006-01-02 15:04:05"))
+			fmt.Println("   1️⃣  Resume capture into it")
+			fmt.Println("   2️⃣  Close it and generate summary")
+			fmt.Println("   3️⃣  Exit")
+		} else if activeSession != nil {
+			paused, _ = app.sessionManager.IsPaused(activeSession.ID)
+			if paused {
+				fmt.Printf("   ⏸️  Active session paused (ID: %d)\n", activeSession.ID)
+			} else {
+				fmt.Printf("   ⏳ Active session running (ID: %d)\n", activeSession.ID)
+			}
 			fmt.Println("   1️⃣  Stop current session and generate summary")
-			fmt.Println("   2️⃣  Exit (keep session running)")
+			if paused {
+				fmt.Println("   2️⃣  Resume capture")
+			} else {
+				fmt.Println("   2️⃣  Pause capture (e.g. for a break)")
+			}
+			fmt.Println("   3️⃣  Exit (keep session running)")
 		} else {
 			fmt.Println("   1️⃣  USB Auto Mode (Recommended)")
 			fmt.Println("   2️⃣  Start new screenshot session manually")
@@ -138,23 +429,41 @@ func runInteractiveMode(configPath string) {
 		}
 
 		fmt.Print("\n💭 Enter your choice (1-" + func() string {
-			if activeSession != nil { return "2" } else { return "4" }
+			if activeSession != nil {
+				return "3"
+			} else {
+				return "4"
+			}
 		}() + "): ")
 
 		choice, _ := reader.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 
-		if activeSession != nil {
+		if interrupted {
+			switch choice {
+			case "1":
+				handleResumeInterrupted(app)
+			case "2":
+				handleStopSession(app)
+			case "3":
+				fmt.Println("\n👋 Goodbye!")
+				return
+			default:
+				fmt.Println("❌ Invalid choice. Please enter 1, 2, or 3.")
+			}
+		} else if activeSession != nil {
 			switch choice {
 			case "1":
 				handleStopSession(app)
 			case "2":
+				handlePauseResume(app, reader, paused)
+			case "3":
 				fmt.Println("\n👋 Session will continue running in the background.")
 				fmt.Println("   Double-click this program again to stop it.")
 				pauseForUser()
 				return
 			default:
-				fmt.Println("❌ Invalid choice. Please enter 1 or 2.")
+				fmt.Println("❌ Invalid choice. Please enter 1, 2, or 3.")
 			}
 		} else {
 			switch choice {
@@ -195,20 +504,56 @@ func handleStartSession(app *App, reader *bufio.Reader) {
 
 	fmt.Printf("\n✅ Starting session with %d second intervals\n", interval)
 	fmt.Println("📸 Screenshots will be saved automatically")
-	fmt.Println("🛑 Close this window or press Ctrl+C to stop")
+	fmt.Println("🛑 Press s (or Ctrl+C) to stop and generate the summary")
 	fmt.Println(strings.Repeat("-", 50))
 
+	// Handle graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
 	// Start session in the background
-	go func() {
-		if err := app.StartSession(interval, studentName); err != nil {
-			fmt.Printf("❌ Error starting session: %v\n", err)
-		}
-	}()
+	if err := app.StartSessionInBackground(interval, studentName, defaultSessionDescription); err != nil {
+		fmt.Printf("❌ Error starting session: %v\n", err)
+		pauseForUser()
+		return
+	}
+	followSession(app, sigChan)
+}
+
+// handleResumeInterrupted continues capture into a session a crashed
+// process left active
+func handleResumeInterrupted(app *App) {
+	fmt.Println("\n🔁 Resuming Interrupted Session")
+	fmt.Println("🛑 Press s (or Ctrl+C) to stop and generate the summary")
+	fmt.Println(strings.Repeat("-", 50))
 
-	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	defer signal.Stop(sigChan)
+
+	// The session's own interval is used when it recorded one
+	if err := app.ResumeInterruptedSession(30); err != nil {
+		fmt.Printf("❌ Error resuming session: %v\n", err)
+		pauseForUser()
+		return
+	}
+	followSession(app, sigChan)
+}
+
+// followSession handles hotkeys for a session running in the background
+// until it is stopped, then summarizes it
+func followSession(app *App, sigChan <-chan os.Signal) {
+	// Pause, capture now and bookmark keys work until the session is stopped
+	listenForHotkeys(app, sigChan, app.RemoteStopped())
+
+	select {
+	case <-app.RemoteStopped():
+		fmt.Println("\n🛑 Session stopped remotely; summary generated")
+		pauseForUser()
+		return
+	default:
+	}
 
 	fmt.Println("\n🛑 Stopping session...")
 	if err := app.StopSessionAndSummarize(); err != nil {
@@ -233,8 +578,32 @@ func handleStopSession(app *App) {
 	pauseForUser()
 }
 
+func handlePauseResume(app *App, reader *bufio.Reader, paused bool) {
+	if paused {
+		if err := app.ResumeSession(); err != nil {
+			fmt.Printf("❌ Error: %v\n", err)
+			return
+		}
+		fmt.Println("▶️  Capture resumed")
+		return
+	}
+
+	fmt.Print("📝 Reason for the pause (default \"break\"): ")
+	reason, _ := reader.ReadString('\n')
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		reason = "break"
+	}
+
+	if err := app.PauseSession(reason); err != nil {
+		fmt.Printf("❌ Error: %v\n", err)
+		return
+	}
+	fmt.Println("⏸️  Capture paused - no screenshots until you resume")
+}
+
 func handleUSBAutoMode(app *App) {
-	app.Close() // Close the app properly before switching to USB mode
+	app.Close()                      // Close the app properly before switching to USB mode
 	RunUSBMode("config.json", false) // Interactive USB mode
 }
 
@@ -295,6 +664,18 @@ func runAnalysisMode(configPath string) {
 		return
 	}
 	defer app.Close()
+	app.StartUploads()
+
+	// Pick up names and summaries assigned in the webapp first, so reports
+	// and timelapse filenames use the right student name
+	if app.webapp != nil {
+		fmt.Println("\n🔄 Syncing with webapp...")
+		if report, err := app.SyncWithWebapp(); err != nil {
+			fmt.Printf("⚠️  Sync failed, using local names: %v\n", err)
+		} else {
+			fmt.Println(report)
+		}
+	}
 
 	// Find unanalyzed sessions
 	unanalyzedSessions, err := findUnanalyzedSessions(app)
@@ -331,11 +712,16 @@ func runAnalysisMode(configPath string) {
 
 		fmt.Printf("   📸 Processing %d screenshots...\n", len(screenshots))
 
-		// Generate analysis
-		summary, err := app.analyzer.GenerateSessionSummary(screenshots, app.config.AnalysisPrompt, app.config, session.StudentName)
-		if err != nil {
-			fmt.Printf("   ❌ Analysis failed: %v\n", err)
-			continue
+		// Generate analysis, unless the webapp already has a summary
+		summary := session.Summary
+		if summary != "" && app.webapp != nil {
+			fmt.Printf("   📝 Using summary from webapp\n")
+		} else {
+			summary, err = app.analyzer.GenerateSessionSummary(screenshots, app.config.AnalysisPrompt, app.config, session.StudentName)
+			if err != nil {
+				fmt.Printf("   ❌ Analysis failed: %v\n", err)
+				continue
+			}
 		}
 
 		// Save analysis
@@ -347,8 +733,20 @@ func runAnalysisMode(configPath string) {
 			continue
 		}
 
-		// Generate timelapse if enough screenshots
-		if len(screenshots) >= 3 {
+		// Sessions cleaned up after a crash were never reported as finished
+		app.reportStopped(&session)
+		if summary != session.Summary {
+			app.publishSummary(&session, summary)
+		}
+
+		// Contact sheet of thumbnails for quick browsing
+		contactSheetPath := filepath.Join(sessionDir, "contact_sheet.jpg")
+		if err := GenerateContactSheet(screenshots, contactSheetPath, app.config.ThumbnailSettings); err != nil {
+			fmt.Printf("   ⚠️  Contact sheet failed: %v\n", err)
+		}
+
+		// Generate timelapse if enough capture ticks
+		if len(groupScreenshotsByTick(screenshots)) >= 3 {
 			fmt.Printf("   🎬 Creating timelapse...\n")
 			if err := app.generateTimelapse(screenshots, sessionDir, &session); err != nil {
 				fmt.Printf("   ⚠️  Timelapse failed: %v\n", err)
@@ -366,7 +764,7 @@ func runAnalysisMode(configPath string) {
 func findUnanalyzedSessions(app *App) ([]Session, error) {
 	// Get all completed sessions
 	rows, err := app.sessionManager.db.Query(
-		"SELECT id, start_time, end_time, description, student_name, status FROM sessions WHERE status = 'completed' ORDER BY start_time DESC",
+		"SELECT " + sessionColumns + " FROM sessions WHERE status = 'completed' AND purged_at IS NULL ORDER BY start_time DESC",
 	)
 	if err != nil {
 		return nil, err
@@ -375,28 +773,18 @@ func findUnanalyzedSessions(app *App) ([]Session, error) {
 
 	var unanalyzed []Session
 	for rows.Next() {
-		var session Session
-		var endTime sql.NullTime
-		var studentName sql.NullString
-
-		if err := rows.Scan(&session.ID, &session.StartTime, &endTime, &session.Description, &studentName, &session.Status); err != nil {
+		session, err := scanSession(rows)
+		if err != nil {
 			continue
 		}
 
-		if endTime.Valid {
-			session.EndTime = endTime.Time
-		}
-		if studentName.Valid {
-			session.StudentName = studentName.String
-		}
-
 		// Check if analysis already exists
 		sessionDir := app.sessionManager.GetSessionDir(session.ID)
 		summaryPath := filepath.Join(sessionDir, "summary.txt")
 
 		if _, err := os.Stat(summaryPath); os.IsNotExist(err) {
 			// No analysis exists - add to unanalyzed list
-			unanalyzed = append(unanalyzed, session)
+			unanalyzed = append(unanalyzed, *session)
 		}
 	}
 
@@ -406,4 +794,4 @@ func findUnanalyzedSessions(app *App) ([]Session, error) {
 func pauseForUser() {
 	fmt.Print("\n⏎  Press Enter to continue...")
 	bufio.NewReader(os.Stdin).ReadString('\n')
-}
\ No newline at end of file
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// migration is one step of the sessions.db schema. Steps run in version
+// order, each in its own transaction together with its schema_version row,
+// so a failed step leaves the database at the previous version.
+type migration struct {
+	version     int
+	description string
+	apply       func(tx *sql.Tx, sm *SessionManager) error
+}
+
+// migrations is the schema history. Append new steps; never edit or
+// reorder released ones.
+var migrations = []migration{
+	{1, "Create sessions, screenshots, pause, upload queue, sync and event tables", createTables},
+	{2, "Add columns that databases created before versioned migrations may lack", addUnversionedColumns},
+	{3, "Index screenshots, pauses, events and the upload queue by session", addSessionIndexes},
+	{4, "Give sessions device-qualified global IDs", migrateGlobalIDs},
+	{5, "Record which sessions and screenshots retention has purged", addPurgeColumns},
+	{6, "Track when each session last captured, to tell crashed sessions from running ones", addLastActivity},
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// schemaVersion returns the version recorded in db, 0 for a database from
+// before versioned migrations (or a new one). It doesn't create anything.
+func schemaVersion(db *sql.DB) (int, error) {
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'").Scan(&tables); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	var version sql.NullInt64
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// readSchemaVersion returns the schema version of the sessions.db at
+// dbPath, opened read-only. Opening a missing file would create it, so a
+// new install counts as version 0 without touching the disk.
+func readSchemaVersion(dbPath string) (int, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to read database: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(dbPath)+"?mode=ro")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+	return schemaVersion(db)
+}
+
+// pendingMigrations returns the steps a database at version still needs,
+// refusing a schema newer than this build knows
+func pendingMigrations(version int) ([]migration, error) {
+	if version > latestSchemaVersion() {
+		return nil, fmt.Errorf("sessions.db has schema version %d but this build only knows up to %d; use a newer infogenerator", version, latestSchemaVersion())
+	}
+	var pending []migration
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// migrate applies every pending migration
+func (sm *SessionManager) migrate() error {
+	version, err := schemaVersion(sm.db)
+	if err != nil {
+		return err
+	}
+	pending, err := pendingMigrations(version)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if _, err := sm.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at DATETIME NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	for _, m := range pending {
+		if err := sm.applyMigration(m); err != nil {
+			return fmt.Errorf("schema migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+func (sm *SessionManager) applyMigration(m migration) error {
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.apply(tx, sm); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)",
+		m.version, m.description, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// execAll runs statements in order, stopping at the first error
+func execAll(tx *sql.Tx, statements ...string) error {
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column unless the table already has it
+func addColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// createTables creates the schema as it stood when versioning began.
+// Existing tables are left for migration 2 to complete.
+func createTables(tx *sql.Tx, sm *SessionManager) error {
+	return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			start_time DATETIME NOT NULL,
+			end_time DATETIME,
+			description TEXT,
+			student_name TEXT,
+			status TEXT NOT NULL DEFAULT 'active',
+			capture_policy TEXT,
+			capture_params TEXT,
+			summary TEXT,
+			synced_name TEXT,
+			synced_summary TEXT,
+			synced_at DATETIME,
+			global_id TEXT,
+			legacy_global_id TEXT
+		)`, `
+		CREATE TABLE IF NOT EXISTS screenshots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			timestamp DATETIME NOT NULL,
+			file_path TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			display_index INTEGER NOT NULL DEFAULT 0,
+			tick INTEGER NOT NULL DEFAULT 0,
+			jpeg_quality INTEGER NOT NULL DEFAULT 0,
+			width INTEGER NOT NULL DEFAULT 0,
+			height INTEGER NOT NULL DEFAULT 0,
+			phash TEXT,
+			duplicate_of INTEGER,
+			window_title TEXT,
+			window_class TEXT,
+			window_pid INTEGER,
+			redactions TEXT,
+			thumbnail_path TEXT,
+			FOREIGN KEY (session_id) REFERENCES sessions (id)
+		)`, `
+		CREATE TABLE IF NOT EXISTS session_pauses (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			paused_at DATETIME NOT NULL,
+			resumed_at DATETIME,
+			reason TEXT,
+			FOREIGN KEY (session_id) REFERENCES sessions (id)
+		)`, `
+		CREATE TABLE IF NOT EXISTS upload_queue (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			global_session_id TEXT NOT NULL,
+			sink TEXT NOT NULL DEFAULT 'webapp',
+			kind TEXT NOT NULL DEFAULT 'screenshot',
+			file_path TEXT NOT NULL,
+			payload TEXT,
+			captured_at DATETIME NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at DATETIME NOT NULL,
+			last_error TEXT,
+			created_at DATETIME NOT NULL,
+			uploaded_at DATETIME,
+			FOREIGN KEY (session_id) REFERENCES sessions (id)
+		)`, `
+		CREATE TABLE IF NOT EXISTS sync_files (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			kind TEXT NOT NULL,
+			file_path TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			synced_at DATETIME,
+			UNIQUE (session_id, kind, file_path),
+			FOREIGN KEY (session_id) REFERENCES sessions (id)
+		)`, `
+		CREATE TABLE IF NOT EXISTS session_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			timestamp DATETIME NOT NULL,
+			kind TEXT NOT NULL,
+			note TEXT,
+			FOREIGN KEY (session_id) REFERENCES sessions (id)
+		)`)
+}
+
+// addUnversionedColumns catches up tables created by builds that added
+// columns with unchecked ALTER TABLE statements
+func addUnversionedColumns(tx *sql.Tx, sm *SessionManager) error {
+	columns := []struct{ table, column, definition string }{
+		{"sessions", "student_name", "TEXT"},
+		{"sessions", "capture_policy", "TEXT"},
+		{"sessions", "capture_params", "TEXT"},
+		{"sessions", "summary", "TEXT"},
+		{"sessions", "synced_name", "TEXT"},
+		{"sessions", "synced_summary", "TEXT"},
+		{"sessions", "synced_at", "DATETIME"},
+		{"sessions", "global_id", "TEXT"},
+		{"sessions", "legacy_global_id", "TEXT"},
+		{"screenshots", "display_index", "INTEGER NOT NULL DEFAULT 0"},
+		{"screenshots", "tick", "INTEGER NOT NULL DEFAULT 0"},
+		{"screenshots", "jpeg_quality", "INTEGER NOT NULL DEFAULT 0"},
+		{"screenshots", "width", "INTEGER NOT NULL DEFAULT 0"},
+		{"screenshots", "height", "INTEGER NOT NULL DEFAULT 0"},
+		{"screenshots", "phash", "TEXT"},
+		{"screenshots", "duplicate_of", "INTEGER"},
+		{"screenshots", "window_title", "TEXT"},
+		{"screenshots", "window_class", "TEXT"},
+		{"screenshots", "window_pid", "INTEGER"},
+		{"screenshots", "redactions", "TEXT"},
+		{"screenshots", "thumbnail_path", "TEXT"},
+		{"upload_queue", "kind", "TEXT NOT NULL DEFAULT 'screenshot'"},
+		{"upload_queue", "payload", "TEXT"},
+		// Rows queued before sinks existed were for the webapp
+		{"upload_queue", "sink", "TEXT NOT NULL DEFAULT 'webapp'"},
+	}
+	for _, c := range columns {
+		if err := addColumn(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addSessionIndexes(tx *sql.Tx, sm *SessionManager) error {
+	return execAll(tx,
+		`CREATE INDEX IF NOT EXISTS idx_sessions_status ON sessions (status, start_time)`,
+		`CREATE INDEX IF NOT EXISTS idx_screenshots_session ON screenshots (session_id, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_session_pauses_session ON session_pauses (session_id, paused_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_session_events_session ON session_events (session_id, timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_queue_due ON upload_queue (status, next_attempt_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_queue_session ON upload_queue (session_id, sink)`,
+	)
+}
+
+// migrateGlobalIDs gives sessions recorded before device UUIDs their
+// device-qualified ID. Uploads still queued follow the new ID; sent ones
+// keep the old ID, and with the sessions' -sync records cleared, -sync
+// republishes them under the new one.
+func migrateGlobalIDs(tx *sql.Tx, sm *SessionManager) error {
+	rows, err := tx.Query("SELECT id, start_time FROM sessions WHERE global_id IS NULL OR global_id = ''")
+	if err != nil {
+		return err
+	}
+	type legacySession struct {
+		id    int
+		start time.Time
+	}
+	var legacy []legacySession
+	for rows.Next() {
+		var s legacySession
+		if err := rows.Scan(&s.id, &s.start); err != nil {
+			rows.Close()
+			return err
+		}
+		legacy = append(legacy, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	for _, s := range legacy {
+		oldID, newID := legacyGlobalID(s.id, s.start), newGlobalID(sm.deviceUUID, s.id, s.start)
+		if _, err := tx.Exec("UPDATE sessions SET global_id = ?, legacy_global_id = ? WHERE id = ?", newID, oldID, s.id); err != nil {
+			return fmt.Errorf("failed to rename session %d: %w", s.id, err)
+		}
+		if _, err := tx.Exec("UPDATE upload_queue SET global_session_id = ? WHERE session_id = ? AND status != ?", newID, s.id, uploadDone); err != nil {
+			return fmt.Errorf("failed to rename queued uploads of session %d: %w", s.id, err)
+		}
+		if _, err := tx.Exec("DELETE FROM sync_files WHERE session_id = ?", s.id); err != nil {
+			return fmt.Errorf("failed to reset sync records of session %d: %w", s.id, err)
+		}
+	}
+	fmt.Printf("Added this device's ID to %d earlier session ID(s); run -sync to upload them under the new IDs\n", len(legacy))
+	return nil
+}
+
+func addPurgeColumns(tx *sql.Tx, sm *SessionManager) error {
+	if err := addColumn(tx, "sessions", "purged_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := addColumn(tx, "sessions", "purge_scope", "TEXT"); err != nil {
+		return err
+	}
+	return addColumn(tx, "screenshots", "purged_at", "DATETIME")
+}
+
+// addLastActivity backfills each session's last activity with its latest
+// screenshot, or its start when it has none
+func addLastActivity(tx *sql.Tx, sm *SessionManager) error {
+	if err := addColumn(tx, "sessions", "last_activity_at", "DATETIME"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`UPDATE sessions SET last_activity_at = COALESCE(
+		(SELECT MAX(timestamp) FROM screenshots WHERE screenshots.session_id = sessions.id), start_time)
+		WHERE last_activity_at IS NULL`)
+	return err
+}
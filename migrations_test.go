@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeBaselineDatabase writes a sessions.db in dir as released before
+// versioned migrations, with one session and its screenshot
+func writeBaselineDatabase(t *testing.T, dir string) string {
+	t.Helper()
+	dbPath := filepath.Join(dir, "sessions.db")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		`CREATE TABLE sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			start_time DATETIME NOT NULL,
+			end_time DATETIME,
+			description TEXT,
+			student_name TEXT,
+			status TEXT NOT NULL DEFAULT 'active'
+		)`,
+		`CREATE TABLE screenshots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			session_id INTEGER NOT NULL,
+			timestamp DATETIME NOT NULL,
+			file_path TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			FOREIGN KEY (session_id) REFERENCES sessions (id)
+		)`,
+		`INSERT INTO sessions (start_time, end_time, description, student_name, status)
+			VALUES ('2024-03-01 09:00:00', '2024-03-01 10:00:00', 'Fractions', 'Ada', 'completed')`,
+		`INSERT INTO screenshots (session_id, timestamp, file_path, file_size)
+			VALUES (1, '2024-03-01 09:00:30', 'screenshot_001.jpg', 1234)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dbPath
+}
+
+func dirEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	dir := t.TempDir()
+	writeBaselineDatabase(t, dir)
+
+	for run := 1; run <= 2; run++ {
+		sm, err := NewSessionManager(dir, testDeviceUUID)
+		if err != nil {
+			t.Fatalf("run %d: NewSessionManager: %v", run, err)
+		}
+		if version, err := schemaVersion(sm.db); err != nil || version != latestSchemaVersion() {
+			t.Errorf("run %d: schema version %d (%v), want %d", run, version, err, latestSchemaVersion())
+		}
+		// Each step is recorded once, however often the database is opened
+		var steps int
+		if err := sm.db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&steps); err != nil || steps != len(migrations) {
+			t.Errorf("run %d: %d schema_version rows (%v), want %d", run, steps, err, len(migrations))
+		}
+		if err := sm.migrate(); err != nil {
+			t.Errorf("run %d: migrating again: %v", run, err)
+		}
+
+		session, err := sm.GetSessionByID(1)
+		if err != nil {
+			t.Fatalf("run %d: GetSessionByID: %v", run, err)
+		}
+		if session.Description != "Fractions" || session.StudentName != "Ada" || session.Status != "completed" {
+			t.Errorf("run %d: session = %+v", run, session)
+		}
+		if !strings.HasPrefix(session.GlobalID(), testDeviceUUID+"_") {
+			t.Errorf("run %d: GlobalID = %q, want it qualified by the device", run, session.GlobalID())
+		}
+		screenshots, err := sm.GetSessionScreenshots(1)
+		if err != nil || len(screenshots) != 1 || screenshots[0].FileSize != 1234 {
+			t.Errorf("run %d: screenshots = %+v (%v)", run, screenshots, err)
+		}
+		sm.Close()
+	}
+}
+
+func TestMigrateModeDryRun(t *testing.T) {
+	dataDir := filepath.Join(t.TempDir(), "sessions")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := writeBaselineDatabase(t, dataDir)
+	configPath := writeTestConfig(t, map[string]interface{}{"data_dir": dataDir})
+	before, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := dirEntries(t, dataDir)
+
+	runMigrateMode(configPath, true)
+
+	after, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("dry run changed sessions.db")
+	}
+	if got := dirEntries(t, dataDir); !reflect.DeepEqual(got, entries) {
+		t.Errorf("data_dir holds %v after a dry run, want %v", got, entries)
+	}
+
+	runMigrateMode(configPath, false)
+	if version, err := readSchemaVersion(dbPath); err != nil || version != latestSchemaVersion() {
+		t.Errorf("after migrating, schema version %d (%v), want %d", version, err, latestSchemaVersion())
+	}
+}
+
+func TestMigrateModeDryRunCreatesNothing(t *testing.T) {
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "sessions")
+
+	// An existing config naming a data_dir that doesn't exist yet
+	configPath := writeTestConfig(t, map[string]interface{}{"data_dir": dataDir})
+	runMigrateMode(configPath, true)
+	if _, err := os.Stat(dataDir); !os.IsNotExist(err) {
+		t.Errorf("dry run created data_dir (%v)", err)
+	}
+
+	// No config file at all
+	missing := filepath.Join(dir, "config.json")
+	runMigrateMode(missing, true)
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("dry run created a config file (%v)", err)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type SyncSettings struct {
+	ConflictPolicy string `json:"conflict_policy"` // "remote" (default) or "local": which side wins when both changed
+}
+
+const (
+	conflictRemoteWins = "remote"
+	conflictLocalWins  = "local"
+)
+
+// remoteSessionData is what GET /api/student-names returns, keyed by
+// global session ID
+type remoteSessionData struct {
+	Names     map[string]string `json:"names"`
+	Status    map[string]string `json:"status"`
+	Summaries map[string]string `json:"summaries"`
+	Details   map[string]struct {
+		Timelapse *TimelapseMetadata `json:"timelapse"`
+	} `json:"details"`
+}
+
+// FetchSessionData reads the names, statuses and summaries the webapp
+// holds for every session
+func (c *WebappClient) FetchSessionData() (*remoteSessionData, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/student-names", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &uploadError{status: resp.StatusCode, body: resp.Status}
+	}
+	var data remoteSessionData
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode session data: %w", err)
+	}
+	return &data, nil
+}
+
+// syncState is a session with the values last agreed with the webapp.
+// A field that differs from its synced value was changed on that side.
+type syncState struct {
+	Session
+	SyncedName    sql.NullString
+	SyncedSummary sql.NullString
+}
+
+// getSyncStates returns every session with its sync columns
+func (sm *SessionManager) getSyncStates() ([]syncState, error) {
+	rows, err := sm.db.Query(
+		"SELECT " + sessionColumns + ", synced_name, synced_summary FROM sessions ORDER BY start_time",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []syncState
+	for rows.Next() {
+		var state syncState
+		session, err := scanSession(rows, &state.SyncedName, &state.SyncedSummary)
+		if err != nil {
+			return nil, err
+		}
+		state.Session = *session
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// setStudentName changes a session's student name, keeping the in-memory
+// current session in step
+func (sm *SessionManager) setStudentName(sessionID int, name string) error {
+	if _, err := sm.db.Exec("UPDATE sessions SET student_name = ? WHERE id = ?", name, sessionID); err != nil {
+		return err
+	}
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.currentSession != nil && sm.currentSession.ID == sessionID {
+		sm.currentSession.StudentName = name
+	}
+	return nil
+}
+
+// SetSummary stores the summary generated for a session
+func (sm *SessionManager) SetSummary(sessionID int, summary string) error {
+	_, err := sm.db.Exec("UPDATE sessions SET summary = ? WHERE id = ?", summary, sessionID)
+	return err
+}
+
+// markSynced records the values now shared with the webapp; empty
+// arguments leave that field's synced value alone. Values sent through the
+// outbox are only marked once delivered, by updateSent.
+func (sm *SessionManager) markSynced(sessionID int, name, summary string) error {
+	if name != "" {
+		if _, err := sm.db.Exec("UPDATE sessions SET synced_name = ? WHERE id = ?", name, sessionID); err != nil {
+			return err
+		}
+	}
+	if summary != "" {
+		if _, err := sm.db.Exec("UPDATE sessions SET synced_summary = ? WHERE id = ?", summary, sessionID); err != nil {
+			return err
+		}
+	}
+	_, err := sm.db.Exec("UPDATE sessions SET synced_at = ? WHERE id = ?", time.Now(), sessionID)
+	return err
+}
+
+// updateSent is the outbox's delivery hook. Names and summaries the webapp
+// accepted become the values both sides agree on; until then a sync still
+// sees them as local changes and pushes them again.
+func (app *App) updateSent(sink string, sessionID int, update SessionUpdate) {
+	if sink != app.webappSink || (update.StudentName == "" && update.Summary == "") {
+		return
+	}
+	if err := app.sessionManager.markSynced(sessionID, update.StudentName, update.Summary); err != nil {
+		fmt.Printf("Warning: Failed to record sync of session %d: %v\n", sessionID, err)
+	}
+}
+
+// syncAction is what a sync decides for one field
+type syncAction int
+
+const (
+	syncNothing syncAction = iota
+	syncPull               // Take the webapp's value
+	syncPush               // Send the local value
+)
+
+// mergeField compares a field's local and remote values against the value
+// both sides last agreed on. Whichever side changed wins; when both did,
+// policy decides. An empty remote value never replaces a local one.
+func mergeField(local, remote string, synced sql.NullString, policy string) (action syncAction, conflict bool) {
+	switch {
+	case local == remote:
+		return syncNothing, false
+	case remote == "":
+		return syncPush, false
+	case synced.Valid && local == synced.String:
+		return syncPull, false
+	case synced.Valid && remote == synced.String:
+		return syncPush, false
+	case local == "":
+		return syncPull, false
+	}
+
+	// Both sides changed, or they were never synced
+	if policy == conflictLocalWins {
+		return syncPush, true
+	}
+	return syncPull, true
+}
+
+// SyncReport counts what a sync changed
+type SyncReport struct {
+	Sessions        int
+	NamesPulled     int
+	NamesPushed     int
+	SummariesPulled int
+	SummariesPushed int
+	StatusPushed    int
+	Conflicts       int
+}
+
+func (r SyncReport) String() string {
+	return fmt.Sprintf("Synced %d session(s) with webapp: names %d pulled, %d pushed; summaries %d pulled, %d pushed; %d completed status sent; %d conflict(s)",
+		r.Sessions, r.NamesPulled, r.NamesPushed, r.SummariesPulled, r.SummariesPushed, r.StatusPushed, r.Conflicts)
+}
+
+// SyncWithWebapp reconciles student names, summaries and completion
+// status with the webapp. Names and summaries follow whichever side
+// changed since the last sync, with the conflict policy settling cases
+// where both did. Completion only flows from here to the webapp: a session
+// still running locally is never stopped because the webapp marked it
+// complete.
+func (app *App) SyncWithWebapp() (*SyncReport, error) {
+	if app.webapp == nil {
+		return nil, fmt.Errorf("no webapp_url configured")
+	}
+	policy := app.config.Sync.ConflictPolicy
+	if policy != conflictRemoteWins && policy != conflictLocalWins {
+		return nil, fmt.Errorf("unknown sync conflict policy %q", policy)
+	}
+
+	remote, err := app.webapp.FetchSessionData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch session data from webapp: %w", err)
+	}
+	states, err := app.sessionManager.getSyncStates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	report := &SyncReport{}
+	for _, state := range states {
+		globalID := state.GlobalID()
+		update := SessionUpdate{SessionID: globalID}
+		var syncedName, syncedSummary string
+
+		remoteName := remote.Names[globalID]
+		action, conflict := mergeField(state.StudentName, remoteName, state.SyncedName, policy)
+		if conflict {
+			report.Conflicts++
+			fmt.Printf("Session %d: student name is %q here and %q on the webapp; keeping the %s one\n",
+				state.ID, state.StudentName, remoteName, winningSide(action))
+		}
+		switch action {
+		case syncPull:
+			if err := app.sessionManager.setStudentName(state.ID, remoteName); err != nil {
+				return report, fmt.Errorf("failed to update session %d: %w", state.ID, err)
+			}
+			fmt.Printf("Session %d: student name %q → %q\n", state.ID, state.StudentName, remoteName)
+			syncedName = remoteName
+			report.NamesPulled++
+		case syncPush:
+			// Recorded as synced once the outbox delivers it
+			if state.StudentName != "" {
+				update.StudentName = state.StudentName
+				report.NamesPushed++
+			}
+		case syncNothing:
+			if !state.SyncedName.Valid || state.SyncedName.String != state.StudentName {
+				syncedName = state.StudentName
+			}
+		}
+
+		remoteSummary := remote.Summaries[globalID]
+		action, conflict = mergeField(state.Summary, remoteSummary, state.SyncedSummary, policy)
+		if conflict {
+			report.Conflicts++
+			fmt.Printf("Session %d: summary differs here and on the webapp; keeping the %s one\n",
+				state.ID, winningSide(action))
+		}
+		switch action {
+		case syncPull:
+			if err := app.sessionManager.SetSummary(state.ID, remoteSummary); err != nil {
+				return report, fmt.Errorf("failed to update session %d: %w", state.ID, err)
+			}
+			syncedSummary = remoteSummary
+			report.SummariesPulled++
+		case syncPush:
+			if state.Summary != "" {
+				update.Summary = state.Summary
+				report.SummariesPushed++
+			}
+		case syncNothing:
+			if !state.SyncedSummary.Valid || state.SyncedSummary.String != state.Summary {
+				syncedSummary = state.Summary
+			}
+		}
+
+		switch {
+		case state.Status == "completed" && remote.Status[globalID] != "completed":
+			pauses, err := app.sessionManager.GetSessionPauses(state.ID)
+			if err != nil {
+				fmt.Printf("Warning: Failed to load pauses: %v\n", err)
+			}
+			stopped := sessionStoppedUpdate(&state.Session, pauses)
+			update.Status, update.StartTime, update.EndTime, update.ActiveSeconds =
+				stopped.Status, stopped.StartTime, stopped.EndTime, stopped.ActiveSeconds
+			report.StatusPushed++
+		case state.Status == "active" && remote.Status[globalID] == "completed":
+			fmt.Printf("Session %d is marked completed on the webapp but is still active here; leaving it running\n", state.ID)
+		}
+
+		if update.StudentName != "" || update.Summary != "" || update.Status != "" {
+			if err := app.outbox.EnqueueUpdate(state.ID, update); err != nil {
+				return report, err
+			}
+		}
+		if syncedName != "" || syncedSummary != "" {
+			if err := app.sessionManager.markSynced(state.ID, syncedName, syncedSummary); err != nil {
+				return report, fmt.Errorf("failed to record sync of session %d: %w", state.ID, err)
+			}
+		}
+		report.Sessions++
+	}
+	return report, nil
+}
+
+func winningSide(action syncAction) string {
+	if action == syncPush {
+		return "local"
+	}
+	return "webapp"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMergeField(t *testing.T) {
+	synced := func(value string) sql.NullString { return sql.NullString{String: value, Valid: true} }
+	never := sql.NullString{}
+
+	tests := []struct {
+		name         string
+		local        string
+		remote       string
+		synced       sql.NullString
+		remoteWins   syncAction
+		localWins    syncAction
+		wantConflict bool
+	}{
+		{"unchanged", "Ada", "Ada", synced("Ada"), syncNothing, syncNothing, false},
+		{"local changed", "Ada L", "Ada", synced("Ada"), syncPush, syncPush, false},
+		{"remote changed", "Ada", "Ada L", synced("Ada"), syncPull, syncPull, false},
+		{"both changed", "Ada L", "Ada B", synced("Ada"), syncPull, syncPush, true},
+		{"both changed alike", "Ada L", "Ada L", synced("Ada"), syncNothing, syncNothing, false},
+		{"remote empty", "Ada", "", synced("Ada"), syncPush, syncPush, false},
+		{"remote cleared after sync", "Ada", "", synced("Ada B"), syncPush, syncPush, false},
+		{"local empty", "", "Ada", never, syncPull, syncPull, false},
+		{"never synced", "Ada", "Ada B", never, syncPull, syncPush, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for policy, want := range map[string]syncAction{conflictRemoteWins: tt.remoteWins, conflictLocalWins: tt.localWins} {
+				action, conflict := mergeField(tt.local, tt.remote, tt.synced, policy)
+				if action != want || conflict != tt.wantConflict {
+					t.Errorf("%s wins: mergeField = %v, %v; want %v, %v", policy, action, conflict, want, tt.wantConflict)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncWithWebapp(t *testing.T) {
+	tests := []struct {
+		policy    string
+		wantBoth  string // Name of the session changed on both sides
+		conflicts int
+		pulled    int
+		pushed    int
+	}{
+		{conflictRemoteWins, "Cyril", 1, 2, 1},
+		{conflictLocalWins, "Cyrus", 1, 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			var mu sync.Mutex
+			remoteNames := make(map[string]string)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Method == "POST" {
+					var update SessionUpdate
+					if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					if update.StudentName != "" {
+						remoteNames[update.SessionID] = update.StudentName
+					}
+					w.Write([]byte(`{"success": true}`))
+					return
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{"names": remoteNames})
+			}))
+			defer server.Close()
+			app := newTestApp(t, map[string]interface{}{
+				"webapp_url": server.URL,
+				"sync":       map[string]string{"conflict_policy": tt.policy},
+			})
+			sm := app.sessionManager
+
+			// Each session was synced as synced, then renamed locally,
+			// remotely or both
+			sessions := []struct{ synced, local, remote string }{
+				{"Ada", "Ada Lovelace", "Ada"}, // Local change
+				{"Bob", "Bob", "Robert"},       // Remote change
+				{"Cy", "Cyrus", "Cyril"},       // Both changed
+			}
+			ids := make([]int, len(sessions))
+			syncedBefore := make(map[int]string)
+			for i, s := range sessions {
+				session, err := sm.StartSession("Sync test", s.synced)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := sm.StopSession(); err != nil {
+					t.Fatal(err)
+				}
+				if err := sm.markSynced(session.ID, s.synced, ""); err != nil {
+					t.Fatal(err)
+				}
+				if err := sm.setStudentName(session.ID, s.local); err != nil {
+					t.Fatal(err)
+				}
+				remoteNames[session.GlobalID()] = s.remote
+				ids[i] = session.ID
+				syncedBefore[session.ID] = s.synced
+			}
+
+			report, err := app.SyncWithWebapp()
+			if err != nil {
+				t.Fatalf("SyncWithWebapp: %v", err)
+			}
+			if report.Conflicts != tt.conflicts || report.NamesPulled != tt.pulled || report.NamesPushed != tt.pushed {
+				t.Errorf("report = %+v", report)
+			}
+
+			for i, want := range []string{"Ada Lovelace", "Robert", tt.wantBoth} {
+				session, err := sm.GetSessionByID(ids[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if session.StudentName != want {
+					t.Errorf("session %d is named %q, want %q", i+1, session.StudentName, want)
+				}
+			}
+
+			// Pushed names are queued for the webapp
+			pushed := make(map[int]string)
+			rows, err := sm.db.Query("SELECT session_id, payload FROM upload_queue WHERE kind = ?", uploadSessionUpdate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for rows.Next() {
+				var sessionID int
+				var payload string
+				if err := rows.Scan(&sessionID, &payload); err != nil {
+					t.Fatal(err)
+				}
+				var update SessionUpdate
+				if err := json.Unmarshal([]byte(payload), &update); err != nil {
+					t.Fatal(err)
+				}
+				if update.StudentName != "" {
+					pushed[sessionID] = update.StudentName
+				}
+			}
+			rows.Close()
+			if len(pushed) != tt.pushed || pushed[ids[0]] != "Ada Lovelace" {
+				t.Errorf("names queued for the webapp = %v", pushed)
+			}
+
+			// Pulled names are agreed at once, pushed ones only when the
+			// webapp has them
+			checkSynced := func(delivered bool) {
+				t.Helper()
+				states, err := sm.getSyncStates()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, state := range states {
+					want := state.StudentName
+					if _, ok := pushed[state.ID]; ok && !delivered {
+						want = syncedBefore[state.ID]
+					}
+					if state.SyncedName.String != want {
+						t.Errorf("session %d synced as %q, want %q", state.ID, state.SyncedName.String, want)
+					}
+				}
+			}
+			checkSynced(false)
+
+			app.StartUploads()
+			app.outbox.Flush(5 * time.Second)
+			checkSynced(true)
+
+			// With the names delivered, a second sync has nothing left to do
+			report, err = app.SyncWithWebapp()
+			if err != nil {
+				t.Fatalf("second SyncWithWebapp: %v", err)
+			}
+			if report.Conflicts != 0 || report.NamesPulled != 0 || report.NamesPushed != 0 {
+				t.Errorf("second report = %+v", report)
+			}
+		})
+	}
+}
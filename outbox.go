@@ -0,0 +1,597 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type OutboxSettings struct {
+	MaxAttempts       int `json:"max_attempts"`         // Attempts before an upload is marked dead
+	BaseDelaySeconds  int `json:"base_delay_seconds"`   // Delay before the first retry, doubled for each later one
+	MaxDelaySeconds   int `json:"max_delay_seconds"`    // Upper bound on the retry delay
+	Workers           int `json:"workers"`              // Requests sent in parallel
+	MaxBytesPerSecond int `json:"max_bytes_per_second"` // Combined upload bandwidth (0 = unlimited)
+	BatchThreshold    int `json:"batch_threshold"`      // Due uploads before screenshots are sent in batches
+	BatchSize         int `json:"batch_size"`           // Screenshots per request when batching (1 = never batch)
+}
+
+// Upload queue states
+const (
+	uploadPending = "pending"
+	uploadDone    = "done"
+	uploadDead    = "dead" // Gave up after MaxAttempts or a permanent error
+)
+
+// uploadLease is how long a claimed upload stays hidden from other
+// workers; if the process dies mid-upload it becomes due again after this
+const uploadLease = 2 * time.Minute
+
+// UploadOutbox is a durable queue of screenshots and session updates
+// waiting to reach the upload sinks. Rows live in the upload_queue table,
+// one per sink, so uploads that fail while offline are retried with
+// backoff and survive restarts.
+type UploadOutbox struct {
+	db       *sql.DB
+	sinks    []*uploadSink
+	settings OutboxSettings
+
+	// Told about each session update a sink accepted; may be nil
+	updateSent func(sink string, sessionID int, update SessionUpdate)
+
+	wake     chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+	inFlight int64
+}
+
+// Kinds of upload_queue rows
+const (
+	uploadScreenshot    = "screenshot"     // file_path is sent to /api/screenshots
+	uploadRendition     = "rendition"      // As screenshot, but file_path is an upload copy removed once sent
+	uploadSessionUpdate = "session_update" // payload is a SessionUpdate
+	uploadTimelapse     = "timelapse"      // file_path is a video; payload is its TimelapseMetadata
+)
+
+// uploadItem is one row of upload_queue
+type uploadItem struct {
+	ID              int
+	SessionID       int
+	GlobalSessionID string
+	Sink            string
+	Kind            string
+	FilePath        string
+	Payload         string
+	CapturedAt      time.Time
+	Attempts        int
+}
+
+// describe names the item in log messages
+func (item uploadItem) describe() string {
+	if item.Kind == uploadSessionUpdate {
+		return "session update for " + item.GlobalSessionID
+	}
+	return filepath.Base(item.FilePath)
+}
+
+// NewUploadOutbox creates an outbox delivering to sinks
+func NewUploadOutbox(db *sql.DB, sinks []*uploadSink, settings OutboxSettings) *UploadOutbox {
+	if settings.MaxAttempts <= 0 {
+		settings.MaxAttempts = 10
+	}
+	if settings.BaseDelaySeconds <= 0 {
+		settings.BaseDelaySeconds = 5
+	}
+	if settings.MaxDelaySeconds < settings.BaseDelaySeconds {
+		settings.MaxDelaySeconds = settings.BaseDelaySeconds
+	}
+	if settings.Workers <= 0 {
+		settings.Workers = 2
+	}
+	if settings.BatchSize <= 0 {
+		settings.BatchSize = 1
+	}
+
+	return &UploadOutbox{
+		db:       db,
+		sinks:    sinks,
+		settings: settings,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Enqueue adds a saved screenshot to the queue of every sink taking
+// screenshots and wakes the worker. The frame's upload copy is sent
+// instead of the saved file when it has one.
+func (o *UploadOutbox) Enqueue(sessionID int, globalSessionID string, frame CapturedFrame) error {
+	kind, filePath := uploadScreenshot, frame.FilePath
+	if frame.UploadPath != "" {
+		kind, filePath = uploadRendition, frame.UploadPath
+	}
+	for _, sink := range o.sinks {
+		if !sink.accepts(sinkScreenshots) {
+			continue
+		}
+		if err := o.insert(sink.name, sessionID, globalSessionID, kind, filePath, "", frame.Timestamp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueUpdate queues session details for each sink, trimmed to what
+// that sink takes
+func (o *UploadOutbox) EnqueueUpdate(sessionID int, update SessionUpdate) error {
+	for _, sink := range o.sinks {
+		filtered, ok := sink.filterUpdate(update)
+		if !ok {
+			continue
+		}
+		payload, err := json.Marshal(filtered)
+		if err != nil {
+			return fmt.Errorf("failed to encode session update: %w", err)
+		}
+		if err := o.insert(sink.name, sessionID, update.SessionID, uploadSessionUpdate, "", string(payload), time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueTimelapse queues a timelapse video for the sinks taking
+// timelapses. Once stored, its metadata is reported with the location.
+func (o *UploadOutbox) EnqueueTimelapse(sessionID int, globalSessionID, videoPath string, metadata *TimelapseMetadata) error {
+	payload, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("failed to encode timelapse metadata: %w", err)
+	}
+	for _, sink := range o.sinks {
+		if !sink.accepts(sinkTimelapses) {
+			continue
+		}
+		if err := o.insert(sink.name, sessionID, globalSessionID, uploadTimelapse, videoPath, string(payload), time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *UploadOutbox) insert(sink string, sessionID int, globalSessionID, kind, filePath, payload string, capturedAt time.Time) error {
+	now := time.Now().UTC()
+	_, err := o.db.Exec(
+		"INSERT INTO upload_queue (session_id, global_session_id, sink, kind, file_path, payload, captured_at, status, attempts, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)",
+		sessionID, globalSessionID, sink, kind, filePath, payload, capturedAt.UTC(), uploadPending, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to queue upload: %w", err)
+	}
+
+	o.notify()
+	return nil
+}
+
+func (o *UploadOutbox) notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start runs the worker until Stop, picking up anything left pending by
+// earlier runs
+func (o *UploadOutbox) Start() {
+	if o.stop != nil {
+		return
+	}
+	o.stop = make(chan struct{})
+	o.wg.Add(1)
+	go o.run()
+}
+
+// Stop waits for in-flight uploads to finish; anything still pending is
+// sent on the next launch
+func (o *UploadOutbox) Stop() {
+	if o.stop == nil {
+		return
+	}
+	close(o.stop)
+	o.wg.Wait()
+	o.stop = nil
+}
+
+// Flush waits up to timeout for everything currently due to be sent.
+// Uploads waiting out a retry delay are left for later, so being offline
+// doesn't hold up exit.
+func (o *UploadOutbox) Flush(timeout time.Duration) {
+	if o.stop == nil {
+		return
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if o.dueCount() == 0 && atomic.LoadInt64(&o.inFlight) == 0 {
+			return
+		}
+		o.notify()
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Depth returns how many uploads are waiting and how many were given up on
+func (o *UploadOutbox) Depth() (pending, dead int, err error) {
+	err = o.db.QueryRow(
+		"SELECT COALESCE(SUM(status = ?), 0), COALESCE(SUM(status = ?), 0) FROM upload_queue",
+		uploadPending, uploadDead,
+	).Scan(&pending, &dead)
+	return pending, dead, err
+}
+
+func (o *UploadOutbox) run() {
+	defer o.wg.Done()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		default:
+		}
+
+		// A growing backlog is worked through in batches, trading a
+		// bigger request for fewer round trips
+		limit := o.settings.Workers
+		batching := o.settings.BatchSize > 1 && o.dueCount() > o.settings.BatchThreshold
+		if batching {
+			limit *= o.settings.BatchSize
+		}
+
+		items, err := o.claimDue(limit)
+		if err != nil {
+			fmt.Printf("Upload queue error: %v\n", err)
+		}
+
+		if len(items) > 0 {
+			var requests sync.WaitGroup
+			slots := make(chan struct{}, o.settings.Workers)
+			for _, group := range o.groupRequests(items, batching) {
+				requests.Add(1)
+				slots <- struct{}{}
+				go func(group []uploadItem) {
+					defer requests.Done()
+					defer func() { <-slots }()
+					o.sendGroup(group)
+				}(group)
+			}
+			requests.Wait()
+			atomic.AddInt64(&o.inFlight, -int64(len(items)))
+			continue
+		}
+
+		select {
+		case <-o.stop:
+			return
+		case <-o.wake:
+		case <-time.After(o.untilNextDue()):
+		}
+	}
+}
+
+// dueCount returns how many uploads are waiting to be sent now
+func (o *UploadOutbox) dueCount() int {
+	var due int
+	o.db.QueryRow(
+		"SELECT COUNT(*) FROM upload_queue WHERE status = ? AND next_attempt_at <= ?", uploadPending, time.Now().UTC(),
+	).Scan(&due)
+	return due
+}
+
+// groupRequests splits claimed items into requests. Screenshots of the
+// same session for the same sink share a request of up to BatchSize when
+// batching; session updates and timelapses always go alone.
+func (o *UploadOutbox) groupRequests(items []uploadItem, batching bool) [][]uploadItem {
+	var groups [][]uploadItem
+	open := make(map[string]int) // Sink and session to the index of their unfilled group
+	for _, item := range items {
+		if !batching || (item.Kind != uploadScreenshot && item.Kind != uploadRendition) {
+			groups = append(groups, []uploadItem{item})
+			continue
+		}
+		key := item.Sink + "|" + item.GlobalSessionID
+		if i, ok := open[key]; ok {
+			groups[i] = append(groups[i], item)
+			if len(groups[i]) >= o.settings.BatchSize {
+				delete(open, key)
+			}
+			continue
+		}
+		open[key] = len(groups)
+		groups = append(groups, []uploadItem{item})
+	}
+	return groups
+}
+
+// sink returns the configured sink an item is queued for
+func (o *UploadOutbox) sink(item uploadItem) (*uploadSink, error) {
+	for _, sink := range o.sinks {
+		if sink.name == item.Sink {
+			return sink, nil
+		}
+	}
+	// Removed from config; retrying won't bring it back
+	return nil, &uploadError{status: http.StatusNotFound, body: fmt.Sprintf("no upload sink named %q is configured", item.Sink)}
+}
+
+// sendGroup sends one request and records the outcome for each item in it
+func (o *UploadOutbox) sendGroup(group []uploadItem) {
+	if len(group) == 1 {
+		o.finish(group[0], o.send(group[0]))
+		return
+	}
+
+	var files []uploadFile
+	var sent []uploadItem
+	for _, item := range group {
+		data, err := os.ReadFile(item.FilePath)
+		if err != nil {
+			// Nothing to retry if the file is gone
+			o.finish(item, &uploadError{status: http.StatusGone, body: err.Error()})
+			continue
+		}
+		files = append(files, uploadFile{Filename: filepath.Base(item.FilePath), CapturedAt: item.CapturedAt, Data: data})
+		sent = append(sent, item)
+	}
+	if len(sent) == 0 {
+		return
+	}
+
+	sink, err := o.sink(sent[0])
+	if err == nil {
+		err = sink.UploadScreenshots(sent[0].GlobalSessionID, files)
+	}
+	if err == nil && len(sent) > 1 {
+		fmt.Printf("Sent batch of %d screenshots to %s\n", len(sent), sent[0].Sink)
+	}
+	for _, item := range sent {
+		o.finish(item, err)
+	}
+}
+
+// claimDue takes up to limit due uploads, bumping their attempt count and
+// leasing them so another process sharing the database skips them
+func (o *UploadOutbox) claimDue(limit int) ([]uploadItem, error) {
+	now := time.Now().UTC()
+	rows, err := o.db.Query(
+		"SELECT id, session_id, global_session_id, sink, kind, file_path, COALESCE(payload, ''), captured_at, attempts FROM upload_queue WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?",
+		uploadPending, now, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []uploadItem
+	for rows.Next() {
+		var item uploadItem
+		if err := rows.Scan(&item.ID, &item.SessionID, &item.GlobalSessionID, &item.Sink, &item.Kind, &item.FilePath, &item.Payload, &item.CapturedAt, &item.Attempts); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates = append(candidates, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []uploadItem
+	for _, item := range candidates {
+		// Counted before the row stops being due so Flush never sees neither
+		atomic.AddInt64(&o.inFlight, 1)
+		result, err := o.db.Exec(
+			"UPDATE upload_queue SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ? AND status = ? AND attempts = ?",
+			now.Add(uploadLease), item.ID, uploadPending, item.Attempts,
+		)
+		if err != nil {
+			atomic.AddInt64(&o.inFlight, -1)
+			return claimed, err
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			item.Attempts++
+			claimed = append(claimed, item)
+		} else {
+			atomic.AddInt64(&o.inFlight, -1)
+		}
+	}
+	return claimed, nil
+}
+
+// untilNextDue returns how long to sleep before the next pending upload
+// is due, capped so new rows from other processes are noticed
+func (o *UploadOutbox) untilNextDue() time.Duration {
+	wait := time.Minute
+	var next sql.NullTime
+	if err := o.db.QueryRow(
+		"SELECT next_attempt_at FROM upload_queue WHERE status = ? ORDER BY next_attempt_at LIMIT 1", uploadPending,
+	).Scan(&next); err == nil && next.Valid {
+		if d := time.Until(next.Time); d < wait {
+			wait = d
+		}
+	}
+	if wait < 100*time.Millisecond {
+		wait = 100 * time.Millisecond
+	}
+	return wait
+}
+
+// finish records the outcome of an attempt: done, retry later with
+// backoff, or dead once attempts run out or the error is permanent. If the
+// outcome can't be recorded the row keeps its lease and is simply tried
+// again once that runs out.
+func (o *UploadOutbox) finish(item uploadItem, err error) {
+	if err == nil {
+		if _, dbErr := o.db.Exec(
+			"UPDATE upload_queue SET status = ?, uploaded_at = ?, last_error = NULL WHERE id = ?",
+			uploadDone, time.Now().UTC(), item.ID,
+		); dbErr != nil {
+			fmt.Printf("Warning: Sent %s to %s but failed to record it: %v\n", item.describe(), item.Sink, dbErr)
+			return
+		}
+		switch item.Kind {
+		case uploadSessionUpdate, uploadTimelapse:
+			fmt.Printf("Sent %s to %s\n", item.describe(), item.Sink)
+		default:
+			fmt.Printf("Screenshot sent to %s: %s\n", item.Sink, item.describe())
+		}
+		if item.Kind == uploadRendition {
+			o.removeRendition(item.FilePath)
+		}
+		if item.Kind == uploadSessionUpdate && o.updateSent != nil {
+			var update SessionUpdate
+			if json.Unmarshal([]byte(item.Payload), &update) == nil {
+				o.updateSent(item.Sink, item.SessionID, update)
+			}
+		}
+		return
+	}
+
+	permanent := false
+	if uploadErr, ok := err.(*uploadError); ok {
+		permanent = uploadErr.permanent()
+	}
+
+	if permanent || item.Attempts >= o.settings.MaxAttempts {
+		if _, dbErr := o.db.Exec(
+			"UPDATE upload_queue SET status = ?, last_error = ? WHERE id = ?",
+			uploadDead, err.Error(), item.ID,
+		); dbErr != nil {
+			fmt.Printf("Warning: Failed to record giving up on %s: %v\n", item.describe(), dbErr)
+			return
+		}
+		fmt.Printf("Giving up on uploading %s after %d attempt(s): %v\n", item.describe(), item.Attempts, err)
+		// The saved screenshot stays; only the upload copy is dropped
+		if item.Kind == uploadRendition {
+			o.removeRendition(item.FilePath)
+		}
+		return
+	}
+
+	delay := o.backoff(item.Attempts)
+	if _, dbErr := o.db.Exec(
+		"UPDATE upload_queue SET next_attempt_at = ?, last_error = ? WHERE id = ?",
+		time.Now().UTC().Add(delay), err.Error(), item.ID,
+	); dbErr != nil {
+		fmt.Printf("Warning: Failed to schedule retry of %s: %v\n", item.describe(), dbErr)
+		return
+	}
+	fmt.Printf("Upload of %s failed (attempt %d), retrying in %s: %v\n",
+		item.describe(), item.Attempts, delay.Round(time.Second), err)
+}
+
+// backoff doubles the base delay for each attempt up to the maximum, then
+// picks a random point in its upper half so many clients coming back
+// online together don't retry in lockstep
+func (o *UploadOutbox) backoff(attempt int) time.Duration {
+	delay := time.Duration(o.settings.BaseDelaySeconds) * time.Second
+	max := time.Duration(o.settings.MaxDelaySeconds) * time.Second
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// uploadError is a non-2xx response from the webapp
+type uploadError struct {
+	status int
+	body   string
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("server returned status %d: %s", e.status, e.body)
+}
+
+// permanent reports whether retrying cannot help: client errors other
+// than timeouts, rate limiting and rejected signatures. Each attempt is
+// signed afresh, so an upload whose signature expired on the way is
+// accepted on a retry.
+func (e *uploadError) permanent() bool {
+	return e.status >= 400 && e.status < 500 &&
+		e.status != http.StatusRequestTimeout && e.status != http.StatusTooManyRequests &&
+		e.status != http.StatusUnauthorized
+}
+
+// send delivers one queued item to its sink
+func (o *UploadOutbox) send(item uploadItem) error {
+	sink, err := o.sink(item)
+	if err != nil {
+		return err
+	}
+
+	switch item.Kind {
+	case uploadSessionUpdate:
+		var update SessionUpdate
+		if err := json.Unmarshal([]byte(item.Payload), &update); err != nil {
+			// A corrupt payload will never succeed
+			return &uploadError{status: http.StatusBadRequest, body: err.Error()}
+		}
+		return sink.UpdateSession(update)
+	case uploadTimelapse:
+		return o.sendTimelapse(sink, item)
+	}
+
+	data, err := os.ReadFile(item.FilePath)
+	if err != nil {
+		// Nothing to retry if the file is gone
+		return &uploadError{status: http.StatusGone, body: err.Error()}
+	}
+	return sink.UploadScreenshots(item.GlobalSessionID, []uploadFile{
+		{Filename: filepath.Base(item.FilePath), CapturedAt: item.CapturedAt, Data: data},
+	})
+}
+
+// sendTimelapse stores the video, then reports its metadata with where it
+// went. A sink that refuses the video, e.g. because it is too large, still
+// gets the metadata.
+func (o *UploadOutbox) sendTimelapse(sink *uploadSink, item uploadItem) error {
+	var metadata TimelapseMetadata
+	if err := json.Unmarshal([]byte(item.Payload), &metadata); err != nil {
+		return &uploadError{status: http.StatusBadRequest, body: err.Error()}
+	}
+
+	data, err := os.ReadFile(item.FilePath)
+	if err == nil {
+		metadata.URL, err = sink.UploadTimelapse(item.GlobalSessionID, uploadFile{
+			Filename:   filepath.Base(item.FilePath),
+			CapturedAt: item.CapturedAt,
+			Data:       data,
+		})
+	} else {
+		err = &uploadError{status: http.StatusGone, body: err.Error()}
+	}
+	if err != nil {
+		var uploadErr *uploadError
+		if !errors.As(err, &uploadErr) || !uploadErr.permanent() {
+			return err
+		}
+		fmt.Printf("Warning: %s did not take timelapse %s: %v; sending its details only\n", item.Sink, item.describe(), err)
+	}
+	return sink.UpdateSession(SessionUpdate{SessionID: item.GlobalSessionID, Timelapse: &metadata})
+}
+
+// removeRendition deletes an upload copy once no sink still needs it
+func (o *UploadOutbox) removeRendition(path string) {
+	var waiting int
+	if err := o.db.QueryRow(
+		"SELECT COUNT(*) FROM upload_queue WHERE file_path = ? AND status = ?", path, uploadPending,
+	).Scan(&waiting); err != nil || waiting > 0 {
+		return
+	}
+	os.Remove(path)
+}
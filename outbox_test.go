@@ -0,0 +1,213 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// screenshotServer answers /api/screenshots with the status codes in
+// order, repeating the last one, and counts the uploads it received
+func screenshotServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/screenshots" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("invalid upload form: %v", err)
+		} else if r.FormValue("sessionId") == "" || len(r.MultipartForm.File["screenshot"]) != len(r.MultipartForm.Value["timestamp"]) {
+			t.Errorf("upload is missing its session or timestamps")
+		}
+
+		n := int(atomic.AddInt32(&requests, 1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(`{"success": true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func webappSinks(url string) []*uploadSink {
+	return []*uploadSink{{UploadSink: NewWebappClient(url, nil, nil), name: "webapp"}}
+}
+
+// waitForQueue waits until the outbox has no pending uploads
+func waitForQueue(t *testing.T, outbox *UploadOutbox) (dead int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		pending, dead, err := outbox.Depth()
+		if err != nil {
+			t.Fatalf("Depth: %v", err)
+		}
+		if pending == 0 {
+			return dead
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("upload queue did not drain")
+	return 0
+}
+
+func writeTestFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("jpeg data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutboxRetriesAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	settings := OutboxSettings{MaxAttempts: 3, BaseDelaySeconds: 1, MaxDelaySeconds: 1}
+
+	// Queue a screenshot while offline, then "exit"
+	sm := openTestSessionManager(t, dir)
+	session, err := sm.StartSession("Outbox test", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shot := filepath.Join(sm.GetSessionDir(session.ID), "screenshot.jpg")
+	writeTestFile(t, shot)
+	offline := NewUploadOutbox(sm.db, webappSinks("http://127.0.0.1:1"), settings)
+	if err := offline.Enqueue(session.ID, session.GlobalID(), CapturedFrame{FilePath: shot, Timestamp: time.Now()}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	sm.Close()
+
+	// The next run finds the row and delivers it once the server recovers
+	server, requests := screenshotServer(t, http.StatusInternalServerError, http.StatusOK)
+	sm = openTestSessionManager(t, dir)
+	outbox := NewUploadOutbox(sm.db, webappSinks(server.URL), settings)
+	if pending, _, err := outbox.Depth(); err != nil || pending != 1 {
+		t.Fatalf("after restart Depth = %d pending (%v), want 1", pending, err)
+	}
+	outbox.Start()
+	defer outbox.Stop()
+
+	if dead := waitForQueue(t, outbox); dead != 0 {
+		t.Fatalf("%d upload(s) dead, want 0", dead)
+	}
+	if n := atomic.LoadInt32(requests); n != 2 {
+		t.Errorf("server saw %d requests, want 2 (a 500, then a 200)", n)
+	}
+
+	var status string
+	var attempts int
+	if err := sm.db.QueryRow("SELECT status, attempts FROM upload_queue").Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != uploadDone || attempts != 2 {
+		t.Errorf("row is %s after %d attempt(s), want done after 2", status, attempts)
+	}
+}
+
+func TestOutboxDeadLetters(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		requests int32
+	}{
+		{"attempts run out", http.StatusInternalServerError, 2},
+		{"permanent error", http.StatusBadRequest, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := newTestSessionManager(t)
+			session, err := sm.StartSession("Outbox test", "Ada")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sessionDir := sm.GetSessionDir(session.ID)
+			shot := filepath.Join(sessionDir, "screenshot.jpg")
+			rendition := uploadRenditionPath(shot)
+			writeTestFile(t, shot)
+			if err := writeUploadRendition(rendition, []byte("smaller jpeg")); err != nil {
+				t.Fatal(err)
+			}
+
+			server, requests := screenshotServer(t, tt.status)
+			outbox := NewUploadOutbox(sm.db, webappSinks(server.URL), OutboxSettings{MaxAttempts: 2, BaseDelaySeconds: 1, MaxDelaySeconds: 1})
+			frame := CapturedFrame{FilePath: shot, UploadPath: rendition, Timestamp: time.Now()}
+			if err := outbox.Enqueue(session.ID, session.GlobalID(), frame); err != nil {
+				t.Fatalf("Enqueue: %v", err)
+			}
+			outbox.Start()
+			defer outbox.Stop()
+
+			if dead := waitForQueue(t, outbox); dead != 1 {
+				t.Fatalf("%d upload(s) dead, want 1", dead)
+			}
+			// The row is marked dead before its copy is removed
+			outbox.Stop()
+			if n := atomic.LoadInt32(requests); n != tt.requests {
+				t.Errorf("server saw %d requests, want %d", n, tt.requests)
+			}
+
+			var lastError string
+			if err := sm.db.QueryRow("SELECT last_error FROM upload_queue").Scan(&lastError); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(lastError, "status") {
+				t.Errorf("last_error = %q, want the server's response", lastError)
+			}
+			if _, err := os.Stat(rendition); !os.IsNotExist(err) {
+				t.Errorf("upload copy still on disk after giving up (%v)", err)
+			}
+			if _, err := os.Stat(shot); err != nil {
+				t.Errorf("saved screenshot removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestUploadErrorPermanent(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusUnauthorized, false}, // Signed again on the retry
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		if got := (&uploadError{status: tt.status}).permanent(); got != tt.permanent {
+			t.Errorf("status %d permanent = %v, want %v", tt.status, got, tt.permanent)
+		}
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	outbox := NewUploadOutbox(nil, nil, OutboxSettings{BaseDelaySeconds: 5, MaxDelaySeconds: 60})
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 2500 * time.Millisecond, 5 * time.Second},
+		{2, 5 * time.Second, 10 * time.Second},
+		{3, 10 * time.Second, 20 * time.Second},
+		{10, 30 * time.Second, 60 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := outbox.backoff(tt.attempt); d < tt.min || d > tt.max {
+				t.Errorf("backoff(%d) = %s, want between %s and %s", tt.attempt, d, tt.min, tt.max)
+			}
+		}
+	}
+}
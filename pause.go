@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SessionPause is one interval during which capture was paused. ResumedAt
+// is zero while the pause is still open.
+type SessionPause struct {
+	ID        int       `json:"id"`
+	SessionID int       `json:"session_id"`
+	PausedAt  time.Time `json:"paused_at"`
+	ResumedAt time.Time `json:"resumed_at,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// PauseSession opens a pause on the session. The running capture loop
+// checks the database every tick, so this also works from another process.
+func (sm *SessionManager) PauseSession(sessionID int, reason string) error {
+	paused, err := sm.IsPaused(sessionID)
+	if err != nil {
+		return err
+	}
+	if paused {
+		return fmt.Errorf("session %d is already paused", sessionID)
+	}
+
+	_, err = sm.db.Exec(
+		"INSERT INTO session_pauses (session_id, paused_at, reason) VALUES (?, ?, ?)",
+		sessionID, time.Now(), reason,
+	)
+	return err
+}
+
+// ResumeSession closes the session's open pause
+func (sm *SessionManager) ResumeSession(sessionID int) error {
+	return sm.closePause(sessionID, time.Now(), true)
+}
+
+// closePause ends any open pause at the given time. With mustExist set it
+// is an error for the session not to be paused.
+func (sm *SessionManager) closePause(sessionID int, at time.Time, mustExist bool) error {
+	result, err := sm.db.Exec(
+		"UPDATE session_pauses SET resumed_at = ? WHERE session_id = ? AND resumed_at IS NULL",
+		at, sessionID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if mustExist {
+		if n, _ := result.RowsAffected(); n == 0 {
+			return fmt.Errorf("session %d is not paused", sessionID)
+		}
+	}
+	return nil
+}
+
+// IsPaused reports whether the session has an open pause
+func (sm *SessionManager) IsPaused(sessionID int) (bool, error) {
+	var count int
+	err := sm.db.QueryRow(
+		"SELECT COUNT(*) FROM session_pauses WHERE session_id = ? AND resumed_at IS NULL",
+		sessionID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func (sm *SessionManager) GetSessionPauses(sessionID int) ([]SessionPause, error) {
+	rows, err := sm.db.Query(
+		"SELECT id, session_id, paused_at, resumed_at, reason FROM session_pauses WHERE session_id = ? ORDER BY paused_at",
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pauses []SessionPause
+	for rows.Next() {
+		var p SessionPause
+		var resumedAt sql.NullTime
+		var reason sql.NullString
+		if err := rows.Scan(&p.ID, &p.SessionID, &p.PausedAt, &resumedAt, &reason); err != nil {
+			return nil, err
+		}
+		if resumedAt.Valid {
+			p.ResumedAt = resumedAt.Time
+		}
+		p.Reason = reason.String
+		pauses = append(pauses, p)
+	}
+
+	return pauses, rows.Err()
+}
+
+// pausedDuration returns how much of the interval [start, end] falls
+// inside pauses. Open pauses count as lasting until end.
+func pausedDuration(start, end time.Time, pauses []SessionPause) time.Duration {
+	var total time.Duration
+	for _, p := range pauses {
+		from, to := p.PausedAt, p.ResumedAt
+		if to.IsZero() || to.After(end) {
+			to = end
+		}
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(from) {
+			total += to.Sub(from)
+		}
+	}
+	return total
+}
+
+// activeDuration is the wall time between start and end minus pauses
+func activeDuration(start, end time.Time, pauses []SessionPause) time.Duration {
+	if end.Before(start) {
+		return 0
+	}
+	return end.Sub(start) - pausedDuration(start, end, pauses)
+}
+
+// describePauses summarizes pauses for reports, e.g. "2 (12m0s total)"
+func describePauses(start, end time.Time, pauses []SessionPause) string {
+	if len(pauses) == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d (%s total)", len(pauses), pausedDuration(start, end, pauses).Round(time.Second))
+}
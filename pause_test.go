@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActiveDuration(t *testing.T) {
+	start := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	tests := []struct {
+		name   string
+		pauses []SessionPause
+		want   time.Duration
+	}{
+		{"no pauses", nil, time.Hour},
+		{"two pauses", []SessionPause{{PausedAt: at(10), ResumedAt: at(20)}, {PausedAt: at(30), ResumedAt: at(35)}}, 45 * time.Minute},
+		{"still open at the end", []SessionPause{{PausedAt: at(50)}}, 50 * time.Minute},
+		{"resumed after the end", []SessionPause{{PausedAt: at(50), ResumedAt: at(70)}}, 50 * time.Minute},
+		{"paused before the start", []SessionPause{{PausedAt: at(-10), ResumedAt: at(5)}}, 55 * time.Minute},
+		{"outside the session", []SessionPause{{PausedAt: at(70), ResumedAt: at(80)}}, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := activeDuration(start, end, tt.pauses); got != tt.want {
+			t.Errorf("%s: active %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if got := activeDuration(end, start, nil); got != 0 {
+		t.Errorf("end before start: active %v, want 0", got)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	app := newTestApp(t, nil)
+	// Drive the capture loop's ticks by hand
+	session, _, err := app.beginSession(3600, "Ada", "Pause test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.ResumeSession(); err == nil {
+		t.Error("resumed a session that was not paused")
+	}
+
+	app.captureTick(session.ID)
+	if err := app.PauseSession("lunch"); err != nil {
+		t.Fatalf("PauseSession: %v", err)
+	}
+	if err := app.PauseSession("again"); err == nil {
+		t.Error("paused a session twice")
+	}
+
+	// Ticks while paused capture nothing
+	app.captureTick(session.ID)
+	app.captureTick(session.ID)
+
+	if err := app.ResumeSession(); err != nil {
+		t.Fatalf("ResumeSession: %v", err)
+	}
+	app.captureTick(session.ID)
+
+	// Stopping while paused closes the open pause
+	if err := app.PauseSession(""); err != nil {
+		t.Fatal(err)
+	}
+	app.drainPipeline()
+	if err := app.sessionManager.StopSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	screenshots, err := app.sessionManager.GetSessionScreenshots(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(screenshots) != 2 {
+		t.Errorf("%d screenshots, want one before the pause and one after", len(screenshots))
+	}
+	pauses, err := app.sessionManager.GetSessionPauses(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pauses) != 2 || pauses[0].Reason != "lunch" {
+		t.Fatalf("pauses = %+v, want lunch and one more", pauses)
+	}
+	for i, p := range pauses {
+		if p.ResumedAt.IsZero() {
+			t.Errorf("pause %d is still open after stopping", i)
+		}
+	}
+
+	stopped, err := app.sessionManager.GetSessionByID(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := app.durationLines(stopped)
+	for _, want := range []string{"Wall Time: ", "Pauses: 2 (", "(lunch)", "(no reason given)"} {
+		if !strings.Contains(lines, want) {
+			t.Errorf("duration lines missing %q:\n%s", want, lines)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+)
+
+// frameHash is a 256-bit difference hash (dHash) of a frame. Screens are
+// mostly flat areas with small text, so the larger 16x16 grid is used
+// instead of the classic 8x8 to stay sensitive to a few changed lines.
+type frameHash [4]uint64
+
+const hashGrid = 16
+
+// computeFrameHash shrinks img to a (hashGrid+1) x hashGrid grayscale grid
+// and sets one bit per horizontally adjacent pair that gets brighter.
+func computeFrameHash(img image.Image) frameHash {
+	small := resizeBox(img, hashGrid+1, hashGrid)
+
+	var hash frameHash
+	bit := 0
+	for y := 0; y < hashGrid; y++ {
+		for x := 0; x < hashGrid; x++ {
+			if luminance(small, x, y) < luminance(small, x+1, y) {
+				hash[bit/64] |= 1 << uint(bit%64)
+			}
+			bit++
+		}
+	}
+	return hash
+}
+
+func luminance(img *image.RGBA, x, y int) int {
+	i := y*img.Stride + x*4
+	return (299*int(img.Pix[i]) + 587*int(img.Pix[i+1]) + 114*int(img.Pix[i+2])) / 1000
+}
+
+// Distance returns the number of differing bits between two hashes
+func (h frameHash) Distance(other frameHash) int {
+	d := 0
+	for i := range h {
+		d += bits.OnesCount64(h[i] ^ other[i])
+	}
+	return d
+}
+
+func (h frameHash) String() string {
+	return fmt.Sprintf("%016x%016x%016x%016x", h[0], h[1], h[2], h[3])
+}
+
+// frameSignature is a tiny grayscale copy of a frame used to score how much
+// the screen changed between captures or probe frames.
+type frameSignature []uint8
+
+const (
+	signatureWidth  = 64
+	signatureHeight = 36
+)
+
+func computeFrameSignature(img image.Image) frameSignature {
+	small := resizeBox(img, signatureWidth, signatureHeight)
+	sig := make(frameSignature, 0, signatureWidth*signatureHeight)
+	for y := 0; y < signatureHeight; y++ {
+		for x := 0; x < signatureWidth; x++ {
+			sig = append(sig, uint8(luminance(small, x, y)))
+		}
+	}
+	return sig
+}
+
+// frameDifference returns the mean absolute difference between two
+// signatures, scaled to 0 (identical) .. 1 (inverted)
+func frameDifference(a, b frameSignature) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 1
+	}
+	total := 0
+	for i := range a {
+		d := int(a[i]) - int(b[i])
+		if d < 0 {
+			d = -d
+		}
+		total += d
+	}
+	return float64(total) / float64(len(a)*255)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// textScreen draws a white 1280x800 screen with lines of "text" as black
+// bars of varying length, plus an optional text cursor
+func textScreen(lines int, cursor bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 1280, 800))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	for i := 0; i < lines; i++ {
+		bar := image.Rect(40, 40+i*24, 340+(i*97)%600, 52+i*24)
+		draw.Draw(img, bar, image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+	if cursor {
+		draw.Draw(img, image.Rect(700, 400, 702, 416), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func TestFrameHashDistance(t *testing.T) {
+	base := computeFrameHash(textScreen(10, false))
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, textScreen(10, false), &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		maxDistance int
+		want        bool // Treated as unchanged
+	}{
+		{"identical", textScreen(10, false), 0, true},
+		{"JPEG artifacts", reencoded, 0, true},
+		{"cursor blink, exact", textScreen(10, true), 0, false},
+		{"cursor blink, default threshold", textScreen(10, true), 3, true},
+		{"three new lines", textScreen(13, false), 3, false},
+		{"new window", textScreen(30, false), 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := base.Distance(computeFrameHash(tt.img))
+			if got := d <= tt.maxDistance; got != tt.want {
+				t.Errorf("distance %d with max %d: unchanged = %v, want %v", d, tt.maxDistance, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrameHashString(t *testing.T) {
+	hash := frameHash{1, 0, 0, 0xff}
+	if got := hash.String(); got != "0000000000000001"+"0000000000000000"+"0000000000000000"+"00000000000000ff" {
+		t.Errorf("String() = %s", got)
+	}
+	if got := hash.Distance(frameHash{}); got != 9 {
+		t.Errorf("Distance from zero = %d, want 9", got)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type PipelineSettings struct {
+	QueueSize  int    `json:"queue_size"`  // Frames buffered between stages
+	DropPolicy string `json:"drop_policy"` // "drop_oldest" or "drop_newest" when a stage falls behind
+}
+
+const (
+	dropOldest = "drop_oldest"
+	dropNewest = "drop_newest"
+	dropNever  = "block" // Only used internally for the record queue
+)
+
+// CapturePipeline moves frames through bounded stages so the capture
+// goroutine only ever grabs pixels:
+//
+//	grab -> encode (single, in order) -> write to disk -> record in DB
+//	                                                   -> upload outbox
+//
+// When the encode or write queue is full the drop policy decides which
+// frame is discarded. Frames already on disk are never dropped before
+// they are recorded and queued for upload; the outbox sends them on its
+// own workers.
+type CapturePipeline struct {
+	capture *ScreenshotCapture
+	record  func(CapturedFrame) error
+	upload  func(frame CapturedFrame, globalSessionID string) error // Nil when uploads are off
+	failed  func(error)                                             // Told about every stage error; may be nil
+
+	encodeQueue *frameQueue
+	writeQueue  *frameQueue
+	recordQueue *frameQueue
+
+	lostMu sync.Mutex
+	lost   map[string]bool // Originals that never reached disk; their duplicates are skipped
+
+	wg            sync.WaitGroup
+	closeOnce     sync.Once
+	captured      int64
+	encoded       int64
+	written       int64
+	recorded      int64
+	queued        int64
+	stageFailures int64
+}
+
+// PipelineMetrics is a snapshot of pipeline throughput and backpressure
+type PipelineMetrics struct {
+	Captured      int64
+	Encoded       int64
+	Written       int64
+	Recorded      int64
+	Queued        int64 // Frames handed to the upload outbox
+	StageFailures int64
+	Queues        []QueueMetrics
+}
+
+type QueueMetrics struct {
+	Name      string
+	Depth     int
+	Capacity  int
+	HighWater int64 // Deepest the queue has been
+	Dropped   int64
+}
+
+func NewCapturePipeline(capture *ScreenshotCapture, settings PipelineSettings, record func(CapturedFrame) error,
+	upload func(frame CapturedFrame, globalSessionID string) error, failed func(error)) (*CapturePipeline, error) {
+	size := settings.QueueSize
+	if size <= 0 {
+		size = 8
+	}
+	policy := settings.DropPolicy
+	if policy == "" {
+		policy = dropOldest
+	}
+	if policy != dropOldest && policy != dropNewest {
+		return nil, fmt.Errorf("unknown drop policy %q", settings.DropPolicy)
+	}
+
+	p := &CapturePipeline{
+		capture: capture,
+		record:  record,
+		upload:  upload,
+		failed:  failed,
+		lost:    make(map[string]bool),
+	}
+	p.encodeQueue = newFrameQueue("encode", size, policy, p.dropped)
+	p.writeQueue = newFrameQueue("write", size, policy, p.dropped)
+	p.recordQueue = newFrameQueue("record", size, dropNever, nil)
+	return p, nil
+}
+
+// Start launches the stage goroutines
+func (p *CapturePipeline) Start() {
+	p.wg.Add(3)
+	go p.runEncoder()
+	go p.runWriter()
+	go p.runRecorder()
+}
+
+// Capture grabs one tick on the calling goroutine and queues its frames.
+// It returns as soon as the frames are queued.
+func (p *CapturePipeline) Capture(sessionID string) error {
+	frames, err := p.capture.Grab(sessionID)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		atomic.AddInt64(&p.captured, 1)
+		p.encodeQueue.push(frame)
+	}
+	return nil
+}
+
+// Close stops accepting frames and waits until every queued frame has
+// been written, recorded and queued for upload. Capture must not be called after.
+func (p *CapturePipeline) Close() {
+	p.closeOnce.Do(func() {
+		close(p.encodeQueue.frames)
+	})
+	p.wg.Wait()
+}
+
+func (p *CapturePipeline) runEncoder() {
+	defer p.wg.Done()
+	defer close(p.writeQueue.frames)
+
+	for frame := range p.encodeQueue.frames {
+		if err := p.capture.encodePending(frame); err != nil {
+			p.fail(err)
+			p.lose(frame)
+			continue
+		}
+		if !frame.Duplicate {
+			atomic.AddInt64(&p.encoded, 1)
+		}
+		p.writeQueue.push(frame)
+	}
+}
+
+func (p *CapturePipeline) runWriter() {
+	defer p.wg.Done()
+	defer close(p.recordQueue.frames)
+
+	for frame := range p.writeQueue.frames {
+		if err := p.capture.writePending(frame); err != nil {
+			p.fail(err)
+			p.lose(frame)
+			continue
+		}
+		if !frame.Duplicate {
+			atomic.AddInt64(&p.written, 1)
+		}
+		p.recordQueue.push(frame)
+	}
+}
+
+func (p *CapturePipeline) runRecorder() {
+	defer p.wg.Done()
+
+	for frame := range p.recordQueue.frames {
+		if p.orphaned(frame) {
+			fmt.Printf("Skipping duplicate of unsaved screenshot %s\n", filepath.Base(frame.FilePath))
+			continue
+		}
+		if err := p.record(frame.CapturedFrame); err != nil {
+			p.fail(fmt.Errorf("failed to record screenshot: %w", err))
+		} else {
+			atomic.AddInt64(&p.recorded, 1)
+		}
+
+		// The file is on disk, so the outbox can retry it for as long as needed
+		if p.upload == nil || frame.Duplicate {
+			continue
+		}
+		if err := p.upload(frame.CapturedFrame, frame.sessionID); err != nil {
+			p.fail(fmt.Errorf("failed to queue screenshot for upload: %w", err))
+			continue
+		}
+		atomic.AddInt64(&p.queued, 1)
+	}
+}
+
+// fail counts a stage error and reports it, so capture health reflects
+// frames lost after the grab as well as failed grabs
+func (p *CapturePipeline) fail(err error) {
+	fmt.Printf("Error: %v\n", err)
+	atomic.AddInt64(&p.stageFailures, 1)
+	if p.failed != nil {
+		p.failed(err)
+	}
+}
+
+// dropped is called for frames discarded before reaching disk
+func (p *CapturePipeline) dropped(frame *pendingFrame) {
+	if !frame.Duplicate {
+		p.lose(frame)
+	}
+}
+
+// lose handles an original that will never be on disk: later captures
+// must not deduplicate against it, and duplicates already queued have no
+// row to point at
+func (p *CapturePipeline) lose(frame *pendingFrame) {
+	p.capture.forgetFrame(frame.DisplayIndex, frame.FilePath)
+	p.lostMu.Lock()
+	p.lost[frame.FilePath] = true
+	p.lostMu.Unlock()
+}
+
+// orphaned reports whether frame is a duplicate of a lost original
+func (p *CapturePipeline) orphaned(frame *pendingFrame) bool {
+	if !frame.Duplicate {
+		return false
+	}
+	p.lostMu.Lock()
+	defer p.lostMu.Unlock()
+	return p.lost[frame.FilePath]
+}
+
+func (p *CapturePipeline) Metrics() PipelineMetrics {
+	m := PipelineMetrics{
+		Captured:      atomic.LoadInt64(&p.captured),
+		Encoded:       atomic.LoadInt64(&p.encoded),
+		Written:       atomic.LoadInt64(&p.written),
+		Recorded:      atomic.LoadInt64(&p.recorded),
+		Queued:        atomic.LoadInt64(&p.queued),
+		StageFailures: atomic.LoadInt64(&p.stageFailures),
+	}
+	for _, q := range []*frameQueue{p.encodeQueue, p.writeQueue, p.recordQueue} {
+		m.Queues = append(m.Queues, q.metrics())
+	}
+	return m
+}
+
+func (m PipelineMetrics) String() string {
+	s := fmt.Sprintf("Pipeline: %d captured, %d encoded, %d written, %d recorded",
+		m.Captured, m.Encoded, m.Written, m.Recorded)
+	if m.Queued > 0 {
+		s += fmt.Sprintf(", %d queued for upload", m.Queued)
+	}
+	if m.StageFailures > 0 {
+		s += fmt.Sprintf(", %d errors", m.StageFailures)
+	}
+
+	var queues []string
+	for _, q := range m.Queues {
+		queue := fmt.Sprintf("%s %d/%d peak %d", q.Name, q.Depth, q.Capacity, q.HighWater)
+		if q.Dropped > 0 {
+			queue += fmt.Sprintf(" dropped %d", q.Dropped)
+		}
+		queues = append(queues, queue)
+	}
+	return s + "\nQueues: " + strings.Join(queues, "; ")
+}
+
+// frameQueue is a bounded queue between two stages. Each queue has a
+// single producer, which is what makes drop_oldest safe without a lock.
+type frameQueue struct {
+	name      string
+	frames    chan *pendingFrame
+	policy    string
+	onDrop    func(*pendingFrame)
+	dropped   int64
+	highWater int64
+	warned    bool
+}
+
+func newFrameQueue(name string, size int, policy string, onDrop func(*pendingFrame)) *frameQueue {
+	return &frameQueue{
+		name:   name,
+		frames: make(chan *pendingFrame, size),
+		policy: policy,
+		onDrop: onDrop,
+	}
+}
+
+func (q *frameQueue) push(frame *pendingFrame) {
+	if q.policy == dropNever {
+		q.frames <- frame
+		q.observe()
+		return
+	}
+
+	for {
+		select {
+		case q.frames <- frame:
+			q.observe()
+			return
+		default:
+		}
+
+		// Queue is full: the stage after it is falling behind
+		if q.policy == dropNewest {
+			q.drop(frame)
+			return
+		}
+		select {
+		case oldest := <-q.frames:
+			q.drop(oldest)
+		default:
+			// The consumer made room in the meantime
+		}
+	}
+}
+
+func (q *frameQueue) observe() {
+	if depth := int64(len(q.frames)); depth > atomic.LoadInt64(&q.highWater) {
+		atomic.StoreInt64(&q.highWater, depth)
+	}
+}
+
+func (q *frameQueue) drop(frame *pendingFrame) {
+	atomic.AddInt64(&q.dropped, 1)
+	if !q.warned {
+		fmt.Printf("Warning: %s stage is falling behind, dropping frames (%s)\n", q.name, q.policy)
+		q.warned = true
+	}
+	if q.onDrop != nil {
+		q.onDrop(frame)
+	}
+}
+
+func (q *frameQueue) metrics() QueueMetrics {
+	return QueueMetrics{
+		Name:      q.name,
+		Depth:     len(q.frames),
+		Capacity:  cap(q.frames),
+		HighWater: atomic.LoadInt64(&q.highWater),
+		Dropped:   atomic.LoadInt64(&q.dropped),
+	}
+}
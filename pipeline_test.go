@@ -0,0 +1,59 @@
+package main
+
+import (
+	"image"
+	"os"
+	"testing"
+)
+
+// TestPipelineDropsDuplicatesOfDroppedOriginal fills a two-frame encode
+// queue with an original and its duplicate, then lets drop_oldest discard
+// the original
+func TestPipelineDropsDuplicatesOfDroppedOriginal(t *testing.T) {
+	sm := newTestSessionManager(t)
+	session, err := sm.StartSession("Pipeline", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := sm.GetSessionScreenshotDir(session.ID)
+	capture := NewScreenshotCapture(dir, ScreenshotSettings{Quality: 80, Dedup: true, DedupMaxDistance: 3})
+	capture.SetSource(&sequenceSource{images: []*image.RGBA{
+		textScreen(10, false),
+		textScreen(10, false), // Duplicate of the first
+		textScreen(30, false), // New content, pushing out the first
+	}})
+	if err := capture.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline, err := NewCapturePipeline(capture, PipelineSettings{QueueSize: 2, DropPolicy: dropOldest}, sm.RecordScreenshot, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is encoded until all three ticks are queued
+	for i := 0; i < 3; i++ {
+		if err := pipeline.Capture("s"); err != nil {
+			t.Fatalf("capture %d: %v", i, err)
+		}
+	}
+	pipeline.Start()
+	pipeline.Close()
+
+	metrics := pipeline.Metrics()
+	if metrics.StageFailures != 0 {
+		t.Errorf("%d stage failures, want none", metrics.StageFailures)
+	}
+	screenshots, err := sm.GetSessionScreenshots(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(screenshots) != 1 || screenshots[0].IsDuplicate() {
+		t.Fatalf("recorded %+v, want only the third frame", screenshots)
+	}
+	if screenshots[0].Tick != 3 {
+		t.Errorf("original is from tick %d, want 3", screenshots[0].Tick)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files written, want 1", len(files))
+	}
+}
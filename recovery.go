@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A running capture loop refreshes its session's last_activity_at at least
+// this often, besides every recorded screenshot
+const activityInterval = 30 * time.Second
+
+// interruptedAfter is how long a session can go without activity before it
+// is taken to belong to a process that crashed or was killed
+const interruptedAfter = 3 * activityInterval
+
+// pauseReasonInterrupted marks the gap between a crash and resuming capture
+const pauseReasonInterrupted = "interrupted"
+
+// lastActivity is when the session was last known to be capturing
+func (s *Session) lastActivity() time.Time {
+	if s.LastActivityAt.IsZero() {
+		return s.StartTime
+	}
+	return s.LastActivityAt
+}
+
+// Interrupted reports whether an active session has no process capturing
+// it any more
+func (s *Session) Interrupted(now time.Time) bool {
+	return s.Status == "active" && now.Sub(s.lastActivity()) > interruptedAfter
+}
+
+// captureInterval returns the base interval recorded with the session's
+// capture policy, or 0 when the policy has none
+func (s *Session) captureInterval() int {
+	var params struct {
+		IntervalSeconds float64 `json:"interval_seconds"`
+	}
+	if s.CaptureParams == "" || json.Unmarshal([]byte(s.CaptureParams), &params) != nil {
+		return 0
+	}
+	return int(params.IntervalSeconds)
+}
+
+func (sm *SessionManager) touchSession(sessionID int, at time.Time) error {
+	_, err := sm.db.Exec("UPDATE sessions SET last_activity_at = ? WHERE id = ?", at, sessionID)
+	return err
+}
+
+// closeInterruptedSession completes a session at its last activity rather
+// than now, so a crash doesn't stretch it to whenever it was found
+func (sm *SessionManager) closeInterruptedSession(session *Session) error {
+	endTime := session.lastActivity()
+	if _, err := sm.db.Exec(
+		"UPDATE sessions SET end_time = ?, status = ? WHERE id = ? AND status = 'active'",
+		endTime, "completed", session.ID,
+	); err != nil {
+		return err
+	}
+	if err := sm.closePause(session.ID, endTime, false); err != nil {
+		return err
+	}
+
+	session.EndTime = endTime
+	session.Status = "completed"
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if sm.currentSession != nil && sm.currentSession.ID == session.ID {
+		sm.currentSession = nil
+	}
+	return nil
+}
+
+// resumeInterruptedSession makes an interrupted session current again. The
+// time nothing was capturing is recorded as a pause, unless the session was
+// already paused when it was interrupted.
+func (sm *SessionManager) resumeInterruptedSession(session *Session) error {
+	now := time.Now()
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var open int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM session_pauses WHERE session_id = ? AND resumed_at IS NULL", session.ID).Scan(&open); err != nil {
+		return err
+	}
+	if open == 0 {
+		if _, err := tx.Exec(
+			"INSERT INTO session_pauses (session_id, paused_at, resumed_at, reason) VALUES (?, ?, ?, ?)",
+			session.ID, session.lastActivity(), now, pauseReasonInterrupted,
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE sessions SET last_activity_at = ? WHERE id = ?", now, session.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	session.LastActivityAt = now
+	sm.setCurrentSession(session)
+	return nil
+}
+
+// InterruptedSession returns the session a crashed process left active, or
+// nil when there is none
+func (app *App) InterruptedSession() (*Session, error) {
+	if app.running() {
+		return nil, nil
+	}
+	session, err := app.sessionManager.FindActiveSession()
+	if err != nil || session == nil {
+		return nil, err
+	}
+	if !session.Interrupted(time.Now()) {
+		return nil, nil
+	}
+	return session, nil
+}
+
+// CloseInterruptedSession ends any interrupted session at its last
+// activity and tells the upload sinks
+func (app *App) CloseInterruptedSession() error {
+	session, err := app.InterruptedSession()
+	if err != nil || session == nil {
+		return err
+	}
+	if err := app.sessionManager.closeInterruptedSession(session); err != nil {
+		return fmt.Errorf("failed to close interrupted session %d: %w", session.ID, err)
+	}
+	fmt.Printf("Closed interrupted session %d at its last activity (%s)\n",
+		session.ID, session.EndTime.Format("2006-01-02 15:04:05"))
+	app.reportStopped(session)
+	return nil
+}
+
+// ResumeInterruptedSession continues capture into the interrupted session
+// on a background goroutine, at the interval it was recorded with when
+// there is one
+func (app *App) ResumeInterruptedSession(intervalSeconds int) error {
+	session, err := app.InterruptedSession()
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return fmt.Errorf("no interrupted session to resume")
+	}
+	if interval := session.captureInterval(); interval > 0 {
+		intervalSeconds = interval
+	}
+
+	source, policy, pipeline, err := app.newCapture(intervalSeconds)
+	if err != nil {
+		return err
+	}
+	interruptedAt := session.lastActivity()
+	if err := app.sessionManager.resumeInterruptedSession(session); err != nil {
+		return fmt.Errorf("failed to resume session %d: %w", session.ID, err)
+	}
+	fmt.Printf("Resumed session ID: %d (nothing captured since %s)\n", session.ID, interruptedAt.Format("2006-01-02 15:04:05"))
+
+	if err := app.attachCapture(session, source, policy, pipeline); err != nil {
+		return err
+	}
+	go app.runCaptureLoop(session.ID, policy)
+	return nil
+}
+
+// keepSessionAlive refreshes last_activity_at every activityInterval until
+// the returned function is called, so paused sessions and long capture
+// intervals aren't mistaken for crashes
+func (app *App) keepSessionAlive(sessionID int) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(activityInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				if err := app.sessionManager.touchSession(sessionID, now); err != nil {
+					fmt.Printf("Warning: Failed to record session activity: %v\n", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
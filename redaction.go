@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+)
+
+// RedactionRule hides part of a display before the frame is hashed,
+// encoded, saved or uploaded
+type RedactionRule struct {
+	Name    string `json:"name"`
+	Display int    `json:"display"` // Display index the rule applies to, -1 for every display
+	Type    string `json:"type"`    // "rect" for a fixed area, "band" for an edge strip
+	X       int    `json:"x"`       // rect: position and size in display pixels
+	Y       int    `json:"y"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Edge    string `json:"edge"` // band: "top", "bottom", "left" or "right"
+	Size    int    `json:"size"` // band: thickness in pixels
+	Mode    string `json:"mode"` // "black" (default) or "blur" (coarse pixelation)
+}
+
+const redactionBlurBlock = 24
+
+// area returns the rectangle the rule covers on a display of the given
+// size, or an empty rectangle if the rule does not apply to the display.
+// A rule it can't interpret is an error rather than covering nothing.
+func (r RedactionRule) area(display int, bounds image.Rectangle) (image.Rectangle, error) {
+	var area image.Rectangle
+	switch strings.ToLower(r.Type) {
+	case "rect":
+		if r.Width <= 0 || r.Height <= 0 {
+			return image.Rectangle{}, fmt.Errorf("needs a positive width and height")
+		}
+		area = image.Rect(r.X, r.Y, r.X+r.Width, r.Y+r.Height).Add(bounds.Min)
+	case "band":
+		if r.Size <= 0 {
+			return image.Rectangle{}, fmt.Errorf("needs a positive size")
+		}
+		switch strings.ToLower(r.Edge) {
+		case "top":
+			area = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+r.Size)
+		case "bottom":
+			area = image.Rect(bounds.Min.X, bounds.Max.Y-r.Size, bounds.Max.X, bounds.Max.Y)
+		case "left":
+			area = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Min.X+r.Size, bounds.Max.Y)
+		case "right":
+			area = image.Rect(bounds.Max.X-r.Size, bounds.Min.Y, bounds.Max.X, bounds.Max.Y)
+		default:
+			return image.Rectangle{}, fmt.Errorf("has unknown edge %q (use top, bottom, left or right)", r.Edge)
+		}
+	default:
+		return image.Rectangle{}, fmt.Errorf("has unknown type %q (use rect or band)", r.Type)
+	}
+
+	if r.Display >= 0 && r.Display != display {
+		return image.Rectangle{}, nil
+	}
+	return area.Intersect(bounds), nil
+}
+
+// validate checks the rule can be applied, the same way area does
+func (r RedactionRule) validate(index int) error {
+	if _, err := r.area(r.Display, image.Rect(0, 0, 1, 1)); err != nil {
+		return fmt.Errorf("redaction %s %s", r.label(index), err)
+	}
+	return nil
+}
+
+func (r RedactionRule) label(index int) string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return fmt.Sprintf("rule_%d", index+1)
+}
+
+// applyRedactions modifies img in place and returns the names of the rules
+// that covered part of it. An invalid rule fails the frame, so it is never
+// saved without the redaction it was meant to have.
+func applyRedactions(img *image.RGBA, display int, rules []RedactionRule) ([]string, error) {
+	var applied []string
+	for i, rule := range rules {
+		area, err := rule.area(display, img.Bounds())
+		if err != nil {
+			return nil, fmt.Errorf("redaction %s %s", rule.label(i), err)
+		}
+		if area.Empty() {
+			continue
+		}
+
+		if strings.ToLower(rule.Mode) == "blur" {
+			pixelate(img, area, redactionBlurBlock)
+		} else {
+			draw.Draw(img, area, image.Black, image.Point{}, draw.Src)
+		}
+		applied = append(applied, rule.label(i))
+	}
+	return applied, nil
+}
+
+// pixelate replaces each block x block cell inside area with its average
+// color, which leaves layout visible but text unreadable
+func pixelate(img *image.RGBA, area image.Rectangle, block int) {
+	for by := area.Min.Y; by < area.Max.Y; by += block {
+		for bx := area.Min.X; bx < area.Max.X; bx += block {
+			cell := image.Rect(bx, by, bx+block, by+block).Intersect(area)
+
+			var r, g, b, n uint32
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					p := img.PixOffset(x, y)
+					r += uint32(img.Pix[p])
+					g += uint32(img.Pix[p+1])
+					b += uint32(img.Pix[p+2])
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					p := img.PixOffset(x, y)
+					img.Pix[p] = uint8(r / n)
+					img.Pix[p+1] = uint8(g / n)
+					img.Pix[p+2] = uint8(b / n)
+					img.Pix[p+3] = 255
+				}
+			}
+		}
+	}
+}
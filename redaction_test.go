@@ -0,0 +1,158 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestRedactionArea(t *testing.T) {
+	bounds := image.Rect(0, 0, 1920, 1080)
+	tests := []struct {
+		name    string
+		rule    RedactionRule
+		display int
+		want    image.Rectangle
+	}{
+		{"rect", RedactionRule{Type: "rect", X: 100, Y: 50, Width: 200, Height: 30, Display: -1}, 0, image.Rect(100, 50, 300, 80)},
+		{"rect clipped to the display", RedactionRule{Type: "rect", X: 1800, Y: 1000, Width: 400, Height: 400, Display: -1}, 0, image.Rect(1800, 1000, 1920, 1080)},
+		{"rect off screen", RedactionRule{Type: "rect", X: 2000, Y: 0, Width: 100, Height: 100, Display: -1}, 0, image.Rectangle{}},
+		{"top band", RedactionRule{Type: "band", Edge: "top", Size: 40, Display: -1}, 0, image.Rect(0, 0, 1920, 40)},
+		{"bottom band", RedactionRule{Type: "band", Edge: "Bottom", Size: 48, Display: -1}, 0, image.Rect(0, 1032, 1920, 1080)},
+		{"left band", RedactionRule{Type: "band", Edge: "left", Size: 60, Display: -1}, 0, image.Rect(0, 0, 60, 1080)},
+		{"right band", RedactionRule{Type: "band", Edge: "right", Size: 60, Display: -1}, 0, image.Rect(1860, 0, 1920, 1080)},
+		{"other display", RedactionRule{Type: "band", Edge: "top", Size: 40, Display: 1}, 0, image.Rectangle{}},
+		{"matching display", RedactionRule{Type: "band", Edge: "top", Size: 40, Display: 1}, 1, image.Rect(0, 0, 1920, 40)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rule.area(tt.display, bounds)
+			if err != nil {
+				t.Fatalf("area: %v", err)
+			}
+			if got != tt.want && !(got.Empty() && tt.want.Empty()) {
+				t.Errorf("area = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRedactions(t *testing.T) {
+	img := textScreen(30, false)
+	rules := []RedactionRule{
+		{Name: "clock", Type: "band", Edge: "top", Size: 30, Display: -1},
+		{Type: "rect", X: 40, Y: 100, Width: 480, Height: 96, Mode: "blur", Display: -1},
+		{Name: "second screen", Type: "band", Edge: "left", Size: 100, Display: 1},
+	}
+
+	applied, err := applyRedactions(img, 0, rules)
+	if err != nil {
+		t.Fatalf("applyRedactions: %v", err)
+	}
+	if got := strings.Join(applied, ","); got != "clock,rule_2" {
+		t.Errorf("applied %q, want clock,rule_2", got)
+	}
+
+	// The band is solid black
+	for _, p := range []image.Point{{0, 0}, {1279, 29}, {640, 15}} {
+		if got := img.RGBAAt(p.X, p.Y); got != (color.RGBA{0, 0, 0, 255}) {
+			t.Errorf("band pixel %v = %v, want black", p, got)
+		}
+	}
+	if got := img.RGBAAt(640, 30); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("pixel under the band = %v, want untouched", got)
+	}
+
+	// Blurred text becomes flat blocks with no black text pixels left
+	for by := 100; by < 196; by += redactionBlurBlock {
+		for bx := 40; bx < 520; bx += redactionBlurBlock {
+			first := img.RGBAAt(bx, by)
+			if first.R == 0 {
+				t.Fatalf("text still readable at (%d, %d)", bx, by)
+			}
+			for y := by; y < by+redactionBlurBlock && y < 196; y++ {
+				for x := bx; x < bx+redactionBlurBlock && x < 520; x++ {
+					if img.RGBAAt(x, y) != first {
+						t.Fatalf("block at (%d, %d) is not flat", bx, by)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestInvalidRedactionFailsFrame(t *testing.T) {
+	img := textScreen(30, false)
+	rules := []RedactionRule{
+		{Name: "clock", Type: "band", Edge: "top", Size: 30, Display: -1},
+		{Name: "chat", Type: "band", Edge: "middle", Size: 30, Display: 1},
+	}
+	// The broken rule fails even on a display it wouldn't cover
+	if applied, err := applyRedactions(img, 0, rules); err == nil || !strings.Contains(err.Error(), "chat") {
+		t.Errorf("applyRedactions = %v, %v; want an error naming chat", applied, err)
+	}
+}
+
+func TestRedactionsBeforeSaving(t *testing.T) {
+	source := twoDisplays()
+	source.colors = []color.RGBA{{255, 255, 255, 255}, {255, 255, 255, 255}}
+	capture := NewScreenshotCapture(t.TempDir(), ScreenshotSettings{Quality: 90, DisplayMode: "stitched"})
+	capture.SetSource(source)
+	capture.SetRedactions([]RedactionRule{
+		{Name: "laptop clock", Type: "band", Edge: "top", Size: 20, Display: 0},
+		{Name: "chat", Type: "rect", X: 0, Y: 200, Width: 160, Height: 40, Display: 1},
+	})
+	if err := capture.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := capture.CaptureScreenForSession("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(frames[0].Redactions, ","); got != "laptop clock,chat" {
+		t.Errorf("recorded redactions %q", got)
+	}
+
+	// Stitched canvas: the monitor is at x 0-160, the laptop at x 160-360
+	// and y 100-220
+	img := decodeJPEG(t, frames[0].FilePath)
+	tests := []struct {
+		x, y  int
+		black bool
+	}{
+		{260, 105, true},  // Laptop top band
+		{260, 130, false}, // Laptop below the band
+		{80, 220, true},   // Monitor chat area
+		{80, 150, false},  // Monitor above it
+	}
+	for _, tt := range tests {
+		r, _, _, _ := img.At(tt.x, tt.y).RGBA()
+		if black := r>>8 < 40; black != tt.black {
+			t.Errorf("pixel (%d, %d) black = %v, want %v", tt.x, tt.y, black, tt.black)
+		}
+	}
+}
+
+func TestValidateRedactions(t *testing.T) {
+	tests := []struct {
+		rule RedactionRule
+		ok   bool
+	}{
+		{RedactionRule{Type: "rect", Width: 10, Height: 10}, true},
+		{RedactionRule{Type: "rect", Width: 10}, false},
+		{RedactionRule{Type: "band", Edge: "top", Size: 10}, true},
+		{RedactionRule{Type: "band", Edge: "middle", Size: 10}, false},
+		{RedactionRule{Type: "band", Edge: "top"}, false},
+		{RedactionRule{Type: "circle"}, false},
+	}
+	base := *newTestApp(t, nil).config
+	for _, tt := range tests {
+		config := base
+		config.Redactions = []RedactionRule{tt.rule}
+		if err := config.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: Validate() = %v", tt.rule, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+)
+
+// UploadRenditionSettings describe the copy of each screenshot sent to the
+// webapp. The saved file keeps the archival settings from
+// screenshot_settings; zero values fall back to them.
+type UploadRenditionSettings struct {
+	MaxWidth  int `json:"max_width"`  // Downscale wider captures for upload (0 = as saved)
+	MaxHeight int `json:"max_height"` // Downscale taller captures for upload (0 = as saved)
+	Quality   int `json:"quality"`    // JPEG quality of the upload copy (0 = as saved)
+}
+
+// enabled reports whether uploads need their own copy rather than the
+// saved file
+func (r UploadRenditionSettings) enabled() bool {
+	return r.MaxWidth > 0 || r.MaxHeight > 0 || r.Quality > 0
+}
+
+// uploadRenditionPath returns where the upload copy of a screenshot file
+// lives: an "upload" folder next to it. Copies are removed once sent.
+func uploadRenditionPath(screenshotPath string) string {
+	return filepath.Join(filepath.Dir(screenshotPath), "upload", filepath.Base(screenshotPath))
+}
+
+// encodeUploadRendition returns the upload copy of img. quality is the
+// archival quality, used when the rendition doesn't set its own.
+func encodeUploadRendition(img image.Image, settings UploadRenditionSettings, quality int) ([]byte, error) {
+	if settings.Quality > 0 {
+		quality = settings.Quality
+	}
+	return encodeJPEG(scaleToFit(img, settings.MaxWidth, settings.MaxHeight), quality)
+}
+
+// writeUploadRendition saves an encoded upload copy, creating the upload
+// folder
+func writeUploadRendition(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type RetentionSettings struct {
+	ScreenshotDays int  `json:"screenshot_days"`  // Delete screenshots of sessions older than this, keeping summary and timelapse (0 = keep)
+	MaxDataDirMB   int  `json:"max_data_dir_mb"`  // Cap on data_dir; oldest sessions lose screenshots, then their folder (0 = no cap)
+	PruneAtStartup bool `json:"prune_at_startup"` // Apply the rules whenever capture starts
+}
+
+// What a prune removed from a session, as recorded in sessions.purge_scope
+const (
+	purgeScreenshots = "screenshots" // Screenshots, thumbnails and upload copies; summary and timelapse kept
+	purgeSession     = "session"     // The whole session folder; the database rows remain
+)
+
+// pruneAction is one session a prune empties
+type pruneAction struct {
+	Session Session
+	Scope   string
+	Reason  string
+	Bytes   int64
+}
+
+// PruneReport describes a prune, or what a dry run would do
+type PruneReport struct {
+	DryRun     bool
+	Actions    []pruneAction
+	Protected  []string // Sessions the rules would prune but may not, with why
+	Freed      int64
+	SizeBefore int64
+	SizeAfter  int64
+	Cap        int64
+}
+
+func (r PruneReport) String() string {
+	var b strings.Builder
+	verb := "Removed"
+	if r.DryRun {
+		verb = "Would remove"
+	}
+	for _, a := range r.Actions {
+		what := "screenshots"
+		if a.Scope == purgeSession {
+			what = "session folder"
+		}
+		fmt.Fprintf(&b, "%s %s of session %d (%s, %s): %s, %s\n", verb, what, a.Session.ID, a.Session.StudentName,
+			a.Session.StartTime.Format("2006-01-02"), formatMB(a.Bytes), a.Reason)
+	}
+	for _, p := range r.Protected {
+		fmt.Fprintf(&b, "Kept %s\n", p)
+	}
+	fmt.Fprintf(&b, "%s %s from %d session(s); data_dir %s -> %s", verb, formatMB(r.Freed), len(r.Actions),
+		formatMB(r.SizeBefore), formatMB(r.SizeAfter))
+	if r.Cap > 0 && r.SizeAfter > r.Cap {
+		fmt.Fprintf(&b, "\nWarning: still over the %s cap; the rest is active, unsynced or unanalyzed", formatMB(r.Cap))
+	}
+	return b.String()
+}
+
+func formatMB(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1<<20))
+}
+
+// prunable is a completed session with what pruning it would free
+type prunable struct {
+	session   Session
+	dir       string
+	rawBytes  int64  // Screenshots, thumbnails and upload copies still on disk
+	dirBytes  int64  // Everything in the session folder
+	protected string // Why it may not be pruned, if it may not
+}
+
+// getPrunableSessions returns completed sessions not yet removed
+// entirely, oldest first
+func (sm *SessionManager) getPrunableSessions() ([]Session, error) {
+	rows, err := sm.db.Query("SELECT "+sessionColumns+" FROM sessions WHERE status = 'completed' AND (purge_scope IS NULL OR purge_scope != ?) ORDER BY start_time",
+		purgeSession)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// unsyncedReason says why a session's uploads aren't finished, or "" when
+// they are. A session nothing was ever delivered for, by the outbox or
+// -sync, is unsynced whether or not sinks are configured now. Dead uploads
+// count as finished once -sync has sent the session's screenshots.
+func (sm *SessionManager) unsyncedReason(sessionID int) (string, error) {
+	var pending, dead, sent, synced, syncedScreenshots int
+	if err := sm.db.QueryRow(
+		"SELECT COALESCE(SUM(status = ?), 0), COALESCE(SUM(status = ?), 0), COALESCE(SUM(status = ?), 0) FROM upload_queue WHERE session_id = ?",
+		uploadPending, uploadDead, uploadDone, sessionID,
+	).Scan(&pending, &dead, &sent); err != nil {
+		return "", err
+	}
+	if err := sm.db.QueryRow(
+		"SELECT COUNT(*), COALESCE(SUM(kind = ?), 0) FROM sync_files WHERE session_id = ? AND status = ?",
+		syncScreenshot, sessionID, syncDone,
+	).Scan(&synced, &syncedScreenshots); err != nil {
+		return "", err
+	}
+
+	switch {
+	case pending > 0:
+		return fmt.Sprintf("%d upload(s) still queued", pending), nil
+	case dead > 0 && syncedScreenshots == 0:
+		return fmt.Sprintf("%d upload(s) failed; run -sync", dead), nil
+	case sent+synced == 0:
+		return "never uploaded; run -sync", nil
+	}
+	return "", nil
+}
+
+// rawFiles lists the screenshot and thumbnail files of a session's
+// unpurged screenshots, each once
+func rawFiles(screenshots []Screenshot) []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, s := range screenshots {
+		for _, path := range []string{s.FilePath, s.ThumbnailPath} {
+			if path != "" && !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// dirSize totals the files below dir, skipping anything unreadable
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+func (app *App) loadPrunable(session Session) (*prunable, error) {
+	sm := app.sessionManager
+	p := &prunable{session: session, dir: sm.GetSessionDir(session.ID)}
+	p.dirBytes = dirSize(p.dir)
+
+	screenshots, err := sm.GetSessionScreenshots(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range rawFiles(screenshots) {
+		p.rawBytes += fileSize(path)
+	}
+	p.rawBytes += dirSize(filepath.Join(p.dir, "upload"))
+
+	if _, err := os.Stat(filepath.Join(p.dir, "summary.txt")); os.IsNotExist(err) {
+		p.protected = "not analyzed yet"
+		return p, nil
+	}
+	p.protected, err = sm.unsyncedReason(session.ID)
+	return p, err
+}
+
+// planPrune works out what the retention rules remove: screenshots past
+// screenshot_days first, then, while data_dir is over its cap, the
+// screenshots and finally the folders of the oldest sessions. Sessions
+// that are active, unanalyzed or not fully uploaded are never touched.
+func (app *App) planPrune(now time.Time) (*PruneReport, error) {
+	settings := app.config.Retention
+	report := &PruneReport{
+		SizeBefore: dirSize(app.config.DataDir),
+		Cap:        int64(settings.MaxDataDirMB) << 20,
+	}
+	size := report.SizeBefore
+
+	sessions, err := app.sessionManager.getPrunableSessions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	var candidates []*prunable
+	for _, session := range sessions {
+		p, err := app.loadPrunable(session)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect session %d: %w", session.ID, err)
+		}
+		candidates = append(candidates, p)
+	}
+
+	planned := make(map[int]int) // Session ID to index in Actions
+	protected := make(map[int]bool)
+	plan := func(p *prunable, scope, reason string) {
+		if p.protected != "" {
+			if !protected[p.session.ID] {
+				protected[p.session.ID] = true
+				report.Protected = append(report.Protected, fmt.Sprintf("session %d (%s): %s", p.session.ID, reason, p.protected))
+			}
+			return
+		}
+		bytes := p.rawBytes
+		if scope == purgeSession {
+			bytes = p.dirBytes
+		}
+		if i, ok := planned[p.session.ID]; ok {
+			// Upgrading a screenshot purge frees the rest of the folder
+			action := &report.Actions[i]
+			size -= bytes - action.Bytes
+			action.Scope, action.Reason, action.Bytes = scope, reason, bytes
+			return
+		}
+		size -= bytes
+		planned[p.session.ID] = len(report.Actions)
+		report.Actions = append(report.Actions, pruneAction{Session: p.session, Scope: scope, Reason: reason, Bytes: bytes})
+	}
+
+	if settings.ScreenshotDays > 0 {
+		cutoff := now.AddDate(0, 0, -settings.ScreenshotDays)
+		for _, p := range candidates {
+			if p.session.StartTime.Before(cutoff) && p.rawBytes > 0 {
+				plan(p, purgeScreenshots, fmt.Sprintf("older than %d days", settings.ScreenshotDays))
+			}
+		}
+	}
+
+	if report.Cap > 0 {
+		for _, scope := range []string{purgeScreenshots, purgeSession} {
+			for _, p := range candidates {
+				if size <= report.Cap {
+					break
+				}
+				if i, ok := planned[p.session.ID]; ok && report.Actions[i].Scope == scope {
+					continue
+				}
+				if scope == purgeScreenshots && p.rawBytes == 0 {
+					continue
+				}
+				plan(p, scope, "data_dir over "+formatMB(report.Cap))
+			}
+		}
+	}
+
+	for _, action := range report.Actions {
+		report.Freed += action.Bytes
+	}
+	report.SizeAfter = report.SizeBefore - report.Freed
+	return report, nil
+}
+
+// Prune applies the retention rules, or with dryRun only reports what
+// they would remove
+func (app *App) Prune(dryRun bool) (*PruneReport, error) {
+	report, err := app.planPrune(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	for _, action := range report.Actions {
+		if err := app.purgeSession(action.Session, action.Scope); err != nil {
+			return report, fmt.Errorf("failed to prune session %d: %w", action.Session.ID, err)
+		}
+	}
+	report.SizeAfter = dirSize(app.config.DataDir)
+	return report, nil
+}
+
+// purgeSession deletes a session's files for scope and marks its rows
+// purged, so nothing points at files that are gone
+func (app *App) purgeSession(session Session, scope string) error {
+	sm := app.sessionManager
+	dir := sm.GetSessionDir(session.ID)
+
+	if scope == purgeSession {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	} else {
+		screenshots, err := sm.GetSessionScreenshots(session.ID)
+		if err != nil {
+			return err
+		}
+		for _, path := range rawFiles(screenshots) {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(dir, "upload")); err != nil {
+			return err
+		}
+		os.Remove(filepath.Join(dir, "thumbs")) // Only succeeds once empty
+	}
+
+	return sm.markPurged(session.ID, scope)
+}
+
+func (sm *SessionManager) markPurged(sessionID int, scope string) error {
+	now := time.Now()
+	tx, err := sm.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE screenshots SET purged_at = ? WHERE session_id = ? AND purged_at IS NULL", now, sessionID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE sessions SET purged_at = ?, purge_scope = ? WHERE id = ?", now, scope, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PruneAtStartup applies the retention rules when prune_at_startup is set
+// and there are rules to apply
+func (app *App) PruneAtStartup() {
+	settings := app.config.Retention
+	if !settings.PruneAtStartup || (settings.ScreenshotDays <= 0 && settings.MaxDataDirMB <= 0) {
+		return
+	}
+	report, err := app.Prune(false)
+	if err != nil {
+		fmt.Printf("Warning: Retention prune failed: %v\n", err)
+	}
+	if report != nil && (len(report.Actions) > 0 || (report.Cap > 0 && report.SizeAfter > report.Cap)) {
+		fmt.Println(report)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addOldSession records a completed, analyzed session from days ago with
+// one screenshot on disk, returning the session and the screenshot path
+func addOldSession(t *testing.T, app *App, days int) (*Session, string) {
+	t.Helper()
+	sm := app.sessionManager
+	session, err := sm.StartSession("Retention test", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := sm.GetSessionDir(session.ID)
+	shot := filepath.Join(dir, "screenshot.jpg")
+	writeTestFile(t, shot)
+	if err := sm.RecordScreenshot(CapturedFrame{FilePath: shot, Timestamp: session.StartTime}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "summary.txt"), []byte("Fractions"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.StopSession(); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().AddDate(0, 0, -days)
+	if _, err := sm.db.Exec("UPDATE sessions SET start_time = ? WHERE id = ?", start, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	return session, shot
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		// Marks the session's uploads; returns whether it may be pruned
+		uploads func(t *testing.T, app *App, session *Session, shot string) bool
+	}{
+		{
+			name: "no sinks, never uploaded",
+			uploads: func(t *testing.T, app *App, session *Session, shot string) bool {
+				return false
+			},
+		},
+		{
+			name: "no sinks, sent by -sync",
+			uploads: func(t *testing.T, app *App, session *Session, shot string) bool {
+				if err := app.sessionManager.recordSync(session.ID, syncItem{Kind: syncScreenshot, FilePath: shot}, nil); err != nil {
+					t.Fatal(err)
+				}
+				return true
+			},
+		},
+		{
+			name:     "upload still queued",
+			settings: map[string]interface{}{"webapp_url": "http://127.0.0.1:1"},
+			uploads: func(t *testing.T, app *App, session *Session, shot string) bool {
+				if err := app.outbox.Enqueue(session.ID, session.GlobalID(), CapturedFrame{FilePath: shot, Timestamp: time.Now()}); err != nil {
+					t.Fatal(err)
+				}
+				return false
+			},
+		},
+		{
+			name:     "uploaded by the outbox",
+			settings: map[string]interface{}{"webapp_url": "http://127.0.0.1:1"},
+			uploads: func(t *testing.T, app *App, session *Session, shot string) bool {
+				if err := app.outbox.Enqueue(session.ID, session.GlobalID(), CapturedFrame{FilePath: shot, Timestamp: time.Now()}); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := app.sessionManager.db.Exec("UPDATE upload_queue SET status = ? WHERE session_id = ?", uploadDone, session.ID); err != nil {
+					t.Fatal(err)
+				}
+				return true
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := map[string]interface{}{"retention": map[string]int{"screenshot_days": 30}}
+			for key, value := range tt.settings {
+				settings[key] = value
+			}
+			app := newTestApp(t, settings)
+			session, shot := addOldSession(t, app, 60)
+			prunable := tt.uploads(t, app, session, shot)
+
+			for _, dryRun := range []bool{true, false} {
+				report, err := app.Prune(dryRun)
+				if err != nil {
+					t.Fatalf("Prune(%v): %v", dryRun, err)
+				}
+				if prunable != (len(report.Actions) == 1) || prunable == (len(report.Protected) == 1) {
+					t.Fatalf("Prune(%v) = %s", dryRun, report)
+				}
+				if !prunable && !strings.Contains(report.Protected[0], "upload") {
+					t.Errorf("kept for %q, want an upload reason", report.Protected[0])
+				}
+
+				_, err = os.Stat(shot)
+				if removed := os.IsNotExist(err); removed != (prunable && !dryRun) {
+					t.Errorf("Prune(%v): screenshot removed = %v", dryRun, removed)
+				}
+			}
+
+			// The summary always stays
+			if _, err := os.Stat(filepath.Join(app.sessionManager.GetSessionDir(session.ID), "summary.txt")); err != nil {
+				t.Errorf("summary: %v", err)
+			}
+			var purged int
+			if err := app.sessionManager.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE purge_scope = ?", purgeScreenshots).Scan(&purged); err != nil {
+				t.Fatal(err)
+			}
+			if (purged == 1) != prunable {
+				t.Errorf("%d session(s) marked purged", purged)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store writes to an S3-compatible bucket (MinIO, a NAS's S3 service,
+// AWS) using path-style URLs and Signature Version 4
+type s3Store struct {
+	endpoint  string // Scheme and host, e.g. http://nas:9000
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+	limiter   *byteLimiter
+	now       func() time.Time
+}
+
+func newS3Store(s SinkSettings, client *http.Client, limiter *byteLimiter) *s3Store {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &s3Store{
+		endpoint:  strings.TrimRight(s.URL, "/"),
+		bucket:    s.Bucket,
+		prefix:    strings.Trim(s.Prefix, "/"),
+		region:    region,
+		accessKey: s.AccessKey,
+		secretKey: s.SecretKey,
+		client:    client,
+		limiter:   limiter,
+		now:       time.Now,
+	}
+}
+
+func (s *s3Store) put(key string, data []byte, contentType string) error {
+	req, err := http.NewRequest("PUT", s.location(key), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, data)
+	_, err = sendRequest(s.client, s.limiter, req)
+	return err
+}
+
+func (s *s3Store) get(key string) ([]byte, error) {
+	req, err := http.NewRequest("GET", s.location(key), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	s.sign(req, nil)
+	data, err := sendRequest(s.client, nil, req)
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) && uploadErr.status == http.StatusNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *s3Store) location(key string) string {
+	return s.endpoint + "/" + s3Escape(path.Join(s.bucket, s.prefix, key))
+}
+
+// sign adds SigV4 headers to req, covering the host and every header
+// already set on it
+func (s *s3Store) sign(req *http.Request, payload []byte) {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256.Sum256(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes a key the way SigV4 expects: everything but
+// unreserved characters and the slashes between segments
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ScheduleSettings struct {
+	Blocks              []ClassBlock `json:"blocks"`
+	ICSFile             string       `json:"ics_file"`              // Optional iCalendar timetable, merged with blocks
+	Interval            int          `json:"interval"`              // Capture interval in seconds for scheduled sessions
+	MinRemainingMinutes int          `json:"min_remaining_minutes"` // Join a block late only if this much of it is left
+	DefaultStudentName  string       `json:"default_student_name"`  // Used when a block names no student
+}
+
+// ClassBlock is one timetable entry. Weekly blocks set Day; one-off blocks
+// set Date instead.
+type ClassBlock struct {
+	Day         string `json:"day"`   // "monday" .. "sunday"
+	Date        string `json:"date"`  // "2006-01-02" for a single occurrence
+	Until       string `json:"until"` // Optional last date for weekly blocks
+	Start       string `json:"start"` // "15:04" local time
+	End         string `json:"end"`
+	StudentName string `json:"student_name"`
+	Description string `json:"description"`
+}
+
+// classOccurrence is a ClassBlock resolved to concrete times
+type classOccurrence struct {
+	Start       time.Time
+	End         time.Time
+	StudentName string
+	Description string
+}
+
+// Clock abstracts time so the scheduler can be driven by a fake clock
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SessionRunner is what the scheduler drives; App implements it
+type SessionRunner interface {
+	StartScheduledSession(intervalSeconds int, studentName, description string) error
+	StopScheduledSession() error
+}
+
+// Scheduler starts and stops sessions following a weekly timetable.
+//
+// Behavior for awkward timetables:
+//   - Blocks that ended while the machine was off are skipped.
+//   - A block already in progress at startup is joined late if at least
+//     MinRemainingMinutes of it are left, otherwise it is skipped.
+//   - Overlapping blocks never run concurrently: the earlier block runs to
+//     its end and the later one starts when it finishes, or is dropped if
+//     it is covered entirely.
+//   - If a session is already active when a block starts (for example one
+//     started by hand), the block is skipped rather than interrupting it.
+type Scheduler struct {
+	blocks       []ClassBlock
+	settings     ScheduleSettings
+	runner       SessionRunner
+	clock        Clock
+	maxSleep     time.Duration // Re-check the clock at least this often, to survive suspend
+	minRemaining time.Duration
+}
+
+func NewScheduler(blocks []ClassBlock, settings ScheduleSettings, runner SessionRunner, clock Clock) (*Scheduler, error) {
+	for i, block := range blocks {
+		if _, err := block.occurrenceOn(time.Now()); err != nil {
+			return nil, fmt.Errorf("invalid schedule block %d: %w", i+1, err)
+		}
+	}
+
+	if clock == nil {
+		clock = realClock{}
+	}
+	minRemaining := time.Duration(settings.MinRemainingMinutes) * time.Minute
+	if minRemaining <= 0 {
+		minRemaining = 5 * time.Minute
+	}
+
+	return &Scheduler{
+		blocks:       blocks,
+		settings:     settings,
+		runner:       runner,
+		clock:        clock,
+		maxSleep:     time.Minute,
+		minRemaining: minRemaining,
+	}, nil
+}
+
+// Run follows the timetable until stop is closed, stopping any session it
+// started before returning
+func (s *Scheduler) Run(stop <-chan struct{}) error {
+	if len(s.blocks) == 0 {
+		return fmt.Errorf("schedule has no class blocks")
+	}
+
+	for {
+		now := s.clock.Now()
+		occurrence, ok := s.nextOccurrence(now)
+		if !ok {
+			return fmt.Errorf("schedule has no upcoming class blocks")
+		}
+
+		if now.Before(occurrence.Start) {
+			fmt.Printf("Next class: %s for %s, %s - %s\n", occurrence.Description, occurrence.StudentName,
+				occurrence.Start.Format("Mon 2006-01-02 15:04"), occurrence.End.Format("15:04"))
+			if !s.sleepUntil(occurrence.Start, stop) {
+				return nil
+			}
+			continue
+		}
+
+		// Block in progress: join it unless too little is left
+		if occurrence.End.Sub(now) < s.minRemaining && now.After(occurrence.Start.Add(time.Minute)) {
+			fmt.Printf("Skipping %s: only %s left\n", occurrence.Description, occurrence.End.Sub(now).Round(time.Second))
+			if !s.sleepUntil(occurrence.End, stop) {
+				return nil
+			}
+			continue
+		}
+
+		if err := s.runOccurrence(occurrence, stop); err != nil {
+			return err
+		}
+
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+	}
+}
+
+func (s *Scheduler) runOccurrence(occurrence classOccurrence, stop <-chan struct{}) error {
+	interval := s.settings.Interval
+	if interval <= 0 {
+		interval = 30
+	}
+
+	fmt.Printf("Starting scheduled session: %s for %s until %s\n",
+		occurrence.Description, occurrence.StudentName, occurrence.End.Format("15:04"))
+	if err := s.runner.StartScheduledSession(interval, occurrence.StudentName, occurrence.Description); err != nil {
+		// Most likely a session started by hand is still running; leave it alone
+		fmt.Printf("Skipping scheduled session: %v\n", err)
+		s.sleepUntil(occurrence.End, stop)
+		return nil
+	}
+
+	s.sleepUntil(occurrence.End, stop)
+
+	fmt.Printf("Scheduled session ended: %s\n", occurrence.Description)
+	if err := s.runner.StopScheduledSession(); err != nil {
+		fmt.Printf("Error stopping scheduled session: %v\n", err)
+	}
+	return nil
+}
+
+// sleepUntil waits for t in bounded steps, returning false if stop closed
+func (s *Scheduler) sleepUntil(t time.Time, stop <-chan struct{}) bool {
+	for {
+		wait := t.Sub(s.clock.Now())
+		if wait <= 0 {
+			return true
+		}
+		if wait > s.maxSleep {
+			wait = s.maxSleep
+		}
+		select {
+		case <-s.clock.After(wait):
+		case <-stop:
+			return false
+		}
+	}
+}
+
+// nextOccurrence returns the block in progress at now, or the next one to
+// start, after overlapping blocks have been resolved
+func (s *Scheduler) nextOccurrence(now time.Time) (classOccurrence, bool) {
+	for _, occurrence := range s.occurrences(now.AddDate(0, 0, -1), now.AddDate(0, 0, 8)) {
+		if occurrence.End.After(now) {
+			return occurrence, true
+		}
+	}
+	return classOccurrence{}, false
+}
+
+// occurrences lists every block occurrence starting between from and to,
+// sorted, with later overlapping blocks pushed back to the earlier block's
+// end or dropped when fully covered
+func (s *Scheduler) occurrences(from, to time.Time) []classOccurrence {
+	var all []classOccurrence
+	for day := dateOf(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		for _, block := range s.blocks {
+			occurrence, err := block.occurrenceOn(day)
+			if err != nil || occurrence == nil {
+				continue
+			}
+			if occurrence.StudentName == "" {
+				occurrence.StudentName = s.settings.DefaultStudentName
+			}
+			if occurrence.StudentName == "" {
+				occurrence.StudentName = "Student"
+			}
+			if occurrence.Description == "" {
+				occurrence.Description = "Scheduled class"
+			}
+			all = append(all, *occurrence)
+		}
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Start.Before(all[j].Start)
+	})
+
+	var resolved []classOccurrence
+	for _, occurrence := range all {
+		if n := len(resolved); n > 0 && occurrence.Start.Before(resolved[n-1].End) {
+			occurrence.Start = resolved[n-1].End
+			if !occurrence.Start.Before(occurrence.End) {
+				continue
+			}
+		}
+		resolved = append(resolved, occurrence)
+	}
+	return resolved
+}
+
+// occurrenceOn resolves the block on the given day, returning nil if the
+// block does not take place that day
+func (b ClassBlock) occurrenceOn(day time.Time) (*classOccurrence, error) {
+	start, err := time.Parse("15:04", b.Start)
+	if err != nil {
+		return nil, fmt.Errorf("invalid start time %q", b.Start)
+	}
+	end, err := time.Parse("15:04", b.End)
+	if err != nil {
+		return nil, fmt.Errorf("invalid end time %q", b.End)
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("block ends before it starts (%s - %s)", b.Start, b.End)
+	}
+
+	day = dateOf(day)
+	if b.Date != "" {
+		date, err := time.ParseInLocation("2006-01-02", b.Date, day.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", b.Date)
+		}
+		if !date.Equal(day) {
+			return nil, nil
+		}
+	} else {
+		weekday, ok := parseWeekday(b.Day)
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", b.Day)
+		}
+		if day.Weekday() != weekday {
+			return nil, nil
+		}
+		if b.Until != "" {
+			until, err := time.ParseInLocation("2006-01-02", b.Until, day.Location())
+			if err != nil {
+				return nil, fmt.Errorf("invalid until date %q", b.Until)
+			}
+			if day.After(until) {
+				return nil, nil
+			}
+		}
+	}
+
+	at := func(t time.Time) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, day.Location())
+	}
+	return &classOccurrence{
+		Start:       at(start),
+		End:         at(end),
+		StudentName: b.StudentName,
+		Description: b.Description,
+	}, nil
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(strings.TrimSpace(day))
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if day == name || (len(day) >= 2 && strings.HasPrefix(name, day)) {
+			return d, true
+		}
+	}
+	return 0, false
+}
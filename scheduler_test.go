@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock jumps straight to the end of every wait. Once it reaches
+// limit it closes stop and stops firing, so Run returns.
+type fakeClock struct {
+	now   time.Time
+	limit time.Time
+	stop  chan struct{}
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	if !c.now.Before(c.limit) {
+		return nil
+	}
+	c.now = c.now.Add(d)
+	fired := make(chan time.Time, 1)
+	if !c.now.Before(c.limit) {
+		close(c.stop)
+		return nil
+	}
+	fired <- c.now
+	return fired
+}
+
+// fakeRunner records what the scheduler asked for and when
+type fakeRunner struct {
+	clock  *fakeClock
+	busy   bool // A session started by hand is running
+	events []string
+}
+
+func (r *fakeRunner) StartScheduledSession(intervalSeconds int, studentName, description string) error {
+	if r.busy {
+		r.events = append(r.events, r.clock.now.Format("15:04")+" busy "+description)
+		return fmt.Errorf("a session is already active")
+	}
+	r.events = append(r.events, r.clock.now.Format("15:04")+" start "+description+" for "+studentName)
+	return nil
+}
+
+func (r *fakeRunner) StopScheduledSession() error {
+	r.events = append(r.events, r.clock.now.Format("15:04")+" stop")
+	return nil
+}
+
+func TestSchedulerRun(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC) // A Monday
+	block := func(date time.Time, start, end, description string) ClassBlock {
+		return ClassBlock{Date: date.Format("2006-01-02"), Start: start, End: end, Description: description, StudentName: "Ada"}
+	}
+
+	tests := []struct {
+		name   string
+		now    string // On day
+		blocks []ClassBlock
+		busy   bool
+		want   []string
+	}{
+		{
+			name:   "block starts while idle",
+			now:    "08:00",
+			blocks: []ClassBlock{block(day, "09:00", "10:00", "Maths")},
+			want:   []string{"09:00 start Maths for Ada", "10:00 stop"},
+		},
+		{
+			name:   "joins a running block",
+			now:    "09:20",
+			blocks: []ClassBlock{block(day, "09:00", "10:00", "Maths")},
+			want:   []string{"09:20 start Maths for Ada", "10:00 stop"},
+		},
+		{
+			name: "skips a block with too little left",
+			now:  "09:57",
+			blocks: []ClassBlock{
+				block(day, "09:00", "10:00", "Maths"),
+				block(day, "11:00", "12:00", "Physics"),
+			},
+			want: []string{"11:00 start Physics for Ada", "12:00 stop"},
+		},
+		{
+			name: "overlapping blocks run one after the other",
+			now:  "08:00",
+			blocks: []ClassBlock{
+				block(day, "09:00", "10:00", "Maths"),
+				block(day, "09:30", "11:00", "Physics"),
+				block(day, "09:15", "09:45", "Covered"),
+			},
+			want: []string{
+				"09:00 start Maths for Ada", "10:00 stop",
+				"10:00 start Physics for Ada", "11:00 stop",
+			},
+		},
+		{
+			name: "block missed while the machine was off",
+			now:  "13:00",
+			blocks: []ClassBlock{
+				block(day, "09:00", "10:00", "Maths"),
+				block(day.AddDate(0, 0, 1), "09:00", "10:00", "Physics"),
+			},
+			want: []string{"09:00 start Physics for Ada", "10:00 stop"},
+		},
+		{
+			name:   "session already running",
+			now:    "08:00",
+			blocks: []ClassBlock{block(day, "09:00", "10:00", "Maths")},
+			busy:   true,
+			want:   []string{"09:00 busy Maths"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, err := time.Parse("15:04", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := day.Add(time.Duration(start.Hour())*time.Hour + time.Duration(start.Minute())*time.Minute)
+			clock := &fakeClock{now: now, limit: day.AddDate(0, 0, 2), stop: make(chan struct{})}
+			runner := &fakeRunner{clock: clock, busy: tt.busy}
+
+			scheduler, err := NewScheduler(tt.blocks, ScheduleSettings{MinRemainingMinutes: 5}, runner, clock)
+			if err != nil {
+				t.Fatalf("NewScheduler: %v", err)
+			}
+			if err := scheduler.Run(clock.stop); err != nil && !strings.Contains(err.Error(), "no upcoming") {
+				t.Fatalf("Run: %v", err)
+			}
+
+			if got := strings.Join(runner.events, "\n"); got != strings.Join(tt.want, "\n") {
+				t.Errorf("events =\n%s\nwant\n%s", got, strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestSchedulerStopsRunningSession(t *testing.T) {
+	day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: day.Add(8 * time.Hour), limit: day.Add(9*time.Hour + 30*time.Minute), stop: make(chan struct{})}
+	runner := &fakeRunner{clock: clock}
+	blocks := []ClassBlock{{Day: "monday", Start: "09:00", End: "10:00", Description: "Maths"}}
+
+	scheduler, err := NewScheduler(blocks, ScheduleSettings{DefaultStudentName: "Class 3B"}, runner, clock)
+	if err != nil {
+		t.Fatalf("NewScheduler: %v", err)
+	}
+	if err := scheduler.Run(clock.stop); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	want := []string{"09:00 start Maths for Class 3B", "09:30 stop"}
+	if got := strings.Join(runner.events, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("events =\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+}
+
+func TestLoadICSBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timetable.ics")
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"SUMMARY:Maths\\, set 2",
+		"DTSTART:20240304T090000",
+		"DTEND:20240304T100000",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,TH;UNTIL=20240628T235959Z",
+		"X-STUDENT-NAME:Ada",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Exam revision for the",
+		"  end of term",
+		"DTSTART:20240607T131500",
+		"DTEND:20240607T143000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Assembly",
+		"DTSTART:20240304T083000",
+		"DTEND:20240304T084500",
+		"RRULE:FREQ=DAILY",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	if err := os.WriteFile(path, []byte(ics), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, err := LoadICSBlocks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, b := range blocks {
+		got = append(got, fmt.Sprintf("%s%s %s-%s until %q: %s (%s)", b.Day, b.Date, b.Start, b.End, b.Until, b.Description, b.StudentName))
+	}
+	want := []string{
+		`monday 09:00-10:00 until "2024-06-28": Maths, set 2 (Ada)`,
+		`thursday 09:00-10:00 until "2024-06-28": Maths, set 2 (Ada)`,
+		`2024-06-07 13:15-14:30 until "": Exam revision for the end of term ()`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("blocks =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
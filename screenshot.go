@@ -1,172 +1,567 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
 	"image"
-	"image/jpeg"
-	"io"
-	"mime/multipart"
-	"net/http"
+	"image/draw"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/kbinani/screenshot"
 )
 
 type ScreenshotCapture struct {
-	outputDir string
-	quality   int
-	webappURL string
+	outputDir   string
+	quality     int
+	source      CaptureSource
+	displayMode string
+	settings    ScreenshotSettings
+	tick        int
+	lastFrames  map[int]hashedFrame // Last saved frame per display, for dedup
+	framesMu    sync.Mutex          // Guards lastFrames; pipeline stages may forget frames
+
+	lastStamp string        // Filename timestamp of the last capture
+	probed    *displayImage // Primary display grabbed by the last Probe, reused by the next Grab
+
+	lastSignature   frameSignature // Primary display at the last capture
+	lastChangeScore float64        // Difference between the last two captures
+
+	windows         WindowInspector // Optional foreground window lookup
+	windowErrLogged bool
+
+	redactions []RedactionRule
+	thumbnails ThumbnailSettings
+	upload     UploadRenditionSettings
+}
+
+type hashedFrame struct {
+	hash          frameHash
+	filePath      string
+	thumbnailPath string
 }
 
-func NewScreenshotCapture(outputDir string, webappURL string) *ScreenshotCapture {
+// CapturedFrame is one saved image from a capture tick. In "all" display
+// mode a single tick produces one frame per display, all sharing the same
+// tick number and timestamp.
+type CapturedFrame struct {
+	FilePath      string
+	DisplayIndex  int // -1 for a stitched canvas of every display
+	Tick          int
+	Timestamp     time.Time
+	Quality       int // JPEG quality the frame was finally encoded at
+	Width         int
+	Height        int
+	Hash          string // Perceptual hash of the captured image
+	Duplicate     bool   // Unchanged from the previous frame; FilePath is the earlier file
+	Window        WindowInfo
+	Redactions    []string // Names of the redaction rules applied
+	ThumbnailPath string
+	UploadPath    string // Smaller copy to upload instead of FilePath, if an upload rendition is set
+}
+
+// encodedFrame is a JPEG ready to be written and uploaded
+type encodedFrame struct {
+	data    []byte
+	quality int
+	width   int
+	height  int
+}
+
+func NewScreenshotCapture(outputDir string, settings ScreenshotSettings) *ScreenshotCapture {
 	return &ScreenshotCapture{
-		outputDir: outputDir,
-		quality:   80, // JPEG quality
-		webappURL: webappURL,
+		outputDir:   outputDir,
+		quality:     settings.Quality,
+		source:      &screenSource{},
+		displayMode: settings.DisplayMode,
+		settings:    settings,
 	}
 }
 
+// SetSource replaces the backend frames are captured from
+func (sc *ScreenshotCapture) SetSource(source CaptureSource) {
+	sc.source = source
+}
+
 func (sc *ScreenshotCapture) Initialize() error {
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(sc.outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
+
+	// Tick numbers and dedup state restart for every session
+	sc.tick = 0
+	sc.framesMu.Lock()
+	sc.lastFrames = make(map[int]hashedFrame)
+	sc.framesMu.Unlock()
+	sc.lastSignature = nil
+	sc.lastChangeScore = 0
+	sc.lastStamp = ""
+	sc.probed = nil
 	return nil
 }
 
-func (sc *ScreenshotCapture) CaptureScreen() (string, error) {
+// SetRedactions sets the rules applied to every captured display before
+// anything is hashed, written or uploaded
+func (sc *ScreenshotCapture) SetRedactions(rules []RedactionRule) {
+	sc.redactions = rules
+}
+
+// SetThumbnailSettings enables writing a thumbnail next to every saved
+// screenshot; a zero width disables thumbnails
+func (sc *ScreenshotCapture) SetThumbnailSettings(settings ThumbnailSettings) {
+	sc.thumbnails = settings
+}
+
+// SetUploadRendition makes every saved screenshot also get a copy encoded
+// for upload; settings with nothing set disable the copy
+func (sc *ScreenshotCapture) SetUploadRendition(settings UploadRenditionSettings) {
+	sc.upload = settings
+}
+
+// SetWindowInspector enables recording the foreground window with each
+// capture
+func (sc *ScreenshotCapture) SetWindowInspector(windows WindowInspector) {
+	sc.windows = windows
+}
+
+// activeWindow returns the foreground window, or an empty WindowInfo when
+// it can't be determined. The first failure is logged, later ones are not.
+func (sc *ScreenshotCapture) activeWindow() WindowInfo {
+	if sc.windows == nil {
+		return WindowInfo{}
+	}
+	info, err := sc.windows.ActiveWindow()
+	if err != nil {
+		if !sc.windowErrLogged {
+			fmt.Printf("Foreground window info unavailable: %v\n", err)
+			sc.windowErrLogged = true
+		}
+		return WindowInfo{}
+	}
+	sc.windowErrLogged = false
+	return *info
+}
+
+// LastChangeScore returns how much the primary display changed (0-1)
+// between the two most recent captures
+func (sc *ScreenshotCapture) LastChangeScore() float64 {
+	return sc.lastChangeScore
+}
+
+// Probe grabs the primary display without saving anything and returns
+// how much it differs (0-1) from the last capture. Sources that can peek
+// (replay, synthetic) are left where they were. Others have no frame to
+// spare, so the probe frame is kept for the next Grab: a capture the probe
+// triggers saves what was scored instead of asking for another.
+func (sc *ScreenshotCapture) Probe() (float64, error) {
+	var img *image.RGBA
+	var err error
+	peeker, peeks := sc.source.(framePeeker)
+	if peeks {
+		img, err = peeker.Peek(0)
+	} else {
+		img, err = sc.source.Capture(0)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to capture probe frame: %w", err)
+	}
+	applied, err := applyRedactions(img, 0, sc.redactions)
+	if err != nil {
+		return 0, err
+	}
+	if !peeks {
+		sc.probed = &displayImage{display: 0, img: img, redactions: applied}
+	}
+	if sc.lastSignature == nil {
+		return 1, nil
+	}
+	return frameDifference(sc.lastSignature, computeFrameSignature(img)), nil
+}
+
+// DiscardProbe drops the frame kept by Probe, for when the next capture
+// comes later and should grab a fresh one
+func (sc *ScreenshotCapture) DiscardProbe() {
+	sc.probed = nil
+}
+
+func (sc *ScreenshotCapture) CaptureScreen() ([]CapturedFrame, error) {
 	return sc.CaptureScreenForSession("default")
 }
 
-func (sc *ScreenshotCapture) CaptureScreenForSession(sessionID string) (string, error) {
+// CaptureScreenForSession captures, encodes and saves one tick inline on
+// the calling goroutine without uploading it. Sessions use CapturePipeline
+// instead so slow stages never delay the next tick.
+func (sc *ScreenshotCapture) CaptureScreenForSession(sessionID string) ([]CapturedFrame, error) {
+	pending, err := sc.Grab(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []CapturedFrame
+	for _, p := range pending {
+		p.UploadPath = "" // Nothing is uploaded from here
+		if err := sc.encodePending(p); err != nil {
+			return frames, err
+		}
+		if err := sc.writePending(p); err != nil {
+			return frames, err
+		}
+		frames = append(frames, p.CapturedFrame)
+	}
+	return frames, nil
+}
+
+// pendingFrame is a frame moving through the capture stages. The image is
+// released once encoded.
+type pendingFrame struct {
+	CapturedFrame
+	sessionID  string
+	filename   string
+	img        image.Image
+	data       []byte // Encoded JPEG
+	thumbData  []byte // Encoded thumbnail, if thumbnails are enabled
+	uploadData []byte // Encoded upload copy, if an upload rendition is set
+}
+
+// Grab captures the displays for one tick and decides which frames are
+// duplicates, without encoding or writing anything. The tick number and
+// timestamp are fixed here, when the frame is grabbed.
+func (sc *ScreenshotCapture) Grab(sessionID string) ([]*pendingFrame, error) {
 	// Get the number of displays
-	n := screenshot.NumActiveDisplays()
+	n := sc.source.NumDisplays()
 	if n == 0 {
-		return "", fmt.Errorf("no active displays found")
+		return nil, fmt.Errorf("no active displays found")
 	}
 
-	// Capture the primary display (display 0)
-	bounds := screenshot.GetDisplayBounds(0)
-	img, err := screenshot.CaptureRect(bounds)
+	capturedAt := time.Now()
+	sc.tick++
+
+	images, err := sc.captureDisplays(n)
 	if err != nil {
-		return "", fmt.Errorf("failed to capture screen: %w", err)
+		return nil, err
 	}
 
-	// Generate filename with timestamp
-	timestamp := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("screenshot_%s.jpg", timestamp)
-	filepath := filepath.Join(sc.outputDir, filename)
+	// Score the change since the last capture for capture policies
+	signature := computeFrameSignature(images[0].img)
+	if sc.lastSignature != nil {
+		sc.lastChangeScore = frameDifference(sc.lastSignature, signature)
+	}
+	sc.lastSignature = signature
 
-	// Save the image locally
-	if err := sc.saveImage(img, filepath); err != nil {
-		return "", fmt.Errorf("failed to save screenshot: %w", err)
+	window := sc.activeWindow()
+
+	// Generate filename with timestamp. Captures triggered together (a
+	// scheduled one and capture-now, say) can share a millisecond, so the
+	// tick keeps them from overwriting each other's file.
+	timestamp := strings.Replace(capturedAt.Format("20060102_150405.000"), ".", "_", 1)
+	if timestamp == sc.lastStamp {
+		timestamp = fmt.Sprintf("%s_%d", timestamp, sc.tick)
+	} else {
+		sc.lastStamp = timestamp
 	}
 
-	// Send to webapp if URL is configured
-	if sc.webappURL != "" {
-		go sc.sendToWebapp(img, sessionID, timestamp, filename)
+	var frames []*pendingFrame
+	for _, captured := range images {
+		filename := fmt.Sprintf("screenshot_%s.jpg", timestamp)
+		if sc.displayMode == "all" {
+			filename = fmt.Sprintf("screenshot_%s_d%d.jpg", timestamp, captured.display)
+		}
+		filePath := filepath.Join(sc.outputDir, filename)
+
+		frame := &pendingFrame{
+			CapturedFrame: CapturedFrame{
+				FilePath:     filePath,
+				DisplayIndex: captured.display,
+				Tick:         sc.tick,
+				Timestamp:    capturedAt,
+				Window:       window,
+				Redactions:   captured.redactions,
+			},
+			sessionID: sessionID,
+			filename:  filename,
+			img:       captured.img,
+		}
+
+		// Frames that look the same as the display's last one reuse its file
+		hash := computeFrameHash(captured.img)
+		frame.Hash = hash.String()
+		if original, ok := sc.findDuplicate(captured.display, hash); ok {
+			frame.FilePath = original.filePath
+			frame.ThumbnailPath = original.thumbnailPath
+			frame.Duplicate = true
+			frame.img = nil
+			frames = append(frames, frame)
+			continue
+		}
+
+		if sc.thumbnails.Width > 0 {
+			frame.ThumbnailPath = thumbnailPath(filePath)
+		}
+		if sc.upload.enabled() {
+			frame.UploadPath = uploadRenditionPath(filePath)
+		}
+		sc.rememberFrame(captured.display, hashedFrame{hash: hash, filePath: filePath, thumbnailPath: frame.ThumbnailPath})
+		frames = append(frames, frame)
 	}
 
-	return filepath, nil
+	return frames, nil
 }
 
-func (sc *ScreenshotCapture) saveImage(img image.Image, filepath string) error {
-	file, err := os.Create(filepath)
+// encodePending encodes the frame and its thumbnail. Duplicates have
+// nothing to encode.
+func (sc *ScreenshotCapture) encodePending(p *pendingFrame) error {
+	if p.Duplicate {
+		return nil
+	}
+
+	encoded, err := sc.encodeFrame(p.img)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to encode screenshot: %w", err)
 	}
-	defer file.Close()
+	p.data = encoded.data
+	p.Quality = encoded.quality
+	p.Width = encoded.width
+	p.Height = encoded.height
 
-	// Encode as JPEG with specified quality
-	options := &jpeg.Options{Quality: sc.quality}
-	return jpeg.Encode(file, img, options)
+	if p.ThumbnailPath != "" {
+		if p.thumbData, err = encodeThumbnail(p.img, sc.thumbnails); err != nil {
+			fmt.Printf("Warning: Failed to encode thumbnail: %v\n", err)
+			p.ThumbnailPath = ""
+		}
+	}
+
+	if p.UploadPath != "" {
+		if p.uploadData, err = encodeUploadRendition(p.img, sc.upload, p.Quality); err != nil {
+			// The saved file is uploaded instead
+			fmt.Printf("Warning: Failed to encode upload copy: %v\n", err)
+			p.UploadPath = ""
+		}
+	}
+
+	p.img = nil
+	return nil
 }
 
-func (sc *ScreenshotCapture) sendToWebapp(img image.Image, sessionID, timestamp, filename string) {
-	// Convert image to JPEG bytes
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: sc.quality}); err != nil {
-		fmt.Printf("Failed to encode image for webapp: %v\n", err)
-		return
+// writePending saves the encoded frame and its thumbnail locally
+func (sc *ScreenshotCapture) writePending(p *pendingFrame) error {
+	if p.Duplicate {
+		return nil
 	}
 
-	// Create multipart form
-	var body bytes.Buffer
-	writer := multipart.NewWriter(&body)
+	if err := sc.saveImage(p.data, p.FilePath); err != nil {
+		return fmt.Errorf("failed to save screenshot: %w", err)
+	}
 
-	// Add session ID
-	if err := writer.WriteField("sessionId", sessionID); err != nil {
-		fmt.Printf("Failed to write sessionId field: %v\n", err)
-		return
+	if p.thumbData != nil {
+		if err := writeThumbnail(p.ThumbnailPath, p.thumbData); err != nil {
+			fmt.Printf("Warning: Failed to save thumbnail: %v\n", err)
+			p.ThumbnailPath = ""
+		}
 	}
 
-	// Add timestamp
-	if err := writer.WriteField("timestamp", strconv.FormatInt(time.Now().Unix(), 10)); err != nil {
-		fmt.Printf("Failed to write timestamp field: %v\n", err)
-		return
+	if p.uploadData != nil {
+		if err := writeUploadRendition(p.UploadPath, p.uploadData); err != nil {
+			fmt.Printf("Warning: Failed to save upload copy: %v\n", err)
+			p.UploadPath = ""
+		}
+		p.uploadData = nil
 	}
+	return nil
+}
 
-	// Add screenshot file
-	part, err := writer.CreateFormFile("screenshot", filename)
-	if err != nil {
-		fmt.Printf("Failed to create form file: %v\n", err)
-		return
+func (sc *ScreenshotCapture) rememberFrame(display int, frame hashedFrame) {
+	sc.framesMu.Lock()
+	defer sc.framesMu.Unlock()
+	sc.lastFrames[display] = frame
+}
+
+// forgetFrame stops later captures from deduplicating against a frame
+// that was dropped before it reached disk
+func (sc *ScreenshotCapture) forgetFrame(display int, filePath string) {
+	sc.framesMu.Lock()
+	defer sc.framesMu.Unlock()
+	if last, ok := sc.lastFrames[display]; ok && last.filePath == filePath {
+		delete(sc.lastFrames, display)
 	}
+}
 
-	if _, err := io.Copy(part, &buf); err != nil {
-		fmt.Printf("Failed to copy image data: %v\n", err)
-		return
+// findDuplicate reports whether hash is within the configured distance of
+// the last saved frame for the display, returning that frame
+func (sc *ScreenshotCapture) findDuplicate(display int, hash frameHash) (hashedFrame, bool) {
+	if !sc.settings.Dedup {
+		return hashedFrame{}, false
 	}
+	sc.framesMu.Lock()
+	defer sc.framesMu.Unlock()
+	last, ok := sc.lastFrames[display]
+	if !ok || last.hash.Distance(hash) > sc.settings.DedupMaxDistance {
+		return hashedFrame{}, false
+	}
+	return last, true
+}
+
+type displayImage struct {
+	display    int
+	img        image.Image
+	redactions []string
+}
 
-	if err := writer.Close(); err != nil {
-		fmt.Printf("Failed to close multipart writer: %v\n", err)
-		return
+// captureDisplays grabs the displays selected by the display mode:
+// "primary" (default) captures display 0, "all" every active display as a
+// separate image, and "stitched" every display composed onto one canvas.
+func (sc *ScreenshotCapture) captureDisplays(n int) ([]displayImage, error) {
+	if sc.displayMode != "all" && sc.displayMode != "stitched" {
+		n = 1
 	}
 
-	// Send HTTP request
-	req, err := http.NewRequest("POST", sc.webappURL+"/api/screenshots", &body)
-	if err != nil {
-		fmt.Printf("Failed to create request: %v\n", err)
-		return
+	var images []displayImage
+	var rects []image.Rectangle
+	for i := 0; i < n; i++ {
+		if i == 0 && sc.probed != nil {
+			images = append(images, *sc.probed)
+			rects = append(rects, sc.source.DisplayBounds(0))
+			sc.probed = nil
+			continue
+		}
+		img, err := sc.source.Capture(i)
+		if err != nil {
+			return nil, fmt.Errorf("failed to capture display %d: %w", i, err)
+		}
+		applied, err := applyRedactions(img, i, sc.redactions)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, displayImage{display: i, img: img, redactions: applied})
+		rects = append(rects, sc.source.DisplayBounds(i))
 	}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if sc.displayMode == "stitched" && len(images) > 1 {
+		var applied []string
+		seen := make(map[string]bool)
+		for _, captured := range images {
+			for _, name := range captured.redactions {
+				if !seen[name] {
+					seen[name] = true
+					applied = append(applied, name)
+				}
+			}
+		}
+		return []displayImage{{display: -1, img: stitchDisplays(images, rects), redactions: applied}}, nil
+	}
+	return images, nil
+}
+
+// stitchDisplays lays the display images out on one canvas using their
+// positions on the virtual desktop.
+func stitchDisplays(images []displayImage, rects []image.Rectangle) image.Image {
+	union := rects[0]
+	for _, r := range rects[1:] {
+		union = union.Union(r)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, union.Dx(), union.Dy()))
+	for i, captured := range images {
+		dst := rects[i].Sub(union.Min)
+		draw.Draw(canvas, dst, captured.img, captured.img.Bounds().Min, draw.Src)
+	}
+	return canvas
+}
+
+// stitchSideBySide places images left to right, top aligned. Used when
+// recorded display positions are no longer known.
+func stitchSideBySide(images []image.Image) image.Image {
+	width, height := 0, 0
+	for _, img := range images {
+		width += img.Bounds().Dx()
+		if img.Bounds().Dy() > height {
+			height = img.Bounds().Dy()
+		}
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	x := 0
+	for _, img := range images {
+		b := img.Bounds()
+		draw.Draw(canvas, image.Rect(x, 0, x+b.Dx(), b.Dy()), img, b.Min, draw.Src)
+		x += b.Dx()
+	}
+	return canvas
+}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+// encodeFrame encodes img as JPEG using the configured settings. With
+// compression enabled the image is first downscaled to the configured
+// maximum resolution, then the quality is stepped down until the encoded
+// frame fits under max_file_size or min_quality is reached.
+func (sc *ScreenshotCapture) encodeFrame(img image.Image) (*encodedFrame, error) {
+	quality := sc.quality
+	if quality < 1 || quality > 100 {
+		quality = 80
+	}
+
+	if sc.settings.Compress {
+		img = scaleToFit(img, sc.settings.MaxWidth, sc.settings.MaxHeight)
+	}
+
+	data, err := encodeJPEG(img, quality)
 	if err != nil {
-		fmt.Printf("Failed to send screenshot to webapp: %v\n", err)
-		return
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		// Read the response body for more details
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Webapp returned error status: %d\n", resp.StatusCode)
-		fmt.Printf("Response body: %s\n", string(bodyBytes))
-		fmt.Printf("Request URL: %s\n", sc.webappURL+"/api/screenshots")
-		return
+	maxBytes := sc.settings.MaxFileSize * 1024 * 1024
+	if sc.settings.Compress && maxBytes > 0 {
+		minQuality := sc.settings.MinQuality
+		if minQuality < 1 {
+			minQuality = 1
+		}
+		for len(data) > maxBytes && quality > minQuality {
+			quality -= 10
+			if quality < minQuality {
+				quality = minQuality
+			}
+			if data, err = encodeJPEG(img, quality); err != nil {
+				return nil, err
+			}
+		}
+		if len(data) > maxBytes {
+			fmt.Printf("Warning: screenshot is %d KB at minimum quality %d, over the %d MB limit\n",
+				len(data)/1024, quality, sc.settings.MaxFileSize)
+		}
 	}
 
-	fmt.Printf("Screenshot sent to webapp successfully\n")
+	return &encodedFrame{
+		data:    data,
+		quality: quality,
+		width:   img.Bounds().Dx(),
+		height:  img.Bounds().Dy(),
+	}, nil
+}
+
+// saveImage writes a new screenshot, refusing to replace an existing file
+// that another row may already point at
+func (sc *ScreenshotCapture) saveImage(data []byte, filepath string) error {
+	file, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }
 
 func (sc *ScreenshotCapture) GetDisplayInfo() string {
-	n := screenshot.NumActiveDisplays()
+	n := sc.source.NumDisplays()
 	if n == 0 {
 		return "No active displays"
 	}
 
-	info := fmt.Sprintf("Found %d display(s):\n", n)
+	info := fmt.Sprintf("Found %d display(s) via %s backend:\n", n, sc.source.Name())
 	for i := 0; i < n; i++ {
-		bounds := screenshot.GetDisplayBounds(i)
+		bounds := sc.source.DisplayBounds(i)
 		info += fmt.Sprintf("Display %d: %dx%d at (%d,%d)\n",
 			i, bounds.Max.X-bounds.Min.X, bounds.Max.Y-bounds.Min.Y, bounds.Min.X, bounds.Min.Y)
 	}
 	return info
-}
\ No newline at end of file
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixedSource is a CaptureSource whose displays show one solid colour
+// each, at fixed positions on the virtual desktop
+type fixedSource struct {
+	rects  []image.Rectangle
+	colors []color.RGBA
+}
+
+func (s *fixedSource) Name() string { return "fixed" }
+
+func (s *fixedSource) NumDisplays() int { return len(s.rects) }
+
+func (s *fixedSource) DisplayBounds(display int) image.Rectangle { return s.rects[display] }
+
+func (s *fixedSource) Capture(display int) (*image.RGBA, error) {
+	r := s.rects[display]
+	img := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	for i := 0; i < len(img.Pix); i += 4 {
+		c := s.colors[display]
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img, nil
+}
+
+var (
+	red  = color.RGBA{255, 0, 0, 255}
+	blue = color.RGBA{0, 0, 255, 255}
+)
+
+// A laptop with a taller monitor to its left, whose desktop starts at
+// negative coordinates and whose top edges don't line up
+func twoDisplays() *fixedSource {
+	return &fixedSource{
+		rects:  []image.Rectangle{image.Rect(0, 100, 200, 220), image.Rect(-160, 0, 0, 240)},
+		colors: []color.RGBA{red, blue},
+	}
+}
+
+func TestStitchDisplays(t *testing.T) {
+	source := twoDisplays()
+	var images []displayImage
+	for i := range source.rects {
+		img, _ := source.Capture(i)
+		images = append(images, displayImage{display: i, img: img})
+	}
+
+	canvas := stitchDisplays(images, source.rects)
+	if got := canvas.Bounds(); got != image.Rect(0, 0, 360, 240) {
+		t.Fatalf("canvas is %v, want 360x240", got)
+	}
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, blue},             // Left monitor, top left
+		{159, 239, blue},         // Left monitor, bottom right
+		{160, 100, red},          // Laptop, top left
+		{359, 219, red},          // Laptop, bottom right
+		{200, 50, color.RGBA{}},  // Above the laptop: nothing there
+		{200, 230, color.RGBA{}}, // Below it
+	}
+	for _, tt := range tests {
+		if got := color.RGBAModel.Convert(canvas.At(tt.x, tt.y)); got != tt.want {
+			t.Errorf("pixel (%d, %d) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestStitchSideBySide(t *testing.T) {
+	source := twoDisplays()
+	left, _ := source.Capture(0)
+	right, _ := source.Capture(1)
+
+	canvas := stitchSideBySide([]image.Image{left, right})
+	if got := canvas.Bounds(); got != image.Rect(0, 0, 360, 240) {
+		t.Fatalf("canvas is %v, want 360x240", got)
+	}
+	if got := color.RGBAModel.Convert(canvas.At(0, 0)); got != red {
+		t.Errorf("first image starts at %v, want red", got)
+	}
+	if got := color.RGBAModel.Convert(canvas.At(200, 239)); got != blue {
+		t.Errorf("second image is not top aligned next to the first: %v", got)
+	}
+}
+
+func TestCaptureDisplayModes(t *testing.T) {
+	tests := []struct {
+		mode     string
+		displays []int
+		sizes    []image.Point
+	}{
+		{"primary", []int{0}, []image.Point{{200, 120}}},
+		{"all", []int{0, 1}, []image.Point{{200, 120}, {160, 240}}},
+		{"stitched", []int{-1}, []image.Point{{360, 240}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			capture := NewScreenshotCapture(t.TempDir(), ScreenshotSettings{Quality: 80, DisplayMode: tt.mode})
+			capture.SetSource(twoDisplays())
+			if err := capture.Initialize(); err != nil {
+				t.Fatal(err)
+			}
+
+			for tick := 1; tick <= 2; tick++ {
+				frames, err := capture.CaptureScreenForSession("s")
+				if err != nil {
+					t.Fatalf("capture: %v", err)
+				}
+				if len(frames) != len(tt.displays) {
+					t.Fatalf("tick %d saved %d frames, want %d", tick, len(frames), len(tt.displays))
+				}
+				for i, frame := range frames {
+					if frame.DisplayIndex != tt.displays[i] || frame.Tick != tick || !frame.Timestamp.Equal(frames[0].Timestamp) {
+						t.Errorf("tick %d frame %d = %+v", tick, i, frame)
+					}
+				}
+				for i, frame := range frames {
+					if got := decodeJPEG(t, frame.FilePath).Bounds().Size(); got != tt.sizes[i] {
+						t.Errorf("%s is %v, want %v", filepath.Base(frame.FilePath), got, tt.sizes[i])
+					}
+				}
+				if tt.mode == "all" && filepath.Base(frames[1].FilePath) != strings.TrimSuffix(filepath.Base(frames[0].FilePath), "_d0.jpg")+"_d1.jpg" {
+					t.Errorf("display files are named %s and %s", filepath.Base(frames[0].FilePath), filepath.Base(frames[1].FilePath))
+				}
+			}
+		})
+	}
+}
+
+// noise returns an image that JPEG can barely compress: 1200x900 of
+// random pixels is about 2 MB at quality 100 and under 1 MB at 90
+func noise(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	return img
+}
+
+func TestEncodeFrameFitsMaxFileSize(t *testing.T) {
+	tests := []struct {
+		name        string
+		settings    ScreenshotSettings
+		wantQuality int
+		fits        bool
+	}{
+		{"steps quality down", ScreenshotSettings{Quality: 100, Compress: true, MaxFileSize: 1, MinQuality: 40}, 90, true},
+		{"stops at min_quality", ScreenshotSettings{Quality: 100, Compress: true, MaxFileSize: 1, MinQuality: 95}, 95, false},
+		{"compression off", ScreenshotSettings{Quality: 100, Compress: false, MaxFileSize: 1, MinQuality: 40}, 100, false},
+		{"out of range quality", ScreenshotSettings{Quality: 0, Compress: true, MaxFileSize: 1, MinQuality: 40}, 80, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture := NewScreenshotCapture(t.TempDir(), tt.settings)
+			frame, err := capture.encodeFrame(noise(1200, 900))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if frame.quality != tt.wantQuality {
+				t.Errorf("encoded at quality %d, want %d", frame.quality, tt.wantQuality)
+			}
+			if fits := len(frame.data) <= 1024*1024; fits != tt.fits {
+				t.Errorf("frame is %d KB, fits under 1 MB = %v, want %v", len(frame.data)/1024, fits, tt.fits)
+			}
+		})
+	}
+}
+
+func TestEncodeFrameScalesToMaxResolution(t *testing.T) {
+	tests := []struct {
+		name     string
+		compress bool
+		in, want image.Point
+	}{
+		{"ultrawide", true, image.Pt(3840, 1080), image.Pt(1920, 540)},
+		{"portrait", true, image.Pt(1080, 2160), image.Pt(540, 1080)},
+		{"already fits", true, image.Pt(1280, 720), image.Pt(1280, 720)},
+		{"compression off", false, image.Pt(3840, 1080), image.Pt(3840, 1080)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capture := NewScreenshotCapture(t.TempDir(), ScreenshotSettings{
+				Quality: 80, Compress: tt.compress, MaxWidth: 1920, MaxHeight: 1080,
+			})
+			frame, err := capture.encodeFrame(image.NewRGBA(image.Rectangle{Max: tt.in}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := image.Pt(frame.width, frame.height); got != tt.want {
+				t.Errorf("encoded at %v, want %v", got, tt.want)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(frame.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := img.Bounds().Size(); got != tt.want {
+				t.Errorf("JPEG is %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// sequenceSource is a single display CaptureSource that replays images in
+// order, repeating the last one
+type sequenceSource struct {
+	images []*image.RGBA
+	next   int
+}
+
+func (s *sequenceSource) Name() string { return "sequence" }
+
+func (s *sequenceSource) NumDisplays() int { return 1 }
+
+func (s *sequenceSource) DisplayBounds(display int) image.Rectangle { return s.images[0].Bounds() }
+
+func (s *sequenceSource) Capture(display int) (*image.RGBA, error) {
+	img := s.images[s.next]
+	if s.next < len(s.images)-1 {
+		s.next++
+	}
+	return img, nil
+}
+
+func TestDedupRecordsDuplicates(t *testing.T) {
+	sm, err := NewSessionManager(t.TempDir(), testDeviceUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sm.Close()
+	session, err := sm.StartSession("Dedup", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture := NewScreenshotCapture(sm.GetSessionScreenshotDir(session.ID), ScreenshotSettings{
+		Quality: 80, Dedup: true, DedupMaxDistance: 3,
+	})
+	capture.SetSource(&sequenceSource{images: []*image.RGBA{
+		textScreen(10, false),
+		textScreen(10, true), // Cursor blink: unchanged
+		textScreen(10, false),
+		textScreen(20, false), // New content
+		textScreen(20, false),
+	}})
+	if err := capture.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ {
+		frames, err := capture.CaptureScreenForSession("s")
+		if err != nil {
+			t.Fatalf("capture %d: %v", i, err)
+		}
+		for _, frame := range frames {
+			if err := sm.RecordScreenshot(frame); err != nil {
+				t.Fatalf("record %d: %v", i, err)
+			}
+		}
+	}
+
+	screenshots, err := sm.GetSessionScreenshots(session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(screenshots) != 5 {
+		t.Fatalf("%d rows recorded, want one per tick", len(screenshots))
+	}
+	wantOriginal := []int{0, 0, 0, 3, 3}
+	for i, shot := range screenshots {
+		original := screenshots[wantOriginal[i]]
+		if shot.FilePath != original.FilePath {
+			t.Errorf("row %d uses %s, want %s", i, filepath.Base(shot.FilePath), filepath.Base(original.FilePath))
+		}
+		if shot.IsDuplicate() != (i != wantOriginal[i]) {
+			t.Errorf("row %d duplicate = %v", i, shot.IsDuplicate())
+		}
+		if shot.IsDuplicate() && (shot.DuplicateOf != original.ID || shot.FileSize != 0) {
+			t.Errorf("row %d points at %d with %d bytes, want %d with none", i, shot.DuplicateOf, shot.FileSize, original.ID)
+		}
+		if shot.Hash == "" {
+			t.Errorf("row %d has no hash", i)
+		}
+	}
+
+	files, _ := os.ReadDir(sm.GetSessionScreenshotDir(session.ID))
+	if len(files) != 2 {
+		t.Errorf("%d files written, want 2", len(files))
+	}
+}
+
+func TestSaveImageKeepsExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shot.jpg")
+	capture := NewScreenshotCapture(t.TempDir(), ScreenshotSettings{})
+	if err := capture.saveImage([]byte("first"), path); err != nil {
+		t.Fatal(err)
+	}
+	if err := capture.saveImage([]byte("second"), path); err == nil {
+		t.Error("overwrote an existing screenshot")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "first" {
+		t.Errorf("file holds %q, want the first screenshot", data)
+	}
+}
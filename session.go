@@ -6,37 +6,91 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Session struct {
-	ID          int       `json:"id"`
-	StartTime   time.Time `json:"start_time"`
-	EndTime     time.Time `json:"end_time"`
-	Description string    `json:"description"`
-	StudentName string    `json:"student_name"`
-	Status      string    `json:"status"` // "active", "completed"
+	ID            int       `json:"id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	Description   string    `json:"description"`
+	StudentName   string    `json:"student_name"`
+	Status        string    `json:"status"` // "active", "completed"
+	CapturePolicy string    `json:"capture_policy"`
+	CaptureParams string    `json:"capture_params"` // JSON parameters of the capture policy
+	Summary       string    `json:"summary,omitempty"`
+
+	// LastActivityAt is refreshed by every screenshot and periodically by
+	// the capturing process; see Interrupted
+	LastActivityAt time.Time `json:"last_activity_at"`
+
+	// GlobalSessionID is "<device UUID>_<id>_<start unix>", so sessions of
+	// different machines never share a webapp or storage path
+	GlobalSessionID string `json:"global_id"`
+	LegacyGlobalID  string `json:"legacy_global_id,omitempty"` // ID used before device UUIDs, if any
+
+	// Set once retention has deleted the session's screenshots or folder
+	PurgedAt   time.Time `json:"purged_at,omitempty"`
+	PurgeScope string    `json:"purge_scope,omitempty"` // purgeScreenshots or purgeSession
+}
+
+// GlobalID identifies the session to the webapp and upload sinks
+func (s *Session) GlobalID() string {
+	if s.GlobalSessionID != "" {
+		return s.GlobalSessionID
+	}
+	return legacyGlobalID(s.ID, s.StartTime)
+}
+
+func newGlobalID(deviceUUID string, id int, start time.Time) string {
+	return fmt.Sprintf("%s_%d_%d", deviceUUID, id, start.Unix())
+}
+
+// legacyGlobalID is the session ID uploaded before device UUIDs
+func legacyGlobalID(id int, start time.Time) string {
+	return fmt.Sprintf("%d_%d", id, start.Unix())
 }
 
 type Screenshot struct {
-	ID        int       `json:"id"`
-	SessionID int       `json:"session_id"`
-	Timestamp time.Time `json:"timestamp"`
-	FilePath  string    `json:"file_path"`
-	FileSize  int64     `json:"file_size"`
+	ID            int       `json:"id"`
+	SessionID     int       `json:"session_id"`
+	Timestamp     time.Time `json:"timestamp"`
+	FilePath      string    `json:"file_path"`
+	FileSize      int64     `json:"file_size"`
+	DisplayIndex  int       `json:"display_index"` // -1 for a stitched multi-display canvas
+	Tick          int       `json:"tick"`          // Capture tick shared by all displays of one capture
+	Quality       int       `json:"jpeg_quality"`  // JPEG quality actually used
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	Hash          string    `json:"phash"`
+	DuplicateOf   int       `json:"duplicate_of,omitempty"` // ID of the earlier row whose file this one reuses
+	WindowTitle   string    `json:"window_title,omitempty"`
+	WindowClass   string    `json:"window_class,omitempty"` // WM_CLASS of the foreground application
+	WindowPID     int       `json:"window_pid,omitempty"`
+	Redactions    string    `json:"redactions,omitempty"` // Comma-separated redaction rules applied
+	ThumbnailPath string    `json:"thumbnail_path,omitempty"`
 }
 
 type SessionManager struct {
-	db            *sql.DB
-	baseDir       string
+	db         *sql.DB
+	baseDir    string
+	deviceUUID string // Prefix of every global session ID
+
+	// The session this process is capturing. The pipeline, stop paths,
+	// remote commands and heartbeats all reach it, so it is only read or
+	// replaced under mu.
+	mu             sync.Mutex
 	currentSession *Session
 }
 
-func NewSessionManager(baseDir string) (*SessionManager, error) {
+func NewSessionManager(baseDir, deviceUUID string) (*SessionManager, error) {
 	sm := &SessionManager{
-		baseDir: baseDir,
+		baseDir:    baseDir,
+		deviceUUID: deviceUUID,
 	}
 
 	// Create base directory (this will be the "sessions" folder alongside executable)
@@ -60,63 +114,27 @@ func (sm *SessionManager) initDatabase() error {
 		return err
 	}
 
-	// Create sessions table
-	if _, err := sm.db.Exec(`
-		CREATE TABLE IF NOT EXISTS sessions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			start_time DATETIME NOT NULL,
-			end_time DATETIME,
-			description TEXT,
-			student_name TEXT,
-			status TEXT NOT NULL DEFAULT 'active'
-		)
-	`); err != nil {
-		return err
-	}
-
-	// Add student_name column to existing tables if it doesn't exist
-	sm.db.Exec(`ALTER TABLE sessions ADD COLUMN student_name TEXT`)
-
-	// Create screenshots table
-	if _, err := sm.db.Exec(`
-		CREATE TABLE IF NOT EXISTS screenshots (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			session_id INTEGER NOT NULL,
-			timestamp DATETIME NOT NULL,
-			file_path TEXT NOT NULL,
-			file_size INTEGER NOT NULL,
-			FOREIGN KEY (session_id) REFERENCES sessions (id)
-		)
-	`); err != nil {
-		return err
-	}
-
-	return nil
+	// Bring the schema up to date; see migrations.go
+	return sm.migrate()
 }
 
 func (sm *SessionManager) StartSession(description string, studentName string) (*Session, error) {
-	// Check if there's an active session (both in memory and database)
-	if sm.currentSession != nil && sm.currentSession.Status == "active" {
-		return nil, fmt.Errorf("session already active (ID: %d)", sm.currentSession.ID)
-	}
-
-	// Check database for any active sessions (handles stale sessions from crashed processes)
-	activeSession, err := sm.GetActiveSession()
+	// Check the database for an active session, which may belong to
+	// another process
+	activeSession, err := sm.FindActiveSession()
 	if err != nil {
 		return nil, fmt.Errorf("failed to check for active sessions: %w", err)
 	}
 
 	if activeSession != nil {
-		// Found a stale active session - check if it's really old (more than 10 minutes without activity)
-		if time.Since(activeSession.StartTime) > 10*time.Minute {
-			// Automatically clean up old stale session
-			fmt.Printf("Found stale session (ID: %d, started: %s). Cleaning up...\n",
-				activeSession.ID, activeSession.StartTime.Format("2006-01-02 15:04:05"))
-			if err := sm.forceStopSession(activeSession.ID); err != nil {
-				return nil, fmt.Errorf("failed to clean up stale session: %w", err)
+		if activeSession.Interrupted(time.Now()) {
+			// Its process died; it ends where its activity stopped
+			fmt.Printf("Closing interrupted session (ID: %d, last activity: %s)\n",
+				activeSession.ID, activeSession.lastActivity().Format("2006-01-02 15:04:05"))
+			if err := sm.closeInterruptedSession(activeSession); err != nil {
+				return nil, fmt.Errorf("failed to close interrupted session: %w", err)
 			}
 		} else {
-			// Recent session - might still be active
 			return nil, fmt.Errorf("session already active (ID: %d)", activeSession.ID)
 		}
 	}
@@ -127,10 +145,11 @@ func (sm *SessionManager) StartSession(description string, studentName string) (
 		StudentName: studentName,
 		Status:      "active",
 	}
+	session.LastActivityAt = session.StartTime
 
 	result, err := sm.db.Exec(
-		"INSERT INTO sessions (start_time, description, student_name, status) VALUES (?, ?, ?, ?)",
-		session.StartTime, session.Description, session.StudentName, session.Status,
+		"INSERT INTO sessions (start_time, description, student_name, status, last_activity_at) VALUES (?, ?, ?, ?, ?)",
+		session.StartTime, session.Description, session.StudentName, session.Status, session.LastActivityAt,
 	)
 	if err != nil {
 		return nil, err
@@ -142,7 +161,11 @@ func (sm *SessionManager) StartSession(description string, studentName string) (
 	}
 
 	session.ID = int(id)
-	sm.currentSession = session
+	session.GlobalSessionID = newGlobalID(sm.deviceUUID, session.ID, session.StartTime)
+	if _, err := sm.db.Exec("UPDATE sessions SET global_id = ? WHERE id = ?", session.GlobalSessionID, session.ID); err != nil {
+		return nil, err
+	}
+	sm.setCurrentSession(session)
 
 	// Create individual session directory (e.g., "session_1", "session_2")
 	sessionDir := sm.GetSessionDir(session.ID)
@@ -156,6 +179,9 @@ func (sm *SessionManager) StartSession(description string, studentName string) (
 }
 
 func (sm *SessionManager) StopSession() error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
 	if sm.currentSession == nil || sm.currentSession.Status != "active" {
 		return fmt.Errorf("no active session to stop")
 	}
@@ -169,66 +195,91 @@ func (sm *SessionManager) StopSession() error {
 		return err
 	}
 
-	sm.currentSession.EndTime = endTime
-	sm.currentSession.Status = "completed"
-
-	return nil
-}
-
-// forceStopSession stops any session by ID (used for cleaning up stale sessions)
-func (sm *SessionManager) forceStopSession(sessionID int) error {
-	endTime := time.Now()
-	_, err := sm.db.Exec(
-		"UPDATE sessions SET end_time = ?, status = ? WHERE id = ? AND status = 'active'",
-		endTime, "completed", sessionID,
-	)
-	if err != nil {
+	// A session stopped while paused ends its pause too
+	if err := sm.closePause(sm.currentSession.ID, endTime, false); err != nil {
 		return err
 	}
 
-	// Clear current session if it matches
-	if sm.currentSession != nil && sm.currentSession.ID == sessionID {
-		sm.currentSession.EndTime = endTime
-		sm.currentSession.Status = "completed"
-		sm.currentSession = nil
-	}
+	sm.currentSession.EndTime = endTime
+	sm.currentSession.Status = "completed"
 
 	return nil
 }
 
-func (sm *SessionManager) RecordScreenshot(filePath string) error {
-	if sm.currentSession == nil || sm.currentSession.Status != "active" {
+func (sm *SessionManager) RecordScreenshot(frame CapturedFrame) error {
+	sm.mu.Lock()
+	current := sm.currentSession
+	active := current != nil && current.Status == "active"
+	sm.mu.Unlock()
+	if !active {
 		return fmt.Errorf("no active session")
 	}
 
-	// Get file size
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		return err
-	}
-
 	screenshot := &Screenshot{
-		SessionID: sm.currentSession.ID,
-		Timestamp: time.Now(),
-		FilePath:  filePath,
-		FileSize:  fileInfo.Size(),
+		SessionID:     current.ID,
+		Timestamp:     frame.Timestamp,
+		FilePath:      frame.FilePath,
+		DisplayIndex:  frame.DisplayIndex,
+		Tick:          frame.Tick,
+		Quality:       frame.Quality,
+		Width:         frame.Width,
+		Height:        frame.Height,
+		Hash:          frame.Hash,
+		WindowTitle:   frame.Window.Title,
+		WindowClass:   frame.Window.Class,
+		WindowPID:     frame.Window.PID,
+		Redactions:    strings.Join(frame.Redactions, ","),
+		ThumbnailPath: frame.ThumbnailPath,
+	}
+
+	var duplicateOf sql.NullInt64
+	if frame.Duplicate {
+		// Duplicate rows point at the row that owns the file and add no bytes
+		err := sm.db.QueryRow(
+			"SELECT id FROM screenshots WHERE session_id = ? AND file_path = ? AND duplicate_of IS NULL ORDER BY id DESC LIMIT 1",
+			screenshot.SessionID, frame.FilePath,
+		).Scan(&duplicateOf)
+		if err != nil {
+			return fmt.Errorf("failed to find original screenshot: %w", err)
+		}
+	} else {
+		// Get file size
+		fileInfo, err := os.Stat(frame.FilePath)
+		if err != nil {
+			return err
+		}
+		screenshot.FileSize = fileInfo.Size()
 	}
 
-	_, err = sm.db.Exec(
-		"INSERT INTO screenshots (session_id, timestamp, file_path, file_size) VALUES (?, ?, ?, ?)",
+	_, err := sm.db.Exec(
+		"INSERT INTO screenshots (session_id, timestamp, file_path, file_size, display_index, tick, jpeg_quality, width, height, phash, duplicate_of, window_title, window_class, window_pid, redactions, thumbnail_path) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		screenshot.SessionID, screenshot.Timestamp, screenshot.FilePath, screenshot.FileSize,
+		screenshot.DisplayIndex, screenshot.Tick, screenshot.Quality, screenshot.Width, screenshot.Height,
+		screenshot.Hash, duplicateOf, screenshot.WindowTitle, screenshot.WindowClass, screenshot.WindowPID,
+		screenshot.Redactions, screenshot.ThumbnailPath,
 	)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return sm.touchSession(screenshot.SessionID, screenshot.Timestamp)
 }
 
 func (sm *SessionManager) GetCurrentSession() *Session {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
 	return sm.currentSession
 }
 
+func (sm *SessionManager) setCurrentSession(session *Session) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.currentSession = session
+}
+
 func (sm *SessionManager) GetSessionScreenshots(sessionID int) ([]Screenshot, error) {
 	rows, err := sm.db.Query(
-		"SELECT id, session_id, timestamp, file_path, file_size FROM screenshots WHERE session_id = ? ORDER BY timestamp",
+		"SELECT id, session_id, timestamp, file_path, file_size, display_index, tick, jpeg_quality, width, height, phash, duplicate_of, window_title, window_class, window_pid, redactions, thumbnail_path FROM screenshots WHERE session_id = ? AND purged_at IS NULL ORDER BY timestamp, display_index",
 		sessionID,
 	)
 	if err != nil {
@@ -239,64 +290,136 @@ func (sm *SessionManager) GetSessionScreenshots(sessionID int) ([]Screenshot, er
 	var screenshots []Screenshot
 	for rows.Next() {
 		var s Screenshot
-		if err := rows.Scan(&s.ID, &s.SessionID, &s.Timestamp, &s.FilePath, &s.FileSize); err != nil {
+		var hash, windowTitle, windowClass, redactions, thumbnailPath sql.NullString
+		var duplicateOf, windowPID sql.NullInt64
+		if err := rows.Scan(&s.ID, &s.SessionID, &s.Timestamp, &s.FilePath, &s.FileSize, &s.DisplayIndex, &s.Tick,
+			&s.Quality, &s.Width, &s.Height, &hash, &duplicateOf, &windowTitle, &windowClass, &windowPID, &redactions, &thumbnailPath); err != nil {
 			return nil, err
 		}
+		s.Hash = hash.String
+		s.DuplicateOf = int(duplicateOf.Int64)
+		s.WindowTitle = windowTitle.String
+		s.WindowClass = windowClass.String
+		s.WindowPID = int(windowPID.Int64)
+		s.Redactions = redactions.String
+		s.ThumbnailPath = thumbnailPath.String
 		screenshots = append(screenshots, s)
 	}
 
 	return screenshots, rows.Err()
 }
 
+// IsDuplicate reports whether the row reuses an earlier screenshot's file
+// because the screen had not changed
+func (s Screenshot) IsDuplicate() bool {
+	return s.DuplicateOf != 0
+}
+
+// groupScreenshotsByTick splits time-ordered screenshots into capture
+// ticks. Rows recorded before ticks existed (tick 0) each form their own
+// group.
+func groupScreenshotsByTick(screenshots []Screenshot) [][]Screenshot {
+	var groups [][]Screenshot
+	for _, s := range screenshots {
+		last := len(groups) - 1
+		if s.Tick != 0 && last >= 0 && groups[last][0].Tick == s.Tick {
+			groups[last] = append(groups[last], s)
+			continue
+		}
+		groups = append(groups, []Screenshot{s})
+	}
+	return groups
+}
+
 func (sm *SessionManager) GetSessionByID(id int) (*Session, error) {
-	var session Session
-	var endTime sql.NullTime
-	var studentName sql.NullString
-	err := sm.db.QueryRow(
-		"SELECT id, start_time, end_time, description, student_name, status FROM sessions WHERE id = ?",
+	return scanSession(sm.db.QueryRow(
+		"SELECT "+sessionColumns+" FROM sessions WHERE id = ?",
 		id,
-	).Scan(&session.ID, &session.StartTime, &endTime, &session.Description, &studentName, &session.Status)
+	))
+}
 
-	if endTime.Valid {
-		session.EndTime = endTime.Time
-	}
-	if studentName.Valid {
-		session.StudentName = studentName.String
+// GetActiveSession loads the active session and makes it current, so a
+// later StopSession or RecordScreenshot acts on it
+func (sm *SessionManager) GetActiveSession() (*Session, error) {
+	session, err := sm.FindActiveSession()
+	if err != nil || session == nil {
+		return session, err
 	}
 
+	sm.setCurrentSession(session)
+	return session, nil
+}
+
+// FindActiveSession loads the active session without making it current,
+// for callers that only look at it
+func (sm *SessionManager) FindActiveSession() (*Session, error) {
+	session, err := scanSession(sm.db.QueryRow(
+		"SELECT " + sessionColumns + " FROM sessions WHERE status = 'active' ORDER BY start_time DESC LIMIT 1",
+	))
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // No active session
+		}
 		return nil, err
 	}
+	return session, nil
+}
 
-	return &session, nil
+// sessionColumns lists the sessions columns read by scanSession, in order
+const sessionColumns = "id, start_time, end_time, description, student_name, status, capture_policy, capture_params, summary, global_id, legacy_global_id, purged_at, purge_scope, last_activity_at"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (sm *SessionManager) GetActiveSession() (*Session, error) {
+// scanSession reads sessionColumns, followed by any extra columns the
+// query selected into extra
+func scanSession(row rowScanner, extra ...interface{}) (*Session, error) {
 	var session Session
-	var endTime sql.NullTime
-	var studentName sql.NullString
-	err := sm.db.QueryRow(
-		"SELECT id, start_time, end_time, description, student_name, status FROM sessions WHERE status = 'active' ORDER BY start_time DESC LIMIT 1",
-	).Scan(&session.ID, &session.StartTime, &endTime, &session.Description, &studentName, &session.Status)
+	var endTime, purgedAt, lastActivity sql.NullTime
+	var studentName, capturePolicy, captureParams, summary, globalID, legacyID, purgeScope sql.NullString
+
+	dest := []interface{}{&session.ID, &session.StartTime, &endTime, &session.Description, &studentName, &session.Status,
+		&capturePolicy, &captureParams, &summary, &globalID, &legacyID, &purgedAt, &purgeScope, &lastActivity}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
 
 	if endTime.Valid {
 		session.EndTime = endTime.Time
 	}
-	if studentName.Valid {
-		session.StudentName = studentName.String
+	session.StudentName = studentName.String
+	session.CapturePolicy = capturePolicy.String
+	session.CaptureParams = captureParams.String
+	session.Summary = summary.String
+	session.GlobalSessionID = globalID.String
+	session.LegacyGlobalID = legacyID.String
+	if purgedAt.Valid {
+		session.PurgedAt = purgedAt.Time
 	}
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil // No active session
-		}
-		return nil, err
+	session.PurgeScope = purgeScope.String
+	if lastActivity.Valid {
+		session.LastActivityAt = lastActivity.Time
 	}
 
-	sm.currentSession = &session
 	return &session, nil
 }
 
+// SetCapturePolicy records the capture policy a session runs with
+func (sm *SessionManager) SetCapturePolicy(sessionID int, policy string, params string) error {
+	_, err := sm.db.Exec(
+		"UPDATE sessions SET capture_policy = ?, capture_params = ? WHERE id = ?",
+		policy, params, sessionID,
+	)
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if err == nil && sm.currentSession != nil && sm.currentSession.ID == sessionID {
+		sm.currentSession.CapturePolicy = policy
+		sm.currentSession.CaptureParams = params
+	}
+	return err
+}
+
 func (sm *SessionManager) GetSessionDir(sessionID int) string {
 	return filepath.Join(sm.baseDir, fmt.Sprintf("session_%d", sessionID))
 }
@@ -313,7 +436,7 @@ func (sm *SessionManager) Close() error {
 }
 
 func (sm *SessionManager) GetStudentSessions(studentName string, limit int) ([]Session, error) {
-	query := "SELECT id, start_time, end_time, description, student_name, status FROM sessions WHERE student_name = ? AND status = 'completed' ORDER BY start_time DESC"
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE student_name = ? AND status = 'completed' ORDER BY start_time DESC"
 	if limit > 0 {
 		query += fmt.Sprintf(" LIMIT %d", limit)
 	}
@@ -326,22 +449,11 @@ func (sm *SessionManager) GetStudentSessions(studentName string, limit int) ([]S
 
 	var sessions []Session
 	for rows.Next() {
-		var session Session
-		var endTime sql.NullTime
-		var studentNameNull sql.NullString
-
-		if err := rows.Scan(&session.ID, &session.StartTime, &endTime, &session.Description, &studentNameNull, &session.Status); err != nil {
+		session, err := scanSession(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		if endTime.Valid {
-			session.EndTime = endTime.Time
-		}
-		if studentNameNull.Valid {
-			session.StudentName = studentNameNull.String
-		}
-
-		sessions = append(sessions, session)
+		sessions = append(sessions, *session)
 	}
 
 	return sessions, rows.Err()
@@ -372,4 +484,4 @@ func (sm *SessionManager) ExportSessionData(sessionID int, outputPath string) er
 	}
 
 	return os.WriteFile(outputPath, jsonData, 0644)
-}
\ No newline at end of file
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SessionEvent is a timestamped annotation on a session, such as a
+// bookmark typed during capture
+type SessionEvent struct {
+	ID        int       `json:"id"`
+	SessionID int       `json:"session_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"` // "bookmark"
+	Note      string    `json:"note"`
+}
+
+const eventKindBookmark = "bookmark"
+
+func (sm *SessionManager) AddSessionEvent(sessionID int, kind, note string) error {
+	_, err := sm.db.Exec(
+		"INSERT INTO session_events (session_id, timestamp, kind, note) VALUES (?, ?, ?, ?)",
+		sessionID, time.Now(), kind, note,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record %s: %w", kind, err)
+	}
+	return nil
+}
+
+func (sm *SessionManager) GetSessionEvents(sessionID int) ([]SessionEvent, error) {
+	rows, err := sm.db.Query(
+		"SELECT id, session_id, timestamp, kind, note FROM session_events WHERE session_id = ? ORDER BY timestamp",
+		sessionID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []SessionEvent
+	for rows.Next() {
+		var e SessionEvent
+		var note sql.NullString
+		if err := rows.Scan(&e.ID, &e.SessionID, &e.Timestamp, &e.Kind, &note); err != nil {
+			return nil, err
+		}
+		e.Note = note.String
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
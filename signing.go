@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers carrying the upload signature. The signed string is
+//
+//	v1\n<device>\n<sessionId>\n<timestamps>\n<signed at>\n<nonce>\n<sha256 of images>
+//
+// where a batch of several screenshots joins the timestamp fields and the
+// hex image digests with commas, in form order. The signature binds each
+// image to its session and capture time, and the signing time plus a
+// single-use nonce stop it being replayed. Other device requests (command
+// polls and acknowledgements, heartbeats) are signed the same way with
+// "<METHOD> <path>" as the session ID, no timestamps and the SHA-256 of
+// the request body as the digest.
+const (
+	headerDevice    = "X-Infogen-Device"
+	headerSignedAt  = "X-Infogen-Signed-At"
+	headerNonce     = "X-Infogen-Nonce"
+	headerDigest    = "X-Infogen-Content-SHA256"
+	headerSignature = "X-Infogen-Signature"
+
+	signatureVersion = "v1"
+
+	// signatureWindow is how far the signing time may be from the server's
+	// clock; WINDOW_SECONDS in the webapp's verify-upload.ts
+	signatureWindow = 5 * time.Minute
+)
+
+// UploadSigner signs screenshot uploads with the device token shared with
+// the webapp
+type UploadSigner struct {
+	DeviceID string
+	Token    string
+}
+
+// NewUploadSigner returns nil when no token is configured, which leaves
+// uploads unsigned. deviceID is Config.deviceID, so machines without a
+// device_id sign as their device UUID.
+func NewUploadSigner(deviceID, token string) *UploadSigner {
+	if token == "" {
+		return nil
+	}
+	return &UploadSigner{DeviceID: deviceID, Token: token}
+}
+
+// Sign adds the signature headers for an upload of images to req, each
+// paired with the timestamp field at the same index
+func (s *UploadSigner) Sign(req *http.Request, sessionID string, timestamps []string, images [][]byte) error {
+	return s.sign(req, sessionID, strings.Join(timestamps, ","), imageDigests(images))
+}
+
+// SignRequest signs a request that is not an upload, such as a command
+// poll or heartbeat. The session field carries the method and path, so a
+// signature for one endpoint can't be replayed against another, and the
+// digest covers the body (empty for GET).
+func (s *UploadSigner) SignRequest(req *http.Request, body []byte) error {
+	return s.sign(req, requestScope(req.Method, req.URL.Path), "", imageDigests([][]byte{body}))
+}
+
+func (s *UploadSigner) sign(req *http.Request, sessionID, timestamp, digest string) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	fields := signedFields{
+		DeviceID:  s.DeviceID,
+		SessionID: sessionID,
+		Timestamp: timestamp,
+		SignedAt:  strconv.FormatInt(time.Now().Unix(), 10),
+		Nonce:     hex.EncodeToString(nonce),
+		Digest:    digest,
+	}
+
+	req.Header.Set(headerDevice, fields.DeviceID)
+	req.Header.Set(headerSignedAt, fields.SignedAt)
+	req.Header.Set(headerNonce, fields.Nonce)
+	req.Header.Set(headerDigest, fields.Digest)
+	req.Header.Set(headerSignature, fields.sign(s.Token))
+	return nil
+}
+
+// requestScope is what SignRequest signs in place of a session ID
+func requestScope(method, path string) string {
+	return method + " " + path
+}
+
+// imageDigests returns the comma separated hex SHA-256 of each image
+func imageDigests(images [][]byte) string {
+	digests := make([]string, len(images))
+	for i, image := range images {
+		digest := sha256.Sum256(image)
+		digests[i] = hex.EncodeToString(digest[:])
+	}
+	return strings.Join(digests, ",")
+}
+
+// signedFields are the values covered by the signature
+type signedFields struct {
+	DeviceID  string
+	SessionID string
+	Timestamp string
+	SignedAt  string
+	Nonce     string
+	Digest    string
+}
+
+func (f signedFields) sign(token string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(strings.Join([]string{
+		signatureVersion, f.DeviceID, f.SessionID, f.Timestamp, f.SignedAt, f.Nonce, f.Digest,
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifiedUpload is a screenshot upload whose signature checked out
+type VerifiedUpload struct {
+	DeviceID  string
+	SessionID string
+	Frames    []VerifiedFrame
+}
+
+// VerifiedFrame is one screenshot of a verified upload
+type VerifiedFrame struct {
+	Timestamp string
+	Filename  string
+	Data      []byte
+}
+
+// UploadVerifier checks signed uploads: known device, signing time within
+// the window, unused nonce, matching digest and signature. It is the same
+// check the webapp performs, so both ends can be exercised in one process.
+type UploadVerifier struct {
+	tokens map[string]string // Device ID to token
+	window time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // Nonces seen within the window
+	now    func() time.Time
+}
+
+func NewUploadVerifier(tokens map[string]string, window time.Duration) *UploadVerifier {
+	if window <= 0 {
+		window = signatureWindow
+	}
+	return &UploadVerifier{
+		tokens: tokens,
+		window: window,
+		nonces: make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Verify parses the multipart upload in r and checks its signature
+func (v *UploadVerifier) Verify(r *http.Request) (*VerifiedUpload, error) {
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, fmt.Errorf("invalid upload form: %w", err)
+	}
+	headers := r.MultipartForm.File["screenshot"]
+	timestamps := r.MultipartForm.Value["timestamp"]
+	if len(headers) == 0 {
+		return nil, fmt.Errorf("missing screenshot")
+	}
+	if len(timestamps) != len(headers) {
+		return nil, fmt.Errorf("%d timestamps for %d screenshots", len(timestamps), len(headers))
+	}
+
+	frames := make([]VerifiedFrame, len(headers))
+	images := make([][]byte, len(headers))
+	for i, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open screenshot: %w", err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read screenshot: %w", err)
+		}
+		frames[i] = VerifiedFrame{Timestamp: timestamps[i], Filename: header.Filename, Data: data}
+		images[i] = data
+	}
+
+	fields := signedFields{
+		DeviceID:  r.Header.Get(headerDevice),
+		SessionID: r.FormValue("sessionId"),
+		Timestamp: strings.Join(timestamps, ","),
+		SignedAt:  r.Header.Get(headerSignedAt),
+		Nonce:     r.Header.Get(headerNonce),
+		Digest:    r.Header.Get(headerDigest),
+	}
+	if err := v.check(fields, imageDigests(images), r.Header.Get(headerSignature)); err != nil {
+		return nil, err
+	}
+
+	return &VerifiedUpload{
+		DeviceID:  fields.DeviceID,
+		SessionID: fields.SessionID,
+		Frames:    frames,
+	}, nil
+}
+
+// VerifyRequest checks a request signed with SignRequest and returns the
+// device that signed it along with the body it read
+func (v *UploadVerifier) VerifyRequest(r *http.Request) (string, []byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read request: %w", err)
+	}
+	fields := signedFields{
+		DeviceID:  r.Header.Get(headerDevice),
+		SessionID: requestScope(r.Method, r.URL.Path),
+		SignedAt:  r.Header.Get(headerSignedAt),
+		Nonce:     r.Header.Get(headerNonce),
+		Digest:    r.Header.Get(headerDigest),
+	}
+	if err := v.check(fields, imageDigests([][]byte{body}), r.Header.Get(headerSignature)); err != nil {
+		return "", nil, err
+	}
+	return fields.DeviceID, body, nil
+}
+
+// check verifies signed fields against the digest of what was actually
+// received and the signature sent with them
+func (v *UploadVerifier) check(fields signedFields, digest, signature string) error {
+	token, ok := v.tokens[fields.DeviceID]
+	if !ok || token == "" {
+		return fmt.Errorf("unknown device %q", fields.DeviceID)
+	}
+
+	signedAt, err := strconv.ParseInt(fields.SignedAt, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signing time")
+	}
+	now := v.now()
+	if skew := now.Sub(time.Unix(signedAt, 0)); skew > v.window || skew < -v.window {
+		return fmt.Errorf("signature outside the %s window", v.window)
+	}
+
+	if !hmac.Equal([]byte(digest), []byte(strings.ToLower(fields.Digest))) {
+		return fmt.Errorf("content digest mismatch")
+	}
+
+	expected := fields.sign(token)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	// Only remember nonces of genuine requests, and only for as long as
+	// the window would accept them
+	if fields.Nonce == "" || !v.useNonce(fields.DeviceID+":"+fields.Nonce, now) {
+		return fmt.Errorf("nonce already used")
+	}
+	return nil
+}
+
+func (v *UploadVerifier) useNonce(key string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for nonce, seen := range v.nonces {
+		if now.Sub(seen) > 2*v.window {
+			delete(v.nonces, nonce)
+		}
+	}
+	if _, used := v.nonces[key]; used {
+		return false
+	}
+	v.nonces[key] = now
+	return true
+}
+
+// NewUploadReceiver returns a handler for POST /api/screenshots that
+// verifies each upload before passing it to handle. It mirrors the webapp
+// route for local collection and protocol tests.
+func NewUploadReceiver(verifier *UploadVerifier, handle func(*VerifiedUpload) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(map[string]string{"error": "method not allowed"})
+			return
+		}
+
+		upload, err := verifier.Verify(r)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		if err := handle(upload); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]bool{"success": true})
+	})
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testDevice = "lab-1"
+	testToken  = "secret-token"
+)
+
+func newTestVerifier() *UploadVerifier {
+	return NewUploadVerifier(map[string]string{testDevice: testToken}, 5*time.Minute)
+}
+
+// receiverServer verifies uploads with verifier and collects the ones
+// that pass
+func receiverServer(t *testing.T, verifier *UploadVerifier) (*httptest.Server, *[]*VerifiedUpload) {
+	t.Helper()
+	var uploads []*VerifiedUpload
+	server := httptest.NewServer(NewUploadReceiver(verifier, func(upload *VerifiedUpload) error {
+		uploads = append(uploads, upload)
+		return nil
+	}))
+	t.Cleanup(server.Close)
+	return server, &uploads
+}
+
+// queueSignedUpload sends one screenshot through an outbox signing with
+// signer and returns how many uploads ended up dead
+func queueSignedUpload(t *testing.T, serverURL string, signer *UploadSigner) int {
+	t.Helper()
+	sm := newTestSessionManager(t)
+	session, err := sm.StartSession("Signing test", "Ada")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shot := filepath.Join(sm.GetSessionDir(session.ID), "screenshot.jpg")
+	writeTestFile(t, shot)
+
+	outbox := NewUploadOutbox(sm.db, []*uploadSink{{UploadSink: NewWebappClient(serverURL, signer, nil), name: "webapp"}}, OutboxSettings{MaxAttempts: 1})
+	frame := CapturedFrame{FilePath: shot, Timestamp: time.Unix(1700000000, 0)}
+	if err := outbox.Enqueue(session.ID, "session-7", frame); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	outbox.Start()
+	defer outbox.Stop()
+	return waitForQueue(t, outbox)
+}
+
+func TestSignedUploadRoundTrip(t *testing.T) {
+	server, uploads := receiverServer(t, newTestVerifier())
+	if dead := queueSignedUpload(t, server.URL, NewUploadSigner(testDevice, testToken)); dead != 0 {
+		t.Fatalf("%d upload(s) dead, want 0", dead)
+	}
+
+	if len(*uploads) != 1 {
+		t.Fatalf("receiver got %d uploads, want 1", len(*uploads))
+	}
+	upload := (*uploads)[0]
+	if upload.DeviceID != testDevice || upload.SessionID != "session-7" {
+		t.Errorf("upload from %q for %q", upload.DeviceID, upload.SessionID)
+	}
+	if len(upload.Frames) != 1 || upload.Frames[0].Timestamp != "1700000000" ||
+		upload.Frames[0].Filename != "screenshot.jpg" || string(upload.Frames[0].Data) != "jpeg data" {
+		t.Errorf("frames = %+v", upload.Frames)
+	}
+}
+
+func TestSignedBatchRoundTrip(t *testing.T) {
+	server, uploads := receiverServer(t, newTestVerifier())
+	client := NewWebappClient(server.URL, NewUploadSigner(testDevice, testToken), nil)
+
+	capturedAt := time.Unix(1700000000, 0)
+	files := []uploadFile{
+		{Filename: "a.png", CapturedAt: capturedAt, Data: []byte("png-1")},
+		{Filename: "b.png", CapturedAt: capturedAt.Add(5 * time.Second), Data: []byte("png-2")},
+	}
+	if err := client.UploadScreenshots("session-7", files); err != nil {
+		t.Fatalf("UploadScreenshots: %v", err)
+	}
+
+	if len(*uploads) != 1 {
+		t.Fatalf("receiver got %d uploads, want 1", len(*uploads))
+	}
+	frames := (*uploads)[0].Frames
+	if len(frames) != 2 || string(frames[1].Data) != "png-2" || frames[1].Timestamp != "1700000005" {
+		t.Errorf("frames = %+v", frames)
+	}
+}
+
+func TestSignedUploadRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		signer *UploadSigner
+	}{
+		{"unsigned", nil},
+		{"unknown device", NewUploadSigner("lab-2", testToken)},
+		{"wrong token", NewUploadSigner(testDevice, "other-token")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, uploads := receiverServer(t, newTestVerifier())
+			if dead := queueSignedUpload(t, server.URL, tt.signer); dead != 1 {
+				t.Errorf("%d upload(s) dead, want the rejected one", dead)
+			}
+			if len(*uploads) != 0 {
+				t.Errorf("receiver stored %d uploads", len(*uploads))
+			}
+		})
+	}
+}
+
+// signedUpload builds a signed multipart upload of image. The form fields
+// can differ from the signed values to simulate tampering in transit.
+func signedUpload(t *testing.T, sessionID, timestamp string, signedImage, sentImage []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("sessionId", sessionID)
+	writer.WriteField("timestamp", timestamp)
+	part, err := writer.CreateFormFile("screenshot", "a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(sentImage)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/api/screenshots", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if err := NewUploadSigner(testDevice, testToken).Sign(req, "session-7", []string{"1700000000"}, [][]byte{signedImage}); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return req
+}
+
+func TestUploadSignerDeviceID(t *testing.T) {
+	tests := []struct {
+		name     string
+		deviceID string
+		want     string
+	}{
+		{"device_id", "lab-3", "lab-3"},
+		{"device UUID", "", testDeviceUUID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{WebappURL: "http://webapp.invalid", DeviceID: tt.deviceID, DeviceUUID: testDeviceUUID, DeviceToken: testToken}
+			_, webapp, err := NewUploadSinks(config)
+			if err != nil {
+				t.Fatalf("NewUploadSinks: %v", err)
+			}
+			if signer := webapp.UploadSink.(*WebappClient).signer; signer == nil || signer.DeviceID != tt.want {
+				t.Errorf("signer = %+v, want device %q", signer, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyUpload(t *testing.T) {
+	image := []byte("jpeg data")
+	tests := []struct {
+		name      string
+		req       func() *http.Request
+		verifier  func() *UploadVerifier
+		wantError string
+	}{
+		{
+			name: "valid",
+			req:  func() *http.Request { return signedUpload(t, "session-7", "1700000000", image, image) },
+		},
+		{
+			name:      "tampered image",
+			req:       func() *http.Request { return signedUpload(t, "session-7", "1700000000", image, []byte("other data")) },
+			wantError: "digest",
+		},
+		{
+			name:      "moved to another session",
+			req:       func() *http.Request { return signedUpload(t, "session-8", "1700000000", image, image) },
+			wantError: "invalid signature",
+		},
+		{
+			name:      "capture time changed",
+			req:       func() *http.Request { return signedUpload(t, "session-7", "1700000099", image, image) },
+			wantError: "invalid signature",
+		},
+		{
+			name: "expired",
+			req:  func() *http.Request { return signedUpload(t, "session-7", "1700000000", image, image) },
+			verifier: func() *UploadVerifier {
+				verifier := newTestVerifier()
+				verifier.now = func() time.Time { return time.Now().Add(6 * time.Minute) }
+				return verifier
+			},
+			wantError: "window",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := newTestVerifier()
+			if tt.verifier != nil {
+				verifier = tt.verifier()
+			}
+			_, err := verifier.Verify(tt.req())
+			if tt.wantError == "" && err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("Verify = %v, want an error about %s", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestVerifyUploadReplayed(t *testing.T) {
+	image := []byte("jpeg data")
+	verifier := newTestVerifier()
+	req := signedUpload(t, "session-7", "1700000000", image, image)
+
+	// The same headers on a fresh copy of the body
+	replay := signedUpload(t, "session-7", "1700000000", image, image)
+	for _, header := range []string{headerSignedAt, headerNonce, headerSignature} {
+		replay.Header.Set(header, req.Header.Get(header))
+	}
+
+	if _, err := verifier.Verify(req); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if _, err := verifier.Verify(replay); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("replay = %v, want a used nonce", err)
+	}
+}
+
+func TestSessionUpdateSigned(t *testing.T) {
+	verifier := newTestVerifier()
+	var signer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		device, _, err := verifier.VerifyRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		signer = device
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	client := NewWebappClient(server.URL, NewUploadSigner(testDevice, testToken), nil)
+	if err := client.UpdateSession(SessionUpdate{SessionID: "s", DeviceID: testDevice}); err != nil {
+		t.Fatalf("UpdateSession: %v", err)
+	}
+	if signer != testDevice {
+		t.Errorf("update signed by %q, want %q", signer, testDevice)
+	}
+}
+
+// signedRequest returns a request to the session update endpoint signed
+// with the test device's token
+func signedRequest(t *testing.T, body string) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/api/student-names", strings.NewReader(body))
+	if err := NewUploadSigner(testDevice, testToken).SignRequest(req, []byte(body)); err != nil {
+		t.Fatalf("SignRequest: %v", err)
+	}
+	return req
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := `{"sessionId":"abc","status":"active"}`
+
+	t.Run("round trip", func(t *testing.T) {
+		device, got, err := newTestVerifier().VerifyRequest(signedRequest(t, body))
+		if err != nil {
+			t.Fatalf("VerifyRequest: %v", err)
+		}
+		if device != testDevice || string(got) != body {
+			t.Errorf("VerifyRequest = %q, %q", device, got)
+		}
+	})
+
+	t.Run("tampered body", func(t *testing.T) {
+		req := signedRequest(t, body)
+		req.Body = io.NopCloser(strings.NewReader(strings.Replace(body, "active", "completed", 1)))
+		if _, _, err := newTestVerifier().VerifyRequest(req); err == nil || !strings.Contains(err.Error(), "digest") {
+			t.Errorf("VerifyRequest = %v, want a digest mismatch", err)
+		}
+	})
+
+	t.Run("tampered digest", func(t *testing.T) {
+		tampered := strings.Replace(body, "active", "completed", 1)
+		req := signedRequest(t, body)
+		req.Body = io.NopCloser(strings.NewReader(tampered))
+		req.Header.Set(headerDigest, imageDigests([][]byte{[]byte(tampered)}))
+		if _, _, err := newTestVerifier().VerifyRequest(req); err == nil || !strings.Contains(err.Error(), "invalid signature") {
+			t.Errorf("VerifyRequest = %v, want an invalid signature", err)
+		}
+	})
+
+	t.Run("other endpoint", func(t *testing.T) {
+		req := signedRequest(t, body)
+		req.URL.Path = "/api/heartbeat"
+		if _, _, err := newTestVerifier().VerifyRequest(req); err == nil {
+			t.Error("signature for one endpoint accepted at another")
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		verifier := newTestVerifier()
+		verifier.now = func() time.Time { return time.Now().Add(6 * time.Minute) }
+		if _, _, err := verifier.VerifyRequest(signedRequest(t, body)); err == nil || !strings.Contains(err.Error(), "window") {
+			t.Errorf("VerifyRequest = %v, want an expired signature", err)
+		}
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		verifier := newTestVerifier()
+		req := signedRequest(t, body)
+		replay := req.Clone(req.Context())
+		replay.Body = io.NopCloser(strings.NewReader(body))
+		if _, _, err := verifier.VerifyRequest(req); err != nil {
+			t.Fatalf("VerifyRequest: %v", err)
+		}
+		if _, _, err := verifier.VerifyRequest(replay); err == nil || !strings.Contains(err.Error(), "nonce") {
+			t.Errorf("replay = %v, want a used nonce", err)
+		}
+	})
+}
+
+// Vectors computed with the same HMAC as verify-upload.ts, so a change to
+// the signed string on either side shows up here
+func TestSignatureVectors(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields signedFields
+		want   string
+	}{
+		{
+			name: "single upload",
+			fields: signedFields{
+				DeviceID:  testDevice,
+				SessionID: "session-7",
+				Timestamp: "1699999990",
+				SignedAt:  "1700000000",
+				Nonce:     "00112233445566778899aabbccddeeff",
+				Digest:    imageDigests([][]byte{[]byte("png-1")}),
+			},
+			want: "145ff52618423d90f01dedc2f46c1b03af5fc3e6f1c6a5a75298369c53aad352",
+		},
+		{
+			name: "request",
+			fields: signedFields{
+				DeviceID:  testDevice,
+				SessionID: requestScope("POST", "/api/student-names"),
+				SignedAt:  "1700000000",
+				Nonce:     "00112233445566778899aabbccddeeff",
+				Digest:    imageDigests([][]byte{[]byte(`{"sessionId":"abc"}`)}),
+			},
+			want: "4107edf476fcb434e736f5e610b5d7ca2cbdf571f7a2bfb6f2b476606cc62ef1",
+		},
+		{
+			name: "batch upload",
+			fields: signedFields{
+				DeviceID:  testDevice,
+				SessionID: "lab-1_7_1700000000",
+				Timestamp: "1699999990,1699999995",
+				SignedAt:  "1700000000",
+				Nonce:     "00112233445566778899aabbccddeeff",
+				Digest:    imageDigests([][]byte{[]byte("png-1"), []byte("png-2")}),
+			},
+			want: "3af279ea12ddeac871576542a760c06055d3ecbbd78acaca8ca3a950536a593b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fields.sign(testToken); got != tt.want {
+				t.Errorf("signature = %s, want %s", got, tt.want)
+			}
+
+			verifier := newTestVerifier()
+			verifier.now = func() time.Time { return time.Unix(1700000000, 0) }
+			if err := verifier.check(tt.fields, tt.fields.Digest, tt.want); err != nil {
+				t.Errorf("check: %v", err)
+			}
+		})
+	}
+}
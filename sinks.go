@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UploadSink is somewhere screenshots and session details are sent: the
+// webapp, or storage such as a NAS share, an S3 bucket or a WebDAV server
+type UploadSink interface {
+	UploadScreenshots(globalSessionID string, files []uploadFile) error
+	UpdateSession(update SessionUpdate) error
+	// UploadTimelapse stores a timelapse video and returns where it can
+	// be found
+	UploadTimelapse(globalSessionID string, file uploadFile) (string, error)
+}
+
+type SinkSettings struct {
+	Name    string   `json:"name"`    // Identifies the sink in logs and the upload queue (default: type)
+	Type    string   `json:"type"`    // "webapp", "folder", "s3" or "webdav"
+	Include []string `json:"include"` // Any of "screenshots", "details", "summaries", "timelapses", "status" (empty = everything)
+
+	URL       string `json:"url"`        // webapp: base URL (default webapp_url); webdav: collection URL; s3: endpoint, e.g. http://nas:9000
+	Path      string `json:"path"`       // folder: directory or mounted share; must already exist
+	Prefix    string `json:"prefix"`     // s3, webdav: folder inside the bucket or collection
+	Bucket    string `json:"bucket"`     // s3
+	Region    string `json:"region"`     // s3 (default us-east-1)
+	AccessKey string `json:"access_key"` // s3
+	SecretKey string `json:"secret_key"` // s3
+	Username  string `json:"username"`   // webdav
+	Password  string `json:"password"`   // webdav
+}
+
+// What a sink can be sent, as named in SinkSettings.Include
+const (
+	sinkScreenshots = "screenshots"
+	sinkDetails     = "details"   // Student, description, times and completion
+	sinkSummaries   = "summaries" // Generated session summaries
+	sinkTimelapses  = "timelapses"
+	sinkStatus      = "status" // Device heartbeats
+)
+
+// uploadSink is a configured sink with its name and filter
+type uploadSink struct {
+	UploadSink
+	name    string
+	include map[string]bool // Nil accepts everything
+}
+
+func (s *uploadSink) accepts(category string) bool {
+	return s.include == nil || s.include[category]
+}
+
+// filterUpdate strips the parts of update the sink doesn't take. ok is
+// false when nothing is left to send.
+func (s *uploadSink) filterUpdate(update SessionUpdate) (filtered SessionUpdate, ok bool) {
+	filtered = SessionUpdate{SessionID: update.SessionID}
+	if s.accepts(sinkDetails) {
+		filtered.StudentName, filtered.Description, filtered.Status = update.StudentName, update.Description, update.Status
+		filtered.DeviceID = update.DeviceID
+		filtered.StartTime, filtered.EndTime, filtered.ActiveSeconds = update.StartTime, update.EndTime, update.ActiveSeconds
+	}
+	if s.accepts(sinkSummaries) {
+		filtered.Summary = update.Summary
+	}
+	if s.accepts(sinkTimelapses) {
+		filtered.Timelapse = update.Timelapse
+	}
+	return filtered, filtered != SessionUpdate{SessionID: update.SessionID}
+}
+
+// sinkSettings returns the configured sinks, adding one for webapp_url
+// when no webapp sink is listed so older configs keep uploading
+func sinkSettings(config *Config) []SinkSettings {
+	sinks := config.Sinks
+	if config.WebappURL == "" {
+		return sinks
+	}
+	for _, s := range sinks {
+		if s.Type == "webapp" {
+			return sinks
+		}
+	}
+	return append([]SinkSettings{{Name: "webapp", Type: "webapp", URL: config.WebappURL}}, sinks...)
+}
+
+func (s SinkSettings) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Type
+}
+
+// validateSinks checks each sink has a unique name, a known type, the
+// fields its type needs and known filter categories
+func validateSinks(sinks []SinkSettings) error {
+	names := make(map[string]bool)
+	for i, s := range sinks {
+		name := s.name()
+		if name == "" {
+			return fmt.Errorf("sink %d needs a type", i+1)
+		}
+		if names[name] {
+			return fmt.Errorf("sink name %q is used twice", name)
+		}
+		names[name] = true
+
+		switch s.Type {
+		case "webapp":
+		case "folder":
+			if s.Path == "" {
+				return fmt.Errorf("folder sink %q needs a path", name)
+			}
+		case "s3":
+			if s.URL == "" || s.Bucket == "" || s.AccessKey == "" || s.SecretKey == "" {
+				return fmt.Errorf("s3 sink %q needs url, bucket, access_key and secret_key", name)
+			}
+		case "webdav":
+			if s.URL == "" {
+				return fmt.Errorf("webdav sink %q needs a url", name)
+			}
+		default:
+			return fmt.Errorf("sink %q has unknown type %q", name, s.Type)
+		}
+
+		for _, category := range s.Include {
+			switch category {
+			case sinkScreenshots, sinkDetails, sinkSummaries, sinkTimelapses, sinkStatus:
+			default:
+				return fmt.Errorf("sink %q includes unknown category %q", name, category)
+			}
+		}
+	}
+	return nil
+}
+
+// NewUploadSinks builds the configured sinks. HTTP sinks share one
+// bandwidth limit. webapp is the first webapp sink, used for name sync and
+// -sync, or nil when there is none.
+func NewUploadSinks(config *Config) (sinks []*uploadSink, webapp *uploadSink, err error) {
+	settings := sinkSettings(config)
+	if err := validateSinks(settings); err != nil {
+		return nil, nil, err
+	}
+
+	var limiter *byteLimiter
+	if config.UploadOutbox.MaxBytesPerSecond > 0 {
+		limiter = newByteLimiter(config.UploadOutbox.MaxBytesPerSecond)
+	}
+	client := &http.Client{Timeout: 5 * time.Minute} // Long enough for a timelapse on a slow link
+
+	for _, s := range settings {
+		var sink UploadSink
+		switch s.Type {
+		case "webapp":
+			url := s.URL
+			if url == "" {
+				url = config.WebappURL
+			}
+			webappClient := NewWebappClient(url, NewUploadSigner(config.deviceID(), config.DeviceToken), nil)
+			webappClient.limiter = limiter
+			sink = webappClient
+		case "folder":
+			sink = newStoreSink(&folderStore{root: s.Path})
+		case "s3":
+			sink = newStoreSink(newS3Store(s, client, limiter))
+		case "webdav":
+			sink = newStoreSink(newWebDAVStore(s, client, limiter))
+		}
+
+		configured := &uploadSink{UploadSink: sink, name: s.name()}
+		if len(s.Include) > 0 {
+			configured.include = make(map[string]bool)
+			for _, category := range s.Include {
+				configured.include[category] = true
+			}
+		}
+		sinks = append(sinks, configured)
+		if webapp == nil && s.Type == "webapp" {
+			webapp = configured
+		}
+	}
+	return sinks, webapp, nil
+}
+
+// objectStore is storage addressed by slash-separated keys
+type objectStore interface {
+	put(key string, data []byte, contentType string) error
+	get(key string) ([]byte, error) // Nil data without error when the key doesn't exist
+	location(key string) string     // Where the object can be found, for reporting
+}
+
+// storeSink keeps sessions in an objectStore, one folder per session:
+//
+//	<session>/screenshots/<file>
+//	<session>/session.json      everything reported about the session
+//	<session>/<timelapse file>
+type storeSink struct {
+	store objectStore
+	mu    sync.Mutex // Serialises session.json updates
+}
+
+func newStoreSink(store objectStore) *storeSink {
+	return &storeSink{store: store}
+}
+
+func (s *storeSink) UploadScreenshots(globalSessionID string, files []uploadFile) error {
+	for _, file := range files {
+		if err := s.store.put(path.Join(globalSessionID, "screenshots", file.Filename), file.Data, "image/jpeg"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateSession merges update into the session's session.json, so it
+// ends up holding the latest value of every field reported
+func (s *storeSink) UpdateSession(update SessionUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := path.Join(update.SessionID, "session.json")
+	existing, err := s.store.get(key)
+	if err != nil {
+		return err
+	}
+	merged, err := mergeSessionUpdate(existing, update)
+	if err != nil {
+		return err
+	}
+	return s.store.put(key, merged, "application/json")
+}
+
+func (s *storeSink) UploadTimelapse(globalSessionID string, file uploadFile) (string, error) {
+	key := path.Join(globalSessionID, file.Filename)
+	if err := s.store.put(key, file.Data, "application/octet-stream"); err != nil {
+		return "", err
+	}
+	return s.store.location(key), nil
+}
+
+// mergeSessionUpdate lays the non-empty fields of update over a stored
+// session.json
+func mergeSessionUpdate(existing []byte, update SessionUpdate) ([]byte, error) {
+	var merged SessionUpdate
+	if len(existing) > 0 {
+		if err := json.Unmarshal(existing, &merged); err != nil {
+			// A damaged file is replaced rather than blocking every update
+			fmt.Printf("Warning: Replacing unreadable session.json for %s: %v\n", update.SessionID, err)
+			merged = SessionUpdate{}
+		}
+	}
+
+	merged.SessionID = update.SessionID
+	if update.StudentName != "" {
+		merged.StudentName = update.StudentName
+	}
+	if update.Description != "" {
+		merged.Description = update.Description
+	}
+	if update.Status != "" {
+		merged.Status = update.Status
+	}
+	if update.DeviceID != "" {
+		merged.DeviceID = update.DeviceID
+	}
+	if update.StartTime != nil {
+		merged.StartTime = update.StartTime
+	}
+	if update.EndTime != nil {
+		merged.EndTime = update.EndTime
+	}
+	if update.ActiveSeconds != 0 {
+		merged.ActiveSeconds = update.ActiveSeconds
+	}
+	if update.Summary != "" {
+		merged.Summary = update.Summary
+	}
+	if update.Timelapse != nil {
+		merged.Timelapse = update.Timelapse
+	}
+	return json.MarshalIndent(merged, "", "  ")
+}
+
+// folderStore writes to a local directory or mounted network share
+type folderStore struct {
+	root string
+}
+
+func (f *folderStore) put(key string, data []byte, contentType string) error {
+	// An unmounted share must not be silently replaced by a local folder,
+	// so the root is never created here
+	if _, err := os.Stat(f.root); err != nil {
+		return fmt.Errorf("folder %s is not available: %w", f.root, err)
+	}
+
+	target := f.location(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Write then rename so readers of the share never see a partial file
+	tmp := target + ".partial"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+func (f *folderStore) get(key string) ([]byte, error) {
+	data, err := os.ReadFile(f.location(key))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f *folderStore) location(key string) string {
+	return filepath.Join(f.root, filepath.FromSlash(key))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFolderSink(t *testing.T) {
+	root := t.TempDir()
+	sink := newStoreSink(&folderStore{root: root})
+
+	files := []uploadFile{{Filename: "a.jpg", Data: []byte("jpeg a")}, {Filename: "b.jpg", Data: []byte("jpeg b")}}
+	if err := sink.UploadScreenshots("lab-1_7", files); err != nil {
+		t.Fatalf("UploadScreenshots: %v", err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(root, "lab-1_7", "screenshots", file.Filename))
+		if err != nil || string(data) != string(file.Data) {
+			t.Errorf("%s = %q, %v", file.Filename, data, err)
+		}
+	}
+
+	// Updates are merged into session.json field by field
+	start := time.Unix(1700000000, 0).UTC()
+	updates := []SessionUpdate{
+		{SessionID: "lab-1_7", StudentName: "Ada", Status: "active", StartTime: &start},
+		{SessionID: "lab-1_7", Status: "completed", Summary: "Worked on fractions"},
+	}
+	for _, update := range updates {
+		if err := sink.UpdateSession(update); err != nil {
+			t.Fatalf("UpdateSession: %v", err)
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(root, "lab-1_7", "session.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored SessionUpdate
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.StudentName != "Ada" || stored.Status != "completed" || stored.Summary != "Worked on fractions" ||
+		stored.StartTime == nil || !stored.StartTime.Equal(start) {
+		t.Errorf("session.json = %s", data)
+	}
+
+	location, err := sink.UploadTimelapse("lab-1_7", uploadFile{Filename: "session_timelapse.mp4", Data: []byte("video")})
+	if err != nil || location != filepath.Join(root, "lab-1_7", "session_timelapse.mp4") {
+		t.Errorf("UploadTimelapse = %q, %v", location, err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(root, "lab-1_7", "*.partial")); len(leftovers) != 0 {
+		t.Errorf("partial files left behind: %v", leftovers)
+	}
+}
+
+func TestFolderSinkUnmountedShare(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "share")
+	sink := newStoreSink(&folderStore{root: root})
+	if err := sink.UploadScreenshots("s", []uploadFile{{Filename: "a.jpg", Data: []byte("jpeg")}}); err == nil {
+		t.Fatal("wrote to a share that isn't there")
+	}
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("created the missing share folder (%v)", err)
+	}
+}
+
+func TestSinkFilterUpdate(t *testing.T) {
+	update := SessionUpdate{SessionID: "s", StudentName: "Ada", Status: "completed", Summary: "Fractions"}
+
+	sink := &uploadSink{include: map[string]bool{sinkSummaries: true}}
+	filtered, ok := sink.filterUpdate(update)
+	if !ok || filtered != (SessionUpdate{SessionID: "s", Summary: "Fractions"}) {
+		t.Errorf("summaries only: %+v, %v", filtered, ok)
+	}
+
+	sink = &uploadSink{include: map[string]bool{sinkTimelapses: true}}
+	if _, ok := sink.filterUpdate(update); ok {
+		t.Error("timelapse sink was sent a details and summary update")
+	}
+
+	sink = &uploadSink{}
+	if filtered, ok := sink.filterUpdate(update); !ok || filtered != update {
+		t.Errorf("unfiltered sink: %+v, %v", filtered, ok)
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"fmt"
+	"image"
+	"image/jpeg"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 type TimelapseGenerator struct {
@@ -44,7 +47,7 @@ func NewTimelapseGenerator() *TimelapseGenerator {
 
 	return &TimelapseGenerator{
 		ffmpegPath: ffmpegPath,
-		fps:        2,        // Default 2 fps for timelapse
+		fps:        2, // Default 2 fps for timelapse
 		quality:    "medium",
 	}
 }
@@ -64,7 +67,10 @@ func (tg *TimelapseGenerator) GenerateTimelapse(screenshots []Screenshot, output
 	}
 
 	// Sort screenshots by timestamp
-	sort.Slice(screenshots, func(i, j int) bool {
+	sort.SliceStable(screenshots, func(i, j int) bool {
+		if screenshots[i].Timestamp.Equal(screenshots[j].Timestamp) {
+			return screenshots[i].DisplayIndex < screenshots[j].DisplayIndex
+		}
 		return screenshots[i].Timestamp.Before(screenshots[j].Timestamp)
 	})
 
@@ -75,14 +81,23 @@ func (tg *TimelapseGenerator) GenerateTimelapse(screenshots []Screenshot, output
 	}
 	defer os.RemoveAll(tempDir) // Clean up temp directory
 
-	// Copy and rename screenshots to sequential format required by FFmpeg
-	fmt.Printf("Preparing %d screenshots for timelapse...\n", len(screenshots))
-	for i, screenshot := range screenshots {
-		srcPath := screenshot.FilePath
+	// Copy and rename screenshots to sequential format required by FFmpeg.
+	// Multi-display ticks become a single side-by-side frame. Duplicate rows
+	// point at the earlier file, so idle stretches hold that frame.
+	ticks := groupScreenshotsByTick(screenshots)
+	fmt.Printf("Preparing %d frames from %d screenshots for timelapse...\n", len(ticks), len(screenshots))
+	for i, tick := range ticks {
 		dstPath := filepath.Join(tempDir, fmt.Sprintf("frame_%04d.jpg", i+1))
 
-		if err := tg.copyFile(srcPath, dstPath); err != nil {
-			return fmt.Errorf("failed to copy screenshot %d: %w", i+1, err)
+		if len(tick) == 1 {
+			if err := tg.copyFile(tick[0].FilePath, dstPath); err != nil {
+				return fmt.Errorf("failed to copy screenshot %d: %w", i+1, err)
+			}
+			continue
+		}
+
+		if err := tg.composeTick(tick, dstPath); err != nil {
+			return fmt.Errorf("failed to compose frame %d: %w", i+1, err)
 		}
 	}
 
@@ -132,6 +147,27 @@ func (tg *TimelapseGenerator) GenerateTimelapse(screenshots []Screenshot, output
 	return nil
 }
 
+// composeTick stitches the per-display screenshots of one tick into a
+// single frame
+func (tg *TimelapseGenerator) composeTick(tick []Screenshot, dst string) error {
+	var images []image.Image
+	for _, s := range tick {
+		img, err := loadRGBA(s.FilePath)
+		if err != nil {
+			return err
+		}
+		images = append(images, img)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return jpeg.Encode(file, stitchSideBySide(images), &jpeg.Options{Quality: 90})
+}
+
 func (tg *TimelapseGenerator) copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -165,17 +201,38 @@ func (tg *TimelapseGenerator) copyFile(src, dst string) error {
 	return destFile.Sync()
 }
 
-func (tg *TimelapseGenerator) GetTimelapseInfo(screenshots []Screenshot, settings TimelapseSettings) string {
+// GetTimelapseInfo describes the timelapse; the time covered excludes pauses
+func (tg *TimelapseGenerator) GetTimelapseInfo(screenshots []Screenshot, settings TimelapseSettings, pauses []SessionPause) string {
 	if len(screenshots) < 2 {
 		return "Not enough screenshots for timelapse"
 	}
 
-	duration := screenshots[len(screenshots)-1].Timestamp.Sub(screenshots[0].Timestamp)
-	videoLengthSeconds := float64(len(screenshots)) / float64(settings.FPS)
+	ticks := groupScreenshotsByTick(screenshots)
+	start, end := screenshots[0].Timestamp, screenshots[len(screenshots)-1].Timestamp
+	duration := activeDuration(start, end, pauses)
+	videoLengthSeconds := float64(len(ticks)) / float64(settings.FPS)
 
-	return fmt.Sprintf("Timelapse: %d screenshots over %v compressed into %.1f seconds at %d fps",
-		len(screenshots),
+	info := fmt.Sprintf("Timelapse: %d screenshots over %v compressed into %.1f seconds at %d fps",
+		len(ticks),
 		duration.Round(1),
 		videoLengthSeconds,
 		settings.FPS)
-}
\ No newline at end of file
+	if len(ticks) != len(screenshots) {
+		info += fmt.Sprintf(" (%d display captures shown side by side)", len(screenshots))
+	}
+
+	if paused := pausedDuration(start, end, pauses); paused > 0 {
+		info += fmt.Sprintf("\n%s of pauses left out", paused.Round(time.Second))
+	}
+
+	duplicates := 0
+	for _, s := range screenshots {
+		if s.IsDuplicate() {
+			duplicates++
+		}
+	}
+	if duplicates > 0 {
+		info += fmt.Sprintf("\n%d captures were unchanged and reuse the previous frame", duplicates)
+	}
+	return info
+}
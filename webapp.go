@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// WebappClient speaks the webapp's HTTP API: screenshots go to
+// /api/screenshots, timelapse videos to /api/timelapses and session
+// details to /api/student-names. It is the "webapp" UploadSink; callers
+// normally go through UploadOutbox so requests survive being offline.
+type WebappClient struct {
+	baseURL string
+	client  *http.Client
+	signer  *UploadSigner // Nil sends screenshots unsigned
+	limiter *byteLimiter  // Nil leaves bandwidth unlimited
+}
+
+// uploadFile is one screenshot in an upload request
+type uploadFile struct {
+	Filename   string
+	CapturedAt time.Time
+	Data       []byte
+}
+
+// SessionUpdate reports part of a session's state to the webapp. Empty
+// fields are left unchanged there, so updates can arrive in any order
+// without undoing each other.
+type SessionUpdate struct {
+	SessionID     string             `json:"sessionId"`
+	DeviceID      string             `json:"deviceId,omitempty"` // Machine running the session, for remote commands
+	StudentName   string             `json:"studentName,omitempty"`
+	Description   string             `json:"description,omitempty"`
+	Status        string             `json:"status,omitempty"` // Only ever "completed"; new sessions show as active
+	StartTime     *time.Time         `json:"startTime,omitempty"`
+	EndTime       *time.Time         `json:"endTime,omitempty"`
+	ActiveSeconds int64              `json:"activeSeconds,omitempty"` // Session time excluding pauses
+	Summary       string             `json:"summary,omitempty"`
+	Timelapse     *TimelapseMetadata `json:"timelapse,omitempty"`
+}
+
+// TimelapseMetadata describes a timelapse video kept on this machine
+type TimelapseMetadata struct {
+	File           string  `json:"file"`
+	URL            string  `json:"url,omitempty"` // Set once the video itself has been uploaded
+	Format         string  `json:"format"`
+	FPS            int     `json:"fps"`
+	Frames         int     `json:"frames"`
+	LengthSeconds  float64 `json:"lengthSeconds"`  // Length of the video
+	CoveredSeconds int64   `json:"coveredSeconds"` // Active session time the video spans
+}
+
+// NewWebappClient creates a client for the webapp at baseURL. A nil
+// client uses a default with a 30 second timeout.
+func NewWebappClient(baseURL string, signer *UploadSigner, client *http.Client) *WebappClient {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &WebappClient{baseURL: baseURL, client: client, signer: signer}
+}
+
+// sessionStartedUpdate announces a new session with its student name and
+// description
+func sessionStartedUpdate(session *Session) SessionUpdate {
+	start := session.StartTime
+	return SessionUpdate{
+		SessionID:   session.GlobalID(),
+		StudentName: session.StudentName,
+		Description: session.Description,
+		StartTime:   &start,
+	}
+}
+
+// sessionStoppedUpdate marks a session completed
+func sessionStoppedUpdate(session *Session, pauses []SessionPause) SessionUpdate {
+	start, end := session.StartTime, session.EndTime
+	return SessionUpdate{
+		SessionID:     session.GlobalID(),
+		Status:        "completed",
+		StartTime:     &start,
+		EndTime:       &end,
+		ActiveSeconds: int64(activeDuration(start, end, pauses).Seconds()),
+	}
+}
+
+// UploadScreenshots posts one or more screenshots from a session in a
+// single request. Each file is paired with a timestamp field, in order,
+// giving when it was captured rather than when it was uploaded.
+func (c *WebappClient) UploadScreenshots(globalSessionID string, files []uploadFile) error {
+	// Create multipart form
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	if err := writer.WriteField("sessionId", globalSessionID); err != nil {
+		return fmt.Errorf("failed to write sessionId field: %w", err)
+	}
+
+	var timestamps []string
+	var images [][]byte
+	for _, file := range files {
+		timestamp := strconv.FormatInt(file.CapturedAt.Unix(), 10)
+		if err := writer.WriteField("timestamp", timestamp); err != nil {
+			return fmt.Errorf("failed to write timestamp field: %w", err)
+		}
+		part, err := writer.CreateFormFile("screenshot", file.Filename)
+		if err != nil {
+			return fmt.Errorf("failed to create form file: %w", err)
+		}
+		if _, err := part.Write(file.Data); err != nil {
+			return fmt.Errorf("failed to copy image data: %w", err)
+		}
+		timestamps = append(timestamps, timestamp)
+		images = append(images, file.Data)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/screenshots", &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if c.signer != nil {
+		if err := c.signer.Sign(req, globalSessionID, timestamps, images); err != nil {
+			return err
+		}
+	}
+	return c.do(req, nil)
+}
+
+// ListScreenshots returns the names of the screenshots the webapp holds
+// for a session, as stored: "<unix timestamp>-<filename>", possibly with a
+// suffix added by blob storage before the extension
+func (c *WebappClient) ListScreenshots(globalSessionID string) ([]string, error) {
+	req, err := http.NewRequest("GET", c.baseURL+"/api/screenshots?sessionId="+url.QueryEscape(globalSessionID), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	var result struct {
+		Files []string `json:"files"`
+	}
+	if err := c.do(req, &result); err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// UploadTimelapse posts a session's timelapse video to /api/timelapses,
+// signed like screenshots with the session end as its timestamp, and
+// returns the URL the webapp stored it at
+func (c *WebappClient) UploadTimelapse(globalSessionID string, file uploadFile) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	timestamp := strconv.FormatInt(file.CapturedAt.Unix(), 10)
+	if err := writer.WriteField("sessionId", globalSessionID); err != nil {
+		return "", fmt.Errorf("failed to write sessionId field: %w", err)
+	}
+	if err := writer.WriteField("timestamp", timestamp); err != nil {
+		return "", fmt.Errorf("failed to write timestamp field: %w", err)
+	}
+	part, err := writer.CreateFormFile("timelapse", file.Filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(file.Data); err != nil {
+		return "", fmt.Errorf("failed to copy video data: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/timelapses", &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if c.signer != nil {
+		if err := c.signer.Sign(req, globalSessionID, []string{timestamp}, [][]byte{file.Data}); err != nil {
+			return "", err
+		}
+	}
+	var result struct {
+		URL string `json:"url"`
+	}
+	if err := c.do(req, &result); err != nil {
+		return "", err
+	}
+	return result.URL, nil
+}
+
+// UpdateSession posts session details to /api/student-names, signed when
+// there is a device token. The webapp only accepts a DeviceID from a
+// signed update.
+func (c *WebappClient) UpdateSession(update SessionUpdate) error {
+	body, err := json.Marshal(update)
+	if err != nil {
+		return fmt.Errorf("failed to encode session update: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", c.baseURL+"/api/student-names", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.signer != nil {
+		if err := c.signer.SignRequest(req, body); err != nil {
+			return err
+		}
+	}
+	return c.do(req, nil)
+}
+
+// do sends req, turning non-2xx responses into an uploadError. A non-nil
+// result is filled from the JSON response.
+func (c *WebappClient) do(req *http.Request, result interface{}) error {
+	body, err := sendRequest(c.client, c.limiter, req)
+	if err != nil {
+		return err
+	}
+	if result != nil {
+		if err := json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// sendRequest sends req, pacing its body through limiter when there is
+// one, and returns the response body. Non-2xx responses become an
+// uploadError.
+//
+// A body that would take longer than throttleBudget to pace is sent at
+// full speed instead: it would otherwise outlive the client timeout or
+// its signature. Its bytes are still taken from the limiter, so the
+// uploads after it wait to make up for them.
+func sendRequest(client *http.Client, limiter *byteLimiter, req *http.Request) ([]byte, error) {
+	if limiter != nil && req.Body != nil {
+		if req.ContentLength > 0 && limiter.delay(int(req.ContentLength)) > throttleBudget(client) {
+			limiter.take(int(req.ContentLength))
+		} else {
+			// ContentLength is already set, so the body is still sent unchunked
+			req.Body = &throttledReader{r: req.Body, limiter: limiter}
+			req.GetBody = nil
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &uploadError{status: resp.StatusCode, body: string(bodyBytes)}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// throttleBudget is how long a paced body may take to send: half of the
+// signature window or client timeout, whichever is shorter, leaving the
+// rest for the server to respond
+func throttleBudget(client *http.Client) time.Duration {
+	budget := signatureWindow
+	if client.Timeout > 0 && client.Timeout < budget {
+		budget = client.Timeout
+	}
+	return budget / 2
+}
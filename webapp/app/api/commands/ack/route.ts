@@ -0,0 +1,47 @@
+import { NextRequest, NextResponse } from 'next/server'
+import { commands, loadCommands, saveCommands } from '../commands-store'
+import { verifyDeviceRequest } from '../../screenshots/verify-upload'
+
+// The Go application reports whether a command was applied, signed like
+// its polls; a device can only acknowledge its own commands
+export async function POST(request: NextRequest) {
+  try {
+    const body = await request.text()
+    const verified = verifyDeviceRequest(request.headers, 'POST', request.nextUrl.pathname, body)
+    if ('error' in verified) {
+      console.warn('Rejected command acknowledgement:', verified.error)
+      return NextResponse.json({ error: verified.error }, { status: 401 })
+    }
+
+    const { deviceId, id, status, error } = JSON.parse(body)
+    if (deviceId !== verified.device) {
+      return NextResponse.json({ error: 'Signed by a different device' }, { status: 403 })
+    }
+
+    await loadCommands()
+    const command = commands.find(c => c.id === id && c.deviceId === deviceId)
+    if (!command) {
+      return NextResponse.json({ error: 'Unknown command' }, { status: 404 })
+    }
+    if (status !== 'done' && status !== 'failed') {
+      return NextResponse.json({ error: 'status must be done or failed' }, { status: 400 })
+    }
+
+    // Repeated acknowledgements just confirm the first one
+    if (command.status === 'pending') {
+      command.status = status
+      command.error = error || undefined
+      command.ackedAt = new Date().toISOString()
+      await saveCommands()
+      console.log('Command acknowledged:', deviceId, command.type, '→', status, error || '')
+    }
+
+    return NextResponse.json({ success: true })
+  } catch (error) {
+    console.error('Error acknowledging command:', error)
+    return NextResponse.json(
+      { error: 'Failed to acknowledge command' },
+      { status: 500 }
+    )
+  }
+}
@@ -0,0 +1,63 @@
+import { put } from '@vercel/blob'
+
+// Commands the dashboard has queued for the Go application, which polls
+// for its device's pending commands and acknowledges each one
+export const commandTypes = ['stop', 'pause', 'resume', 'interval', 'capture_now', 'set_student_name'] as const
+
+export interface RemoteCommand {
+  id: string
+  deviceId: string
+  type: typeof commandTypes[number]
+  interval?: number
+  studentName?: string
+  reason?: string
+  createdAt: string
+  status: 'pending' | 'done' | 'failed'
+  error?: string
+  ackedAt?: string
+}
+
+// In-memory cache (populated from blob storage)
+export let commands: RemoteCommand[] = []
+let dataLoaded = false
+
+// Load commands from the newest commands file in blob storage
+export async function loadCommands() {
+  if (dataLoaded || !process.env.BLOB_READ_WRITE_TOKEN) return
+
+  try {
+    const { list } = await import('@vercel/blob')
+    const { blobs } = await list({
+      prefix: 'commands-',
+      token: process.env.BLOB_READ_WRITE_TOKEN,
+    })
+
+    if (blobs.length > 0) {
+      const latestBlob = blobs.sort((a, b) => new Date(b.uploadedAt).getTime() - new Date(a.uploadedAt).getTime())[0]
+      const response = await fetch(latestBlob.url)
+      if (response.ok) {
+        const data = await response.json()
+        commands = data.commands || []
+      }
+    }
+  } catch (error) {
+    console.log('Error loading commands:', error, '- starting fresh')
+  }
+
+  dataLoaded = true
+}
+
+// Save commands to blob storage
+export async function saveCommands() {
+  if (!process.env.BLOB_READ_WRITE_TOKEN) return
+
+  try {
+    const data = { commands, lastUpdated: new Date().toISOString() }
+    await put(`commands-${Date.now()}.json`, JSON.stringify(data, null, 2), {
+      access: 'public',
+      token: process.env.BLOB_READ_WRITE_TOKEN,
+    })
+  } catch (error) {
+    console.error('Failed to save commands:', error)
+  }
+}
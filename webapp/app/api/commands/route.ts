@@ -0,0 +1,79 @@
+import { NextRequest, NextResponse } from 'next/server'
+import { randomUUID } from 'crypto'
+import { commands, commandTypes, loadCommands, saveCommands, type RemoteCommand } from './commands-store'
+import { verifyDeviceRequest } from '../screenshots/verify-upload'
+import { verifyDashboard } from '../dashboard-auth'
+
+// Pending commands for one device, oldest first. Only the device itself
+// may read them, signed with its device token.
+export async function GET(request: NextRequest) {
+  const verified = verifyDeviceRequest(request.headers, 'GET', request.nextUrl.pathname, '')
+  if ('error' in verified) {
+    console.warn('Rejected command poll:', verified.error)
+    return NextResponse.json({ error: verified.error }, { status: 401 })
+  }
+
+  const deviceId = request.nextUrl.searchParams.get('deviceId')
+  if (!deviceId) {
+    return NextResponse.json({ error: 'Missing deviceId' }, { status: 400 })
+  }
+  if (deviceId !== verified.device) {
+    return NextResponse.json({ error: 'Signed by a different device' }, { status: 403 })
+  }
+
+  await loadCommands()
+
+  const pending = commands
+    .filter(c => c.deviceId === deviceId && c.status === 'pending')
+    .map(({ id, type, interval, studentName, reason }) => ({ id, type, interval, studentName, reason }))
+  return NextResponse.json({ commands: pending })
+}
+
+// Queue a command from the dashboard
+export async function POST(request: NextRequest) {
+  const authError = verifyDashboard(request.headers)
+  if (authError) {
+    return NextResponse.json({ error: authError }, { status: 401 })
+  }
+
+  try {
+    await loadCommands()
+
+    const { deviceId, type, interval, studentName, reason } = await request.json()
+
+    if (!deviceId || !commandTypes.includes(type)) {
+      return NextResponse.json(
+        { error: 'Missing deviceId or unknown command type' },
+        { status: 400 }
+      )
+    }
+    if (type === 'interval' && !(Number.isInteger(interval) && interval >= 1)) {
+      return NextResponse.json({ error: 'interval must be a whole number of seconds' }, { status: 400 })
+    }
+    if (type === 'set_student_name' && !studentName) {
+      return NextResponse.json({ error: 'Missing studentName' }, { status: 400 })
+    }
+
+    const command: RemoteCommand = {
+      id: randomUUID(),
+      deviceId,
+      type,
+      interval: type === 'interval' ? interval : undefined,
+      studentName: type === 'set_student_name' ? studentName : undefined,
+      reason: type === 'pause' ? reason : undefined,
+      createdAt: new Date().toISOString(),
+      status: 'pending',
+    }
+    commands.push(command)
+    await saveCommands()
+    console.log('Queued command:', deviceId, '→', type)
+
+    return NextResponse.json({ success: true, command })
+  } catch (error) {
+    console.error('Error queueing command:', error)
+    return NextResponse.json(
+      { error: 'Failed to queue command' },
+      { status: 500 }
+    )
+  }
+}
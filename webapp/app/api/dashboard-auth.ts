@@ -0,0 +1,18 @@
+import { timingSafeEqual } from 'crypto'
+
+// Dashboard actions that control devices need the DASHBOARD_TOKEN as a
+// bearer token. Without it set they are refused, not left open.
+export function verifyDashboard(headers: Headers): string | null {
+  const token = process.env.DASHBOARD_TOKEN
+  if (!token) {
+    return 'Dashboard token is not configured (DASHBOARD_TOKEN)'
+  }
+
+  const auth = headers.get('authorization') || ''
+  const given = Buffer.from(auth.startsWith('Bearer ') ? auth.slice('Bearer '.length) : '')
+  const expected = Buffer.from(token)
+  if (given.length !== expected.length || !timingSafeEqual(given, expected)) {
+    return 'Invalid dashboard token'
+  }
+  return null
+}
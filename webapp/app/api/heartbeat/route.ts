@@ -0,0 +1,85 @@
+import { NextRequest, NextResponse } from 'next/server'
+import { put, list } from '@vercel/blob'
+import { verifyDeviceRequestIfConfigured } from '../screenshots/verify-upload'
+
+// Latest heartbeat from each machine running the Go application, kept as
+// devices/<deviceUuid>.json so every install overwrites its own file, even
+// when several share a device ID or have none configured
+interface DeviceStatus {
+  deviceId: string
+  deviceUuid: string
+  hostname: string
+  state: 'capturing' | 'paused' | 'idle' | 'stopped'
+  updatedAt: string
+  intervalSeconds: number
+  sessionId?: string
+  screenshots: number
+  lastCaptureAt?: string
+  lastCaptureError?: string
+  lastCaptureErrorAt?: string
+  uploadsPending: number
+  uploadsFailed: number
+  diskFreeBytes: number
+  diskError?: string
+  ffmpeg: boolean
+}
+
+export async function POST(request: NextRequest) {
+  try {
+    // Signed like uploads once UPLOAD_DEVICE_TOKENS is set
+    const body = await request.text()
+    const verified = verifyDeviceRequestIfConfigured(request.headers, 'POST', request.nextUrl.pathname, body)
+    if ('error' in verified) {
+      console.warn('Rejected heartbeat:', verified.error)
+      return NextResponse.json({ error: verified.error }, { status: 401 })
+    }
+
+    const status: DeviceStatus = JSON.parse(body)
+    if (!status.deviceId || !status.deviceUuid) {
+      return NextResponse.json({ error: 'Missing deviceId or deviceUuid' }, { status: 400 })
+    }
+    if (verified.device !== null && status.deviceId !== verified.device) {
+      return NextResponse.json({ error: 'Signed by a different device' }, { status: 403 })
+    }
+    if (!process.env.BLOB_READ_WRITE_TOKEN) {
+      return NextResponse.json({ error: 'Storage not configured' }, { status: 500 })
+    }
+
+    // Judge staleness by when the heartbeat arrived, not the device clock
+    const received = { ...status, receivedAt: new Date().toISOString() }
+    await put(`devices/${encodeURIComponent(status.deviceUuid)}.json`, JSON.stringify(received, null, 2), {
+      access: 'public',
+      addRandomSuffix: false,
+      token: process.env.BLOB_READ_WRITE_TOKEN,
+    })
+
+    return NextResponse.json({ success: true })
+  } catch (error) {
+    console.error('Error storing heartbeat:', error)
+    return NextResponse.json(
+      { error: 'Failed to store heartbeat' },
+      { status: 500 }
+    )
+  }
+}
+
+export async function GET() {
+  if (!process.env.BLOB_READ_WRITE_TOKEN) {
+    return NextResponse.json([])
+  }
+
+  try {
+    const { blobs } = await list({
+      prefix: 'devices/',
+      token: process.env.BLOB_READ_WRITE_TOKEN,
+    })
+    const devices = await Promise.all(blobs.map(async blob => {
+      const response = await fetch(blob.url, { cache: 'no-store' })
+      return response.ok ? response.json() : null
+    }))
+    return NextResponse.json(devices.filter(Boolean))
+  } catch (error) {
+    console.error('Error listing devices:', error)
+    return NextResponse.json([])
+  }
+}
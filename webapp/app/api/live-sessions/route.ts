@@ -10,7 +10,7 @@ export async function GET() {
 
     // Get student names, session status, and summaries
     // Use relative URL for internal API calls on Vercel
-    let studentData = { names: {}, status: {}, summaries: {} }
+    let studentData = { names: {}, status: {}, summaries: {}, details: {} }
     try {
       const baseUrl = process.env.VERCEL_URL
         ? `https://${process.env.VERCEL_URL}`
@@ -31,6 +31,7 @@ export async function GET() {
     const studentNames: Record<string, string> = studentData.names || {}
     const sessionStatus: Record<string, string> = studentData.status || {}
     const sessionSummaries: Record<string, string> = studentData.summaries || {}
+    const sessionDetails: Record<string, any> = studentData.details || {}
 
     console.log('Session status data:', sessionStatus)
     console.log('Session summaries data:', Object.keys(sessionSummaries))
@@ -77,28 +78,35 @@ export async function GET() {
 
           console.log(`Session ${sessionId} - status:`, status, 'hasSummary:', !!summary)
 
+          const details = sessionDetails[sessionId] || {}
           sessionMap.set(sessionId, {
             id: sessionId,
             studentName: studentNames[sessionId] || 'Unknown Student',
-            startTime: new Date(blob.uploadedAt).toISOString(),
+            startTime: details.startTime || new Date(blob.uploadedAt).toISOString(),
+            endTime: details.endTime,
+            description: details.description,
+            activeSeconds: details.activeSeconds,
+            timelapse: details.timelapse,
+            deviceId: details.deviceId,
             status: status,
             screenshots: [],
-            summary: summary
+            summary: summary,
+            reportedStart: !!details.startTime
           })
         }
 
         const session = sessionMap.get(sessionId)
         session.screenshots.push(blob.url)
 
-        // Update start time to earliest screenshot
-        if (new Date(blob.uploadedAt) < new Date(session.startTime)) {
+        // Update start time to earliest screenshot unless the app reported it
+        if (!session.reportedStart && new Date(blob.uploadedAt) < new Date(session.startTime)) {
           session.startTime = new Date(blob.uploadedAt).toISOString()
         }
       }
     }
 
     // Sort sessions by start time (newest first)
-    const sessions = Array.from(sessionMap.values()).sort((a, b) =>
+    const sessions = Array.from(sessionMap.values()).map(({ reportedStart, ...session }) => session).sort((a, b) =>
       new Date(b.startTime).getTime() - new Date(a.startTime).getTime()
     )
 
@@ -1,21 +1,32 @@
 import { NextRequest, NextResponse } from 'next/server'
-import { put } from '@vercel/blob'
+import { put, list, type ListBlobResult } from '@vercel/blob'
 import { sessions, createSession } from './sessions-store'
+import { verifyUpload } from './verify-upload'
 
 export async function POST(request: NextRequest) {
   try {
     const formData = await request.formData()
-    const file = formData.get('screenshot') as File
+    // Several screenshots may arrive in one request, each paired with the
+    // timestamp field at the same position
+    const files = formData.getAll('screenshot') as File[]
+    const timestamps = formData.getAll('timestamp') as string[]
     const sessionId = formData.get('sessionId') as string
-    const timestamp = formData.get('timestamp') as string
 
-    if (!file || !sessionId) {
+    if (files.length === 0 || !sessionId) {
       return NextResponse.json(
         { error: 'Missing required fields' },
         { status: 400 }
       )
     }
 
+    // Reject unsigned or tampered uploads when device tokens are configured
+    const images = await Promise.all(files.map(async file => Buffer.from(await file.arrayBuffer())))
+    const authError = verifyUpload(request.headers, sessionId, timestamps, images)
+    if (authError) {
+      console.warn('Rejected screenshot upload:', authError)
+      return NextResponse.json({ error: authError }, { status: 401 })
+    }
+
     // Check if blob token exists
     if (!process.env.BLOB_READ_WRITE_TOKEN) {
       console.error('BLOB_READ_WRITE_TOKEN not found')
@@ -25,32 +36,38 @@ export async function POST(request: NextRequest) {
       )
     }
 
-    // Store in Vercel Blob
-    const blobPath = `screenshots/${sessionId}/${timestamp}-${file.name}`
-    console.log('Uploading to blob path:', blobPath)
-
-    const blob = await put(blobPath, file, {
-      access: 'public',
-      token: process.env.BLOB_READ_WRITE_TOKEN,
-    })
-
-    console.log('Screenshot uploaded successfully:', {
-      sessionId,
-      timestamp,
-      blobPath,
-      blobUrl: blob.url,
-      blobPathname: blob.pathname,
-      fileSize: file.size,
-    })
-
     // Update or create session in the sessions store
     let session = sessions.find(s => s.id === sessionId)
     if (!session) {
       session = createSession(sessionId)
     }
 
-    // Add screenshot URL to session
-    session.screenshots.push(blob.url)
+    const urls: string[] = []
+    for (const [i, file] of files.entries()) {
+      const timestamp = timestamps[i] || ''
+
+      // Store in Vercel Blob
+      const blobPath = `screenshots/${sessionId}/${timestamp}-${file.name}`
+      console.log('Uploading to blob path:', blobPath)
+
+      const blob = await put(blobPath, images[i], {
+        access: 'public',
+        token: process.env.BLOB_READ_WRITE_TOKEN,
+      })
+
+      console.log('Screenshot uploaded successfully:', {
+        sessionId,
+        timestamp,
+        blobPath,
+        blobUrl: blob.url,
+        blobPathname: blob.pathname,
+        fileSize: file.size,
+      })
+
+      // Add screenshot URL to session
+      session.screenshots.push(blob.url)
+      urls.push(blob.url)
+    }
 
     // Update start time to earliest if this is an earlier screenshot
     const screenshotTime = new Date().toISOString()
@@ -58,7 +75,7 @@ export async function POST(request: NextRequest) {
       session.startTime = screenshotTime
     }
 
-    return NextResponse.json({ success: true, url: blob.url })
+    return NextResponse.json({ success: true, url: urls[0], urls })
   } catch (error) {
     console.error('Error uploading screenshot:', error)
     return NextResponse.json(
@@ -66,4 +83,41 @@ export async function POST(request: NextRequest) {
       { status: 500 }
     )
   }
-}
\ No newline at end of file
+}
+
+// GET /api/screenshots?sessionId=... lists the screenshots stored for a
+// session, so the Go application's -sync can skip ones already uploaded
+export async function GET(request: NextRequest) {
+  const sessionId = request.nextUrl.searchParams.get('sessionId')
+  if (!sessionId) {
+    return NextResponse.json({ error: 'Missing sessionId' }, { status: 400 })
+  }
+  if (!process.env.BLOB_READ_WRITE_TOKEN) {
+    return NextResponse.json({ error: 'Storage not configured' }, { status: 500 })
+  }
+
+  try {
+    const prefix = `screenshots/${sessionId}/`
+    const files: string[] = []
+    let cursor: string | undefined = undefined
+    do {
+      const response: ListBlobResult = await list({
+        prefix,
+        token: process.env.BLOB_READ_WRITE_TOKEN,
+        cursor,
+      })
+      for (const blob of response.blobs) {
+        files.push(blob.pathname.slice(prefix.length))
+      }
+      cursor = response.cursor
+    } while (cursor)
+
+    return NextResponse.json({ files })
+  } catch (error) {
+    console.error('Error listing screenshots:', error)
+    return NextResponse.json(
+      { error: 'Failed to list screenshots' },
+      { status: 500 }
+    )
+  }
+}
@@ -0,0 +1,135 @@
+import { createHash, createHmac, timingSafeEqual } from 'crypto'
+
+// Signed uploads from the Go application carry these headers. The
+// signature is an HMAC-SHA256, keyed by the device token, over
+//   v1\n<device>\n<sessionId>\n<timestamps>\n<signed at>\n<nonce>\n<sha256 of images>
+// where batches join the timestamps and hex image digests with commas, in
+// form order. Other device requests (command polls and acknowledgements,
+// heartbeats) are signed the same way with "<METHOD> <path>" as the
+// session ID, no timestamps and the SHA-256 of the body as the digest. It
+// must match signing.go in the Go application.
+const SIGNATURE_VERSION = 'v1'
+const WINDOW_SECONDS = 5 * 60
+
+// Nonces seen within the window. This is per instance, so the window is
+// what bounds replays across serverless instances.
+const seenNonces = new Map<string, number>()
+
+// Device tokens come from UPLOAD_DEVICE_TOKENS as "device:token,device:token".
+// Without it uploads are accepted unsigned.
+function deviceTokens(): Map<string, string> | null {
+  const raw = process.env.UPLOAD_DEVICE_TOKENS
+  if (!raw) {
+    return null
+  }
+  const tokens = new Map<string, string>()
+  for (const entry of raw.split(',')) {
+    const split = entry.indexOf(':')
+    if (split > 0) {
+      tokens.set(entry.slice(0, split).trim(), entry.slice(split + 1).trim())
+    }
+  }
+  return tokens
+}
+
+function safeEqual(a: string, b: string) {
+  const left = Buffer.from(a)
+  const right = Buffer.from(b)
+  return left.length === right.length && timingSafeEqual(left, right)
+}
+
+// verifyUpload returns an error message, or null if the upload may be stored
+export function verifyUpload(
+  headers: Headers,
+  sessionId: string,
+  timestamps: string[],
+  images: Buffer[]
+): string | null {
+  const tokens = deviceTokens()
+  if (!tokens) {
+    return null
+  }
+  const result = verifySigned(tokens, headers, sessionId, timestamps, images)
+  return 'error' in result ? result.error : null
+}
+
+// verifyDeviceRequest checks a signed request that is not an upload and
+// returns the device that signed it. Unlike uploads it is never accepted
+// unsigned, so it fails when UPLOAD_DEVICE_TOKENS is not set.
+export function verifyDeviceRequest(
+  headers: Headers,
+  method: string,
+  path: string,
+  body: string
+): { device: string } | { error: string } {
+  const tokens = deviceTokens()
+  if (!tokens) {
+    return { error: 'Device signatures are not configured (UPLOAD_DEVICE_TOKENS)' }
+  }
+  return verifySigned(tokens, headers, `${method} ${path}`, [], [Buffer.from(body)])
+}
+
+// verifyDeviceRequestIfConfigured is verifyDeviceRequest for endpoints that,
+// like uploads, stay open until UPLOAD_DEVICE_TOKENS is set. The device is
+// null when the request was accepted unsigned.
+export function verifyDeviceRequestIfConfigured(
+  headers: Headers,
+  method: string,
+  path: string,
+  body: string
+): { device: string | null } | { error: string } {
+  if (!deviceTokens()) {
+    return { device: null }
+  }
+  return verifyDeviceRequest(headers, method, path, body)
+}
+
+function verifySigned(
+  tokens: Map<string, string>,
+  headers: Headers,
+  sessionId: string,
+  timestamps: string[],
+  contents: Buffer[]
+): { device: string } | { error: string } {
+  const device = headers.get('x-infogen-device') || ''
+  const signedAt = headers.get('x-infogen-signed-at') || ''
+  const nonce = headers.get('x-infogen-nonce') || ''
+  const digest = (headers.get('x-infogen-content-sha256') || '').toLowerCase()
+  const signature = (headers.get('x-infogen-signature') || '').toLowerCase()
+
+  const token = tokens.get(device)
+  if (!token) {
+    return { error: 'Unknown device' }
+  }
+
+  const now = Math.floor(Date.now() / 1000)
+  const signedAtSeconds = Number.parseInt(signedAt, 10)
+  if (!Number.isFinite(signedAtSeconds) || Math.abs(now - signedAtSeconds) > WINDOW_SECONDS) {
+    return { error: 'Signature expired' }
+  }
+
+  const digests = contents.map(content => createHash('sha256').update(content).digest('hex'))
+  if (!safeEqual(digests.join(','), digest)) {
+    return { error: 'Content digest mismatch' }
+  }
+
+  const expected = createHmac('sha256', token)
+    .update([SIGNATURE_VERSION, device, sessionId, timestamps.join(','), signedAt, nonce, digest].join('\n'))
+    .digest('hex')
+  if (!safeEqual(expected, signature)) {
+    return { error: 'Invalid signature' }
+  }
+
+  for (const [key, seen] of seenNonces) {
+    if (now - seen > 2 * WINDOW_SECONDS) {
+      seenNonces.delete(key)
+    }
+  }
+  const nonceKey = `${device}:${nonce}`
+  if (!nonce || seenNonces.has(nonceKey)) {
+    return { error: 'Nonce already used' }
+  }
+  seenNonces.set(nonceKey, now)
+
+  return { device }
+}
@@ -1,12 +1,32 @@
 import { NextRequest, NextResponse } from 'next/server'
 import { put, head } from '@vercel/blob'
+import { verifyDeviceRequestIfConfigured } from '../screenshots/verify-upload'
 
 // In-memory cache (will be populated from blob storage)
 let studentNames: { [sessionId: string]: string } = {}
 let sessionStatus: { [sessionId: string]: 'active' | 'completed' } = {}
 let sessionSummaries: { [sessionId: string]: string } = {}
+let sessionDetails: { [sessionId: string]: SessionDetails } = {}
 let dataLoaded = false
 
+// Details reported by the Go application as a session runs
+interface SessionDetails {
+  description?: string
+  startTime?: string
+  endTime?: string
+  activeSeconds?: number
+  deviceId?: string // Where remote commands for the session are sent
+  timelapse?: {
+    file: string
+    url?: string // Set when -sync has uploaded the video
+    format: string
+    fps: number
+    frames: number
+    lengthSeconds: number
+    coveredSeconds: number
+  }
+}
+
 // Load data from blob storage
 async function loadData() {
   if (dataLoaded || !process.env.BLOB_READ_WRITE_TOKEN) return
@@ -30,6 +50,7 @@ async function loadData() {
         studentNames = data.names || {}
         sessionStatus = data.status || {}
         sessionSummaries = data.summaries || {}
+        sessionDetails = data.details || {}
         console.log('Loaded session data:', Object.keys(studentNames).length, 'sessions', Object.keys(sessionStatus).length, 'statuses')
       }
     } else {
@@ -51,6 +72,7 @@ async function saveData() {
       names: studentNames,
       status: sessionStatus,
       summaries: sessionSummaries,
+      details: sessionDetails,
       lastUpdated: new Date().toISOString()
     }
 
@@ -74,11 +96,34 @@ async function saveData() {
   }
 }
 
+// The Go application signs its updates once it has a device token. The
+// dashboard and generate-summary post here unsigned to rename students and
+// store summaries, so unsigned updates are still taken, but the deviceId
+// they carry is ignored: it decides where remote commands go, so only a
+// signed update may set it, and only to the device that signed it.
 export async function POST(request: NextRequest) {
   try {
+    const body = await request.text()
+    let signer: string | null = null
+    if (request.headers.get('x-infogen-signature')) {
+      const verified = verifyDeviceRequestIfConfigured(request.headers, 'POST', request.nextUrl.pathname, body)
+      if ('error' in verified) {
+        console.warn('Rejected session update:', verified.error)
+        return NextResponse.json({ error: verified.error }, { status: 401 })
+      }
+      signer = verified.device
+    }
+
     await loadData() // Ensure data is loaded first
 
-    const { sessionId, studentName, status, summary } = await request.json()
+    const {
+      sessionId, studentName, status, summary,
+      description, startTime, endTime, activeSeconds, timelapse, deviceId
+    } = JSON.parse(body)
+
+    if (signer !== null && deviceId && deviceId !== signer) {
+      return NextResponse.json({ error: 'Signed by a different device' }, { status: 403 })
+    }
 
     if (!sessionId) {
       return NextResponse.json(
@@ -107,6 +152,19 @@ export async function POST(request: NextRequest) {
       dataChanged = true
     }
 
+    const details: SessionDetails = {}
+    if (description) details.description = description
+    if (startTime) details.startTime = startTime
+    if (endTime) details.endTime = endTime
+    if (activeSeconds) details.activeSeconds = activeSeconds
+    if (timelapse) details.timelapse = timelapse
+    if (deviceId && deviceId === signer) details.deviceId = deviceId
+    if (Object.keys(details).length > 0) {
+      sessionDetails[sessionId] = { ...sessionDetails[sessionId], ...details }
+      console.log('Updated session details:', sessionId, '→', Object.keys(details).join(', '))
+      dataChanged = true
+    }
+
     if (dataChanged) {
       await saveData() // Persist changes to blob storage
     }
@@ -127,6 +185,7 @@ export async function GET() {
   return NextResponse.json({
     names: studentNames,
     status: sessionStatus,
-    summaries: sessionSummaries
+    summaries: sessionSummaries,
+    details: sessionDetails
   })
 }
\ No newline at end of file
@@ -0,0 +1,51 @@
+import { NextRequest, NextResponse } from 'next/server'
+import { put } from '@vercel/blob'
+import { verifyUpload } from '../screenshots/verify-upload'
+
+// Timelapse videos uploaded by the Go application's -sync. The URL returned
+// is then reported with the session's timelapse details.
+export async function POST(request: NextRequest) {
+  try {
+    const formData = await request.formData()
+    const file = formData.get('timelapse') as File | null
+    const timestamp = formData.get('timestamp') as string
+    const sessionId = formData.get('sessionId') as string
+
+    if (!file || !sessionId) {
+      return NextResponse.json(
+        { error: 'Missing required fields' },
+        { status: 400 }
+      )
+    }
+
+    // Signed like screenshots, with the session end as the timestamp
+    const video = Buffer.from(await file.arrayBuffer())
+    const authError = verifyUpload(request.headers, sessionId, timestamp ? [timestamp] : [], [video])
+    if (authError) {
+      console.warn('Rejected timelapse upload:', authError)
+      return NextResponse.json({ error: authError }, { status: 401 })
+    }
+
+    if (!process.env.BLOB_READ_WRITE_TOKEN) {
+      console.error('BLOB_READ_WRITE_TOKEN not found')
+      return NextResponse.json(
+        { error: 'Storage not configured' },
+        { status: 500 }
+      )
+    }
+
+    const blob = await put(`timelapses/${sessionId}/${file.name}`, video, {
+      access: 'public',
+      token: process.env.BLOB_READ_WRITE_TOKEN,
+    })
+    console.log('Timelapse uploaded:', { sessionId, blobUrl: blob.url, fileSize: file.size })
+
+    return NextResponse.json({ success: true, url: blob.url })
+  } catch (error) {
+    console.error('Error uploading timelapse:', error)
+    return NextResponse.json(
+      { error: 'Failed to upload timelapse' },
+      { status: 500 }
+    )
+  }
+}
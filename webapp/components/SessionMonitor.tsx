@@ -15,10 +15,45 @@ interface Session {
   status: 'active' | 'completed'
   screenshots: string[]
   summary?: string
+  description?: string
+  endTime?: string
+  activeSeconds?: number
+  deviceId?: string
+  timelapse?: {
+    file: string
+    url?: string
+    frames: number
+    lengthSeconds: number
+  }
+}
+
+// Latest heartbeat from a machine, see /api/heartbeat
+interface Device {
+  deviceId: string
+  deviceUuid: string
+  hostname: string
+  state: string
+  receivedAt: string
+  intervalSeconds: number
+  sessionId?: string
+  screenshots: number
+  lastCaptureError?: string
+  lastCaptureErrorAt?: string
+  uploadsPending: number
+  uploadsFailed: number
+  diskFreeBytes: number
+  ffmpeg: boolean
 }
 
+// A device that missed three heartbeats without saying it stopped has
+// probably died
+const isStale = (device: Device) =>
+  device.state !== 'stopped' &&
+  Date.now() - new Date(device.receivedAt).getTime() > 3 * device.intervalSeconds * 1000
+
 export default function SessionMonitor() {
   const [sessions, setSessions] = useState<Session[]>([])
+  const [devices, setDevices] = useState<Device[]>([])
   const [selectedSession, setSelectedSession] = useState<Session | null>(null)
   const [screenshots, setScreenshots] = useState<Screenshot[]>([])
   const [viewingScreenshots, setViewingScreenshots] = useState<string | null>(null)
@@ -39,6 +74,13 @@ export default function SessionMonitor() {
     } catch (error) {
       console.error('Failed to fetch sessions:', error)
     }
+
+    try {
+      const response = await fetch('/api/heartbeat')
+      setDevices((await response.json()) || [])
+    } catch (error) {
+      console.error('Failed to fetch devices:', error)
+    }
   }
 
   const handleGenerateSummary = async (session: Session) => {
@@ -96,6 +138,47 @@ export default function SessionMonitor() {
     }
   }
 
+  // Queue a command for the machine running the session; it is applied
+  // the next time the Go application polls. Queuing needs the dashboard
+  // token, asked for once and kept in this browser.
+  const sendCommand = async (session: Session, type: string, extra: Record<string, unknown> = {}) => {
+    let token = localStorage.getItem('dashboardToken')
+    if (!token) {
+      token = prompt('Dashboard token:')
+      if (!token) {
+        return
+      }
+    }
+
+    try {
+      const response = await fetch('/api/commands', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json', Authorization: `Bearer ${token}` },
+        body: JSON.stringify({ deviceId: session.deviceId, type, ...extra }),
+      })
+      if (response.status === 401) {
+        localStorage.removeItem('dashboardToken')
+      } else if (response.ok) {
+        localStorage.setItem('dashboardToken', token)
+      }
+      if (!response.ok) {
+        const { error } = await response.json()
+        alert(`Failed to send command: ${error}`)
+      }
+    } catch (error) {
+      console.error('Failed to send command:', error)
+      alert('Failed to send command')
+    }
+  }
+
+  const changeInterval = (session: Session) => {
+    const value = prompt('Seconds between screenshots:', '30')
+    const interval = value ? parseInt(value, 10) : NaN
+    if (interval >= 1) {
+      sendCommand(session, 'interval', { interval })
+    }
+  }
+
   const generateTimelapseGif = async (session: Session) => {
     setGeneratingGif(session.id)
 
@@ -187,6 +270,41 @@ export default function SessionMonitor() {
 
   return (
     <div className="space-y-6">
+      {/* Device health */}
+      {devices.length > 0 && (
+        <div className="bg-white rounded-lg shadow p-6">
+          <h2 className="text-lg font-semibold text-gray-900 mb-4">
+            🖥️ Devices ({devices.length})
+          </h2>
+          <div className="space-y-2">
+            {devices.map((device) => (
+              <div key={device.deviceUuid} className="flex items-center justify-between border rounded-lg p-3 text-sm">
+                <div>
+                  <span className="font-medium">{device.deviceId}</span>{' '}
+                  <span className="text-gray-500">({device.hostname})</span>
+                  <p className="text-gray-500">
+                    Last heartbeat: {new Date(device.receivedAt).toLocaleString()}
+                    {device.sessionId && <> · Session {device.sessionId}: {device.screenshots} screenshots</>}
+                    {' '}· Uploads waiting: {device.uploadsPending}
+                    {device.uploadsFailed > 0 && <>, failed: {device.uploadsFailed}</>}
+                    {' '}· Disk free: {(device.diskFreeBytes / 2 ** 30).toFixed(1)} GB
+                    {!device.ffmpeg && <> · No FFmpeg</>}
+                  </p>
+                  {device.lastCaptureError && (
+                    <p className="text-red-600">
+                      Capture error at {new Date(device.lastCaptureErrorAt!).toLocaleString()}: {device.lastCaptureError}
+                    </p>
+                  )}
+                </div>
+                <span className={`px-2 py-1 rounded text-white ${isStale(device) ? 'bg-red-600' : device.state === 'stopped' ? 'bg-gray-500' : 'bg-green-600'}`}>
+                  {isStale(device) ? 'not responding' : device.state}
+                </span>
+              </div>
+            ))}
+          </div>
+        </div>
+      )}
+
       {/* Active Sessions */}
       <div className="bg-white rounded-lg shadow p-6">
         <h2 className="text-lg font-semibold text-gray-900 mb-4">
@@ -274,13 +392,65 @@ export default function SessionMonitor() {
                     </h3>
                     <p className="text-sm text-gray-500">
                       Started: {new Date(session.startTime).toLocaleString()}
+                      {session.endTime && <> · Ended: {new Date(session.endTime).toLocaleString()}</>}
+                      {session.activeSeconds !== undefined && <> · Active: {Math.round(session.activeSeconds / 60)} min</>}
                     </p>
+                    {session.description && (
+                      <p className="text-sm text-gray-500">{session.description}</p>
+                    )}
                     <p className="text-sm text-gray-500">
                       Screenshots: {session.screenshots.length}
                     </p>
+                    {session.timelapse && (
+                      <p className="text-sm text-gray-500">
+                        {session.timelapse.url ? (
+                          <a href={session.timelapse.url} className="text-blue-600 hover:underline" target="_blank" rel="noreferrer">
+                            Timelapse: {session.timelapse.file}
+                          </a>
+                        ) : (
+                          <>Local timelapse: {session.timelapse.file}</>
+                        )}{' '}
+                        ({session.timelapse.frames} frames, {session.timelapse.lengthSeconds.toFixed(1)}s)
+                      </p>
+                    )}
                   </div>
 
                   <div className="flex space-x-2">
+                    {session.status === 'active' && session.deviceId && (
+                      <>
+                        <button
+                          onClick={() => sendCommand(session, 'pause')}
+                          className="px-3 py-2 bg-yellow-500 text-white rounded-md hover:bg-yellow-600"
+                        >
+                          Pause
+                        </button>
+                        <button
+                          onClick={() => sendCommand(session, 'resume')}
+                          className="px-3 py-2 bg-yellow-500 text-white rounded-md hover:bg-yellow-600"
+                        >
+                          Resume
+                        </button>
+                        <button
+                          onClick={() => sendCommand(session, 'capture_now')}
+                          className="px-3 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700"
+                        >
+                          Capture Now
+                        </button>
+                        <button
+                          onClick={() => changeInterval(session)}
+                          className="px-3 py-2 bg-blue-600 text-white rounded-md hover:bg-blue-700"
+                        >
+                          Interval
+                        </button>
+                        <button
+                          onClick={() => confirm('Stop this session and generate its summary?') && sendCommand(session, 'stop')}
+                          className="px-3 py-2 bg-orange-600 text-white rounded-md hover:bg-orange-700"
+                        >
+                          Stop
+                        </button>
+                      </>
+                    )}
+
                     <button
                       onClick={() => setViewingScreenshots(session.id)}
                       className="px-3 py-2 bg-gray-600 text-white rounded-md hover:bg-gray-700"
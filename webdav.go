@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// webdavStore writes to a WebDAV collection, as offered by most NAS boxes
+// and Nextcloud, creating folders with MKCOL as needed
+type webdavStore struct {
+	baseURL  string
+	prefix   string
+	username string
+	password string
+	client   *http.Client
+	limiter  *byteLimiter
+
+	mu      sync.Mutex
+	created map[string]bool // Collections known to exist
+}
+
+func newWebDAVStore(s SinkSettings, client *http.Client, limiter *byteLimiter) *webdavStore {
+	return &webdavStore{
+		baseURL:  strings.TrimRight(s.URL, "/"),
+		prefix:   strings.Trim(s.Prefix, "/"),
+		username: s.Username,
+		password: s.Password,
+		client:   client,
+		limiter:  limiter,
+		created:  make(map[string]bool),
+	}
+}
+
+func (w *webdavStore) put(key string, data []byte, contentType string) error {
+	if err := w.makeCollections(path.Dir(path.Join(w.prefix, key))); err != nil {
+		return err
+	}
+	req, err := w.request("PUT", key, data)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	_, err = sendRequest(w.client, w.limiter, req)
+	return err
+}
+
+func (w *webdavStore) get(key string) ([]byte, error) {
+	req, err := w.request("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := sendRequest(w.client, nil, req)
+	var uploadErr *uploadError
+	if errors.As(err, &uploadErr) && uploadErr.status == http.StatusNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (w *webdavStore) location(key string) string {
+	return w.url(path.Join(w.prefix, key))
+}
+
+func (w *webdavStore) url(p string) string {
+	return w.baseURL + "/" + s3Escape(p)
+}
+
+func (w *webdavStore) request(method, key string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, w.location(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if w.username != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	return req, nil
+}
+
+// makeCollections creates dir and its parents below the base URL. Servers
+// answer 405 for a collection that already exists.
+func (w *webdavStore) makeCollections(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if dir == "." || dir == "" || w.created[dir] {
+		return nil
+	}
+	var current string
+	for _, segment := range strings.Split(dir, "/") {
+		current = path.Join(current, segment)
+		if w.created[current] {
+			continue
+		}
+		req, err := http.NewRequest("MKCOL", w.url(current)+"/", nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		if w.username != "" {
+			req.SetBasicAuth(w.username, w.password)
+		}
+		_, err = sendRequest(w.client, nil, req)
+		var uploadErr *uploadError
+		if err != nil && !(errors.As(err, &uploadErr) && uploadErr.status == http.StatusMethodNotAllowed) {
+			return fmt.Errorf("failed to create folder %s: %w", current, err)
+		}
+		w.created[current] = true
+	}
+	return nil
+}
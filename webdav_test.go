@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// davServer records the requests it gets and answers MKCOL for folders
+// in existing with 405, as WebDAV servers do for a collection that is
+// already there
+func davServer(t *testing.T, existing ...string) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		mu.Unlock()
+
+		if user, password, ok := r.BasicAuth(); !ok || user != "ada" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "MKCOL" {
+			for _, dir := range existing {
+				if r.URL.Path == dir {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), requests...)
+	}
+}
+
+func newTestWebDAVStore(url string, client *http.Client) *webdavStore {
+	return newWebDAVStore(SinkSettings{
+		URL:      url + "/dav/",
+		Prefix:   "infogenerator",
+		Username: "ada",
+		Password: "secret",
+	}, client, nil)
+}
+
+func TestWebDAVCreatesFolders(t *testing.T) {
+	server, requests := davServer(t)
+	store := newTestWebDAVStore(server.URL, server.Client())
+
+	if err := store.put("sessions/s1/shot.jpg", []byte("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err := store.put("sessions/s1/other.jpg", []byte("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("second put: %v", err)
+	}
+
+	want := []string{
+		"MKCOL /dav/infogenerator/",
+		"MKCOL /dav/infogenerator/sessions/",
+		"MKCOL /dav/infogenerator/sessions/s1/",
+		"PUT /dav/infogenerator/sessions/s1/shot.jpg",
+		"PUT /dav/infogenerator/sessions/s1/other.jpg",
+	}
+	if got := requests(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWebDAVExistingFolders(t *testing.T) {
+	server, requests := davServer(t, "/dav/infogenerator/", "/dav/infogenerator/sessions/")
+	store := newTestWebDAVStore(server.URL, server.Client())
+
+	if err := store.put("sessions/s1/shot.jpg", []byte("jpeg"), "image/jpeg"); err != nil {
+		t.Fatalf("put with existing folders: %v", err)
+	}
+	if got := requests(); len(got) != 4 || got[3] != "PUT /dav/infogenerator/sessions/s1/shot.jpg" {
+		t.Errorf("requests = %q", got)
+	}
+}
+
+func TestWebDAVFolderError(t *testing.T) {
+	server, _ := davServer(t)
+	store := newTestWebDAVStore(server.URL, server.Client())
+	store.password = "wrong"
+
+	err := store.put("sessions/s1/shot.jpg", []byte("jpeg"), "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "failed to create folder infogenerator") {
+		t.Fatalf("put = %v, want the MKCOL failure", err)
+	}
+	if len(store.created) != 0 {
+		t.Errorf("folders recorded as created after a failure: %v", store.created)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+// WindowInfo describes the foreground application at capture time
+type WindowInfo struct {
+	Title string
+	Class string // WM_CLASS class name, e.g. "firefox" or "Code"
+	PID   int
+}
+
+// WindowInspector looks up the currently focused window. Implementations
+// return an error when no window system is reachable; capture carries on
+// without metadata in that case.
+type WindowInspector interface {
+	ActiveWindow() (*WindowInfo, error)
+	Close()
+}
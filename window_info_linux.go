@@ -0,0 +1,136 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+// x11Inspector reads EWMH properties from the X server named by display
+// (or $DISPLAY when empty). The connection is opened lazily and re-opened
+// after failures, at most once per reconnectDelay.
+type x11Inspector struct {
+	display    string
+	conn       *xgb.Conn
+	root       xproto.Window
+	atoms      map[string]xproto.Atom
+	lastFailed time.Time
+}
+
+const reconnectDelay = time.Minute
+
+func NewWindowInspector(display string) WindowInspector {
+	return &x11Inspector{display: display}
+}
+
+func (xi *x11Inspector) connect() error {
+	if xi.conn != nil {
+		return nil
+	}
+	if xi.display == "" && os.Getenv("DISPLAY") == "" {
+		return fmt.Errorf("no X server: DISPLAY is not set and x_display is empty")
+	}
+	if !xi.lastFailed.IsZero() && time.Since(xi.lastFailed) < reconnectDelay {
+		return fmt.Errorf("X server unavailable")
+	}
+
+	conn, err := xgb.NewConnDisplay(xi.display)
+	if err != nil {
+		xi.lastFailed = time.Now()
+		return fmt.Errorf("failed to connect to X server: %w", err)
+	}
+
+	xi.conn = conn
+	xi.root = xproto.Setup(conn).DefaultScreen(conn).Root
+	xi.atoms = make(map[string]xproto.Atom)
+	xi.lastFailed = time.Time{}
+	return nil
+}
+
+func (xi *x11Inspector) ActiveWindow() (*WindowInfo, error) {
+	if err := xi.connect(); err != nil {
+		return nil, err
+	}
+
+	info, err := xi.activeWindow()
+	if err != nil {
+		// Drop the connection so the next capture reconnects
+		xi.Close()
+		xi.lastFailed = time.Now()
+		return nil, err
+	}
+	return info, nil
+}
+
+func (xi *x11Inspector) activeWindow() (*WindowInfo, error) {
+	value, err := xi.property(xi.root, "_NET_ACTIVE_WINDOW")
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < 4 {
+		return nil, fmt.Errorf("window manager does not report an active window")
+	}
+	window := xproto.Window(xgb.Get32(value))
+	if window == 0 {
+		return nil, fmt.Errorf("no window has focus")
+	}
+
+	info := &WindowInfo{}
+
+	if title, err := xi.property(window, "_NET_WM_NAME"); err == nil && len(title) > 0 {
+		info.Title = string(title)
+	} else if title, err := xi.property(window, "WM_NAME"); err == nil {
+		info.Title = string(title)
+	}
+
+	// WM_CLASS holds "instance\0class\0"
+	if class, err := xi.property(window, "WM_CLASS"); err == nil {
+		parts := strings.Split(strings.TrimRight(string(class), "\x00"), "\x00")
+		info.Class = parts[len(parts)-1]
+	}
+
+	if pid, err := xi.property(window, "_NET_WM_PID"); err == nil && len(pid) >= 4 {
+		info.PID = int(xgb.Get32(pid))
+	}
+
+	return info, nil
+}
+
+func (xi *x11Inspector) property(window xproto.Window, name string) ([]byte, error) {
+	atom, err := xi.atom(name)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := xproto.GetProperty(xi.conn, false, window, atom, xproto.GetPropertyTypeAny, 0, 1024).Reply()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return reply.Value, nil
+}
+
+func (xi *x11Inspector) atom(name string) (xproto.Atom, error) {
+	if atom, ok := xi.atoms[name]; ok {
+		return atom, nil
+	}
+
+	reply, err := xproto.InternAtom(xi.conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		return 0, fmt.Errorf("failed to intern atom %s: %w", name, err)
+	}
+	xi.atoms[name] = reply.Atom
+	return reply.Atom, nil
+}
+
+func (xi *x11Inspector) Close() {
+	if xi.conn != nil {
+		xi.conn.Close()
+		xi.conn = nil
+	}
+}
@@ -0,0 +1,133 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jezek/xgb"
+	"github.com/jezek/xgb/xproto"
+)
+
+func TestWindowInspectorWithoutDisplay(t *testing.T) {
+	t.Setenv("DISPLAY", "")
+
+	inspector := NewWindowInspector("")
+	defer inspector.Close()
+
+	info, err := inspector.ActiveWindow()
+	if err == nil {
+		t.Fatalf("ActiveWindow without an X server returned %+v and no error", info)
+	}
+}
+
+// startXvfb runs a private Xvfb server for the test and returns its
+// display name, skipping the test when Xvfb isn't installed
+func startXvfb(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("Xvfb"); err != nil {
+		t.Skip("Xvfb not installed")
+	}
+
+	for n := 99; n < 120; n++ {
+		if _, err := os.Stat(filepath.Join("/tmp/.X11-unix", fmt.Sprintf("X%d", n))); err == nil {
+			continue // Display in use
+		}
+		display := fmt.Sprintf(":%d", n)
+		cmd := exec.Command("Xvfb", display, "-screen", "0", "640x480x24", "-nolisten", "tcp")
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("failed to start Xvfb: %v", err)
+		}
+		t.Cleanup(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+		})
+
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if conn, err := xgb.NewConnDisplay(display); err == nil {
+				conn.Close()
+				return display
+			}
+		}
+		t.Fatalf("Xvfb on %s did not start", display)
+	}
+	t.Skip("no free X display number")
+	return ""
+}
+
+// setProperty sets a property on window the way a client and its window
+// manager would
+func setProperty(t *testing.T, conn *xgb.Conn, window xproto.Window, name string, typ xproto.Atom, format byte, data []byte) {
+	t.Helper()
+	atom, err := xproto.InternAtom(conn, false, uint16(len(name)), name).Reply()
+	if err != nil {
+		t.Fatalf("failed to intern %s: %v", name, err)
+	}
+	units := uint32(len(data))
+	if format == 32 {
+		units /= 4
+	}
+	if err := xproto.ChangePropertyChecked(conn, xproto.PropModeReplace, window, atom.Atom, typ, format, units, data).Check(); err != nil {
+		t.Fatalf("failed to set %s: %v", name, err)
+	}
+}
+
+func TestWindowInspectorXvfb(t *testing.T) {
+	display := startXvfb(t)
+
+	conn, err := xgb.NewConnDisplay(display)
+	if err != nil {
+		t.Fatalf("failed to connect to Xvfb: %v", err)
+	}
+	defer conn.Close()
+	root := xproto.Setup(conn).DefaultScreen(conn).Root
+
+	inspector := NewWindowInspector(display)
+	defer inspector.Close()
+
+	// Xvfb runs no window manager, so nothing reports an active window yet
+	if info, err := inspector.ActiveWindow(); err == nil {
+		t.Fatalf("ActiveWindow without a window manager returned %+v and no error", info)
+	}
+
+	window, err := xproto.NewWindowId(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := xproto.CreateWindowChecked(conn, 0, window, root, 0, 0, 100, 100, 0,
+		xproto.WindowClassInputOutput, 0, 0, nil).Check(); err != nil {
+		t.Fatalf("failed to create window: %v", err)
+	}
+
+	utf8, err := xproto.InternAtom(conn, false, uint16(len("UTF8_STRING")), "UTF8_STRING").Reply()
+	if err != nil {
+		t.Fatal(err)
+	}
+	setProperty(t, conn, window, "_NET_WM_NAME", utf8.Atom, 8, []byte("Lesson notes – Editor"))
+	setProperty(t, conn, window, "WM_CLASS", xproto.AtomString, 8, []byte("editor\x00Editor\x00"))
+	pid := make([]byte, 4)
+	xgb.Put32(pid, 4242)
+	setProperty(t, conn, window, "_NET_WM_PID", xproto.AtomCardinal, 32, pid)
+
+	// Play the window manager's part
+	active := make([]byte, 4)
+	xgb.Put32(active, uint32(window))
+	setProperty(t, conn, root, "_NET_ACTIVE_WINDOW", xproto.AtomWindow, 32, active)
+
+	// The failed lookup above holds off reconnecting for a while
+	inspector.(*x11Inspector).lastFailed = time.Time{}
+
+	info, err := inspector.ActiveWindow()
+	if err != nil {
+		t.Fatalf("ActiveWindow: %v", err)
+	}
+	want := WindowInfo{Title: "Lesson notes – Editor", Class: "Editor", PID: 4242}
+	if *info != want {
+		t.Errorf("ActiveWindow = %+v, want %+v", *info, want)
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// noWindowInspector is used where foreground window lookup is not
+// implemented yet
+type noWindowInspector struct{}
+
+func NewWindowInspector(display string) WindowInspector {
+	return noWindowInspector{}
+}
+
+func (noWindowInspector) ActiveWindow() (*WindowInfo, error) {
+	return nil, fmt.Errorf("foreground window lookup is only supported on X11")
+}
+
+func (noWindowInspector) Close() {}